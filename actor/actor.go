@@ -13,9 +13,13 @@ package actor // import "tideland.dev/go/stew/actor"
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -26,8 +30,67 @@ const (
 	// defaultQueueCap is the minimum and default capacity
 	// of the async actions queue.
 	defaultQueueCap = 256
+
+	// defaultUrgentCap is the capacity of the urgent actions queue.
+	defaultUrgentCap = 64
+
+	// defaultOverflowCap is the default capacity of the overflow
+	// buffer when using OverflowSpill.
+	defaultOverflowCap = 4096
 )
 
+//--------------------
+// ERRORS
+//--------------------
+
+var (
+	// ErrQueueFull is returned when an action cannot be queued
+	// due to the overflow policy of the Actor.
+	ErrQueueFull = errors.New("actor queue is full")
+
+	// ErrDropped is returned by synchronous calls whose action
+	// has been dropped due to the overflow policy of the Actor.
+	ErrDropped = errors.New("actor action dropped")
+)
+
+//--------------------
+// OVERFLOW POLICY
+//--------------------
+
+// OverflowPolicy defines how an Actor handles new asynchronous actions
+// when its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock lets the caller wait until the action can be
+	// queued. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest silently drops the new action.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest queued action to make
+	// room for the new one.
+	OverflowDropOldest
+
+	// OverflowReject returns ErrQueueFull to the caller.
+	OverflowReject
+
+	// OverflowSpill stores the action in a bounded overflow buffer.
+	// If this buffer is full too ErrQueueFull is returned.
+	OverflowSpill
+)
+
+// QueueStatus contains information about the queues of an Actor.
+type QueueStatus struct {
+	Len      int
+	Cap      int
+	Urgent   int
+	Overflow int
+	Dropped  uint64
+	Rejected uint64
+}
+
 //--------------------
 // HELPER
 //--------------------
@@ -70,6 +133,12 @@ func newRequest(ctx context.Context, action Action) *request {
 	}
 }
 
+// drop marks the request as dropped.
+func (req *request) drop() {
+	req.err = ErrDropped
+	close(req.done)
+}
+
 // execute checks if the request context is canceled or timed out.
 // If not, it performs the action and closes the done channel.
 func (req *request) execute() {
@@ -85,20 +154,33 @@ func (req *request) execute() {
 // Actor introduces the actor model, where call simply are executed
 // sequentially in a backend goroutine.
 type Actor struct {
-	ctx       context.Context
-	cancel    func()
-	requests  chan *request
-	recoverer Recoverer
-	finalizer Finalizer
-	err       atomic.Pointer[error]
-	done      chan struct{}
+	ctx         context.Context
+	cancel      func()
+	queueCap    int
+	policy      OverflowPolicy
+	overflowCap int
+	requests    chan *request
+	urgents     chan *request
+	mu          sync.Mutex
+	overflow    []*request
+	dropped     atomic.Uint64
+	rejected    atomic.Uint64
+	recoverer   Recoverer
+	finalizer   Finalizer
+	clock       wait.Clock
+	err         atomic.Pointer[error]
+	done        chan struct{}
 }
 
 // Go starts an Actor with the given options.
 func Go(options ...Option) (*Actor, error) {
 	// Init with options.
 	act := &Actor{
-		ctx: context.Background(),
+		ctx:         context.Background(),
+		queueCap:    defaultQueueCap,
+		policy:      OverflowBlock,
+		overflowCap: defaultOverflowCap,
+		clock:       wait.RealClock(),
 	}
 	for _, option := range options {
 		if err := option(act); err != nil {
@@ -107,9 +189,8 @@ func Go(options ...Option) (*Actor, error) {
 	}
 	// Ensure default settings.
 	act.ctx, act.cancel = context.WithCancel(act.ctx)
-	if act.requests == nil {
-		act.requests = make(chan *request, defaultQueueCap)
-	}
+	act.requests = make(chan *request, act.queueCap)
+	act.urgents = make(chan *request, defaultUrgentCap)
 	if act.recoverer == nil {
 		act.recoverer = func(reason any) error {
 			return fmt.Errorf("panic during actor action: %v", reason)
@@ -119,6 +200,7 @@ func Go(options ...Option) (*Actor, error) {
 		act.finalizer = func(err error) error { return err }
 	}
 	// Start the backend, wait for it to be ready.
+	act.done = make(chan struct{})
 	started := make(chan struct{})
 
 	go act.backend(started)
@@ -141,7 +223,23 @@ func (act *Actor) DoAsync(action Action) error {
 // when it's queued. A context allows to cancel the action or add a timeout.
 func (act *Actor) DoAsyncWithContext(ctx context.Context, action Action) error {
 	req := newRequest(ctx, action)
-	return act.send(req)
+	return act.send(act.requests, req)
+}
+
+// DoAsyncUrgent sends the actor function to the backend goroutine
+// and returns when it's queued. Urgent actions are executed before
+// all queued normal actions. They are not affected by the overflow
+// policy, a full urgent queue blocks the caller.
+func (act *Actor) DoAsyncUrgent(action Action) error {
+	return act.DoAsyncUrgentWithContext(context.Background(), action)
+}
+
+// DoAsyncUrgentWithContext sends the urgent actor function to the
+// backend and returns when it's queued. A context allows to cancel
+// the action or add a timeout.
+func (act *Actor) DoAsyncUrgentWithContext(ctx context.Context, action Action) error {
+	req := newRequest(ctx, action)
+	return act.send(act.urgents, req)
 }
 
 // DoSync executes the actor function and returns when it's done.
@@ -153,13 +251,53 @@ func (act *Actor) DoSync(action Action) error {
 // A context allows to cancel the action or add a timeout.
 func (act *Actor) DoSyncWithContext(ctx context.Context, action Action) error {
 	req := newRequest(ctx, action)
-	err := act.send(req)
+	err := act.send(act.requests, req)
+	if err != nil {
+		return err
+	}
+	return act.wait(req)
+}
+
+// DoSyncUrgent executes the actor function before all queued normal
+// actions and returns when it's done.
+func (act *Actor) DoSyncUrgent(action Action) error {
+	return act.DoSyncUrgentWithContext(context.Background(), action)
+}
+
+// DoSyncUrgentWithContext executes the urgent action and returns when
+// it's done. A context allows to cancel the action or add a timeout.
+func (act *Actor) DoSyncUrgentWithContext(ctx context.Context, action Action) error {
+	req := newRequest(ctx, action)
+	err := act.send(act.urgents, req)
 	if err != nil {
 		return err
 	}
 	return act.wait(req)
 }
 
+// QueueLen returns the number of queued normal actions including
+// those in the overflow buffer.
+func (act *Actor) QueueLen() int {
+	act.mu.Lock()
+	defer act.mu.Unlock()
+	return len(act.requests) + len(act.overflow)
+}
+
+// QueueStatus returns information about the queues of the Actor
+// for monitoring.
+func (act *Actor) QueueStatus() QueueStatus {
+	act.mu.Lock()
+	defer act.mu.Unlock()
+	return QueueStatus{
+		Len:      len(act.requests) + len(act.overflow),
+		Cap:      cap(act.requests),
+		Urgent:   len(act.urgents),
+		Overflow: len(act.overflow),
+		Dropped:  act.dropped.Load(),
+		Rejected: act.rejected.Load(),
+	}
+}
+
 // Done returns a channel that is closed when the Actor terminates.
 func (act *Actor) Done() <-chan struct{} {
 	return act.done
@@ -193,8 +331,8 @@ func (act *Actor) Stop() {
 	act.cancel()
 }
 
-// send sends a request to the backend.
-func (act *Actor) send(req *request) error {
+// send sends a request to the backend using the given queue.
+func (act *Actor) send(queue chan *request, req *request) error {
 	// Check if we're error free and still working.
 	if act.err.Load() != nil {
 		return *act.err.Load()
@@ -202,8 +340,62 @@ func (act *Actor) send(req *request) error {
 	if act.IsDone() {
 		return fmt.Errorf("actor is done")
 	}
+	// Handle the overflow policy for normal requests.
+	if queue == act.requests {
+		switch act.policy {
+		case OverflowDropNewest:
+			select {
+			case queue <- req:
+			default:
+				act.dropped.Add(1)
+				req.drop()
+			}
+			return nil
+		case OverflowDropOldest:
+			return act.sendDropOldest(req)
+		case OverflowReject:
+			select {
+			case queue <- req:
+				return nil
+			default:
+				act.rejected.Add(1)
+				return ErrQueueFull
+			}
+		case OverflowSpill:
+			return act.sendSpill(req)
+		}
+	}
 	// Send the request to the backend.
 	select {
+	case queue <- req:
+	case <-req.ctx.Done():
+		return fmt.Errorf("action context sending: %v", req.ctx.Err())
+	case <-act.ctx.Done():
+		return fmt.Errorf("actor context sending: %v", act.ctx.Err())
+	}
+	return nil
+}
+
+// sendLast queues the request behind all accepted ones regardless of
+// the overflow policy. A full queue blocks the caller.
+func (act *Actor) sendLast(req *request) error {
+	if act.err.Load() != nil {
+		return *act.err.Load()
+	}
+	if act.IsDone() {
+		return fmt.Errorf("actor is done")
+	}
+	// Spilled requests are queued before the new one.
+	if act.policy == OverflowSpill {
+		act.mu.Lock()
+		if len(act.overflow) > 0 {
+			act.overflow = append(act.overflow, req)
+			act.mu.Unlock()
+			return nil
+		}
+		act.mu.Unlock()
+	}
+	select {
 	case act.requests <- req:
 	case <-req.ctx.Done():
 		return fmt.Errorf("action context sending: %v", req.ctx.Err())
@@ -213,6 +405,67 @@ func (act *Actor) send(req *request) error {
 	return nil
 }
 
+// sendDropOldest queues the request, possibly dropping the
+// oldest queued one.
+func (act *Actor) sendDropOldest(req *request) error {
+	for {
+		select {
+		case act.requests <- req:
+			return nil
+		default:
+		}
+		select {
+		case old := <-act.requests:
+			act.dropped.Add(1)
+			old.drop()
+		default:
+		}
+	}
+}
+
+// sendSpill queues the request or stores it in the overflow
+// buffer if the queue is full.
+func (act *Actor) sendSpill(req *request) error {
+	act.mu.Lock()
+	defer act.mu.Unlock()
+	if len(act.overflow) == 0 {
+		select {
+		case act.requests <- req:
+			return nil
+		default:
+		}
+	}
+	if len(act.overflow) >= act.overflowCap {
+		act.rejected.Add(1)
+		return ErrQueueFull
+	}
+	act.overflow = append(act.overflow, req)
+	return nil
+}
+
+// refill moves requests from the overflow buffer into the queue
+// as long as there's room.
+func (act *Actor) refill() {
+	if act.policy != OverflowSpill {
+		return
+	}
+	act.mu.Lock()
+	defer act.mu.Unlock()
+	moved := 0
+	for _, req := range act.overflow {
+		select {
+		case act.requests <- req:
+			moved++
+			continue
+		default:
+		}
+		break
+	}
+	if moved > 0 {
+		act.overflow = append(act.overflow[:0], act.overflow[moved:]...)
+	}
+}
+
 // wait waits for synchronous requests to be done or returning an error.
 func (act *Actor) wait(req *request) error {
 	select {
@@ -230,8 +483,6 @@ func (act *Actor) backend(started chan struct{}) {
 	defer act.finalize()
 	close(started)
 
-	act.done = make(chan struct{})
-
 	// Work as long as we're not stopped.
 	for !act.IsDone() {
 		act.work()
@@ -251,13 +502,25 @@ func (act *Actor) work() {
 			}
 		}
 	}()
-	// Select in loop.
+	// Select in loop, urgent requests first.
 	for {
 		select {
 		case <-act.ctx.Done():
 			close(act.done)
 			return
+		case req := <-act.urgents:
+			req.execute()
+			continue
+		default:
+		}
+		select {
+		case <-act.ctx.Done():
+			close(act.done)
+			return
+		case req := <-act.urgents:
+			req.execute()
 		case req := <-act.requests:
+			act.refill()
 			req.execute()
 		}
 	}
@@ -276,4 +276,154 @@ func TestNotifierFail(t *testing.T) {
 	Assert(t, ErrorMatches(act.Err(), "ouch:.*"), "actor stopped with error")
 }
 
+// TestOverflowPolicies verifies the different overflow policies
+// of the action queue.
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   actor.OverflowPolicy
+		executed int
+		dropped  uint64
+		rejected uint64
+		first    int
+	}{
+		{"drop newest", actor.OverflowDropNewest, 256, 44, 0, 0},
+		{"drop oldest", actor.OverflowDropOldest, 256, 44, 0, 44},
+		{"reject", actor.OverflowReject, 256, 0, 44, 0},
+		{"spill", actor.OverflowSpill, 300, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Logf("test: %s", test.name)
+		act, err := actor.Go(actor.WithOverflowPolicy(test.policy))
+		Assert(t, NoError(err), "actor started")
+
+		// Block the actor to fill its queue.
+		gate := blockActor(t, act)
+
+		executed := []int{}
+		for i := 0; i < 300; i++ {
+			i := i
+			err = act.DoAsync(func() { executed = append(executed, i) })
+			if test.policy == actor.OverflowReject && i >= 256 {
+				Assert(t, True(errors.Is(err, actor.ErrQueueFull)), "queue is full")
+			} else {
+				Assert(t, NoError(err), "action queued")
+			}
+		}
+		status := act.QueueStatus()
+		Assert(t, Equal(status.Len, test.executed), "queue length")
+		Assert(t, Equal(status.Dropped, test.dropped), "dropped actions")
+		Assert(t, Equal(status.Rejected, test.rejected), "rejected actions")
+
+		close(gate)
+		waitProcessed(t, act)
+		Assert(t, Length(executed, test.executed), "executed actions")
+		Assert(t, Equal(executed[0], test.first), "first executed action")
+		Assert(t, Equal(act.QueueLen(), 0), "queue is empty")
+
+		act.Stop()
+	}
+}
+
+// TestOverflowSpillFull verifies the rejection of actions when the
+// overflow buffer is full too.
+func TestOverflowSpillFull(t *testing.T) {
+	act, err := actor.Go(
+		actor.WithOverflowPolicy(actor.OverflowSpill),
+		actor.WithOverflowCap(10),
+	)
+	Assert(t, NoError(err), "actor started")
+
+	gate := blockActor(t, act)
+
+	for i := 0; i < 266; i++ {
+		err = act.DoAsync(func() {})
+		Assert(t, NoError(err), "action queued")
+	}
+	err = act.DoAsync(func() {})
+	Assert(t, True(errors.Is(err, actor.ErrQueueFull)), "overflow buffer is full")
+	Assert(t, Equal(act.QueueStatus().Overflow, 10), "overflow buffer is used")
+
+	close(gate)
+	act.Stop()
+}
+
+// TestDroppedSync verifies that synchronous calls get an error if
+// their action is dropped.
+func TestDroppedSync(t *testing.T) {
+	act, err := actor.Go(actor.WithOverflowPolicy(actor.OverflowDropOldest))
+	Assert(t, NoError(err), "actor started")
+
+	gate := blockActor(t, act)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- act.DoSync(func() {})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 256; i++ {
+		err = act.DoAsync(func() {})
+		Assert(t, NoError(err), "action queued")
+	}
+	Assert(t, True(errors.Is(<-errs, actor.ErrDropped)), "synchronous action dropped")
+
+	close(gate)
+	act.Stop()
+}
+
+// TestUrgent verifies that urgent actions are executed before
+// queued normal actions.
+func TestUrgent(t *testing.T) {
+	act, err := actor.Go()
+	Assert(t, NoError(err), "actor started")
+
+	gate := blockActor(t, act)
+
+	order := []string{}
+	for i := 0; i < 5; i++ {
+		err = act.DoAsync(func() { order = append(order, "normal") })
+		Assert(t, NoError(err), "normal action queued")
+	}
+	err = act.DoAsyncUrgent(func() { order = append(order, "urgent") })
+	Assert(t, NoError(err), "urgent action queued")
+
+	close(gate)
+	waitProcessed(t, act)
+	Assert(t, Length(order, 6), "all actions executed")
+	Assert(t, Equal(order[0], "urgent"), "urgent action first")
+
+	err = act.DoSyncUrgent(func() { order = append(order, "urgent") })
+	Assert(t, NoError(err), "urgent synchronous action done")
+	Assert(t, Length(order, 7), "urgent synchronous action executed")
+
+	act.Stop()
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// blockActor lets the Actor execute an action blocking until the
+// returned gate is closed.
+func blockActor(t *testing.T, act *actor.Actor) chan struct{} {
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	err := act.DoAsync(func() {
+		close(started)
+		<-gate
+	})
+	Assert(t, NoError(err), "blocking action queued")
+	<-started
+	return gate
+}
+
+// waitProcessed waits until the queue of the Actor is processed.
+func waitProcessed(t *testing.T, act *actor.Actor) {
+	for act.QueueLen() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	err := act.DoSync(func() {})
+	Assert(t, NoError(err), "queue processed")
+}
+
 // EOF
@@ -55,6 +55,26 @@
 // The options for the constructor allow to pass a context for the Actor, the capacity
 // of the Action queue, a recoverer function in case of an Action panic and a finalizer
 // function when the Actor stops.
+//
+// An OverflowPolicy defines what happens when the queue is full. By default the caller
+// blocks, but actions can also be dropped, rejected with ErrQueueFull, or spilled into
+// a bounded overflow buffer. Urgent actions sent with DoAsyncUrgent or DoSyncUrgent are
+// executed before all queued normal actions. QueueStatus returns the queue lengths and
+// drop counters for monitoring.
+//
+// A Registry manages Actors by names and removes them automatically when they are
+// done. Registered Actors can subscribe to topics. Messages published to a topic are
+// passed to the receivers of the subscribers, broadcasted actions are executed by them.
+//
+// A TypedActor owns a state and processes typed messages through a Handler returning
+// the new state. Its state can be inspected with Snapshot, Drain stops it gracefully
+// after all queued messages are processed. A Harness feeds messages step by step into
+// a Handler for testing.
+//
+// A Pool started with GoPool distributes actions to a number of Actors using the
+// strategies RoundRobin, LeastLoaded, or ConsistentHash by a key. It grows and shrinks
+// within configured bounds depending on the queue pressure. If one of its Actors fails
+// the Pool stops and Err returns the error.
 package actor // import "tideland.dev/go/stew/actor"
 
 // EOF
@@ -13,6 +13,9 @@ package actor // import "tideland.dev/go/stew/actor"
 
 import (
 	"context"
+	"fmt"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -36,7 +39,43 @@ func WithQueueCap(c int) Option {
 		if c < defaultQueueCap {
 			c = defaultQueueCap
 		}
-		act.requests = make(chan *request, c)
+		act.queueCap = c
+		return nil
+	}
+}
+
+// WithOverflowPolicy defines how the Actor handles asynchronous
+// actions when its queue is full.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(act *Actor) error {
+		if policy < OverflowBlock || policy > OverflowSpill {
+			return fmt.Errorf("invalid actor option: unknown overflow policy %d", policy)
+		}
+		act.policy = policy
+		return nil
+	}
+}
+
+// WithOverflowCap defines the capacity of the overflow buffer
+// used by OverflowSpill.
+func WithOverflowCap(c int) Option {
+	return func(act *Actor) error {
+		if c < 1 {
+			return fmt.Errorf("invalid actor option: overflow capacity must be positive")
+		}
+		act.overflowCap = c
+		return nil
+	}
+}
+
+// WithClock sets the clock used for repeated actions. By default
+// it's the real clock.
+func WithClock(clock wait.Clock) Option {
+	return func(act *Actor) error {
+		if clock == nil {
+			return fmt.Errorf("invalid actor option: clock is nil")
+		}
+		act.clock = clock
 		return nil
 	}
 }
@@ -0,0 +1,446 @@
+// Tideland Go Stew - Actor
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor // import "tideland.dev/go/stew/actor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// defaultScaleInterval is the interval for checking the
+	// queue pressure of a Pool.
+	defaultScaleInterval = 100 * time.Millisecond
+
+	// defaultGrowLoad is the average queue length per Actor
+	// above which a Pool grows.
+	defaultGrowLoad = 16
+
+	// virtualNodes is the number of points per Actor on the
+	// consistent hash ring.
+	virtualNodes = 64
+)
+
+//--------------------
+// STRATEGY
+//--------------------
+
+// Strategy defines how a Pool distributes actions to its Actors.
+type Strategy int
+
+const (
+	// RoundRobin distributes the actions one after another.
+	RoundRobin Strategy = iota
+
+	// LeastLoaded sends the action to the Actor with the
+	// shortest queue.
+	LeastLoaded
+
+	// ConsistentHash sends all actions with the same key to the
+	// same Actor as long as the Pool doesn't change its size.
+	ConsistentHash
+)
+
+//--------------------
+// POOL OPTIONS
+//--------------------
+
+// PoolOption defines the signature of a pool option setting function.
+type PoolOption func(p *Pool) error
+
+// WithStrategy sets the distribution strategy of the Pool.
+func WithStrategy(strategy Strategy) PoolOption {
+	return func(p *Pool) error {
+		if strategy < RoundRobin || strategy > ConsistentHash {
+			return fmt.Errorf("invalid pool option: unknown strategy %d", strategy)
+		}
+		p.strategy = strategy
+		return nil
+	}
+}
+
+// WithPoolSize sets the minimum and maximum number of Actors. The
+// Pool starts with the minimum number and grows or shrinks within
+// these bounds depending on the queue pressure.
+func WithPoolSize(min, max int) PoolOption {
+	return func(p *Pool) error {
+		if min < 1 || max < min {
+			return fmt.Errorf("invalid pool option: size bounds %d..%d", min, max)
+		}
+		p.minSize = min
+		p.maxSize = max
+		return nil
+	}
+}
+
+// WithScaling sets the interval for checking the queue pressure and
+// the average queue length per Actor above which the Pool grows. It
+// shrinks when all queues are empty.
+func WithScaling(interval time.Duration, growLoad int) PoolOption {
+	return func(p *Pool) error {
+		if interval <= 0 || growLoad < 1 {
+			return fmt.Errorf("invalid pool option: scaling needs positive interval and load")
+		}
+		p.scaleInterval = interval
+		p.growLoad = growLoad
+		return nil
+	}
+}
+
+// WithActorOptions sets the options for each Actor of the Pool.
+func WithActorOptions(options ...Option) PoolOption {
+	return func(p *Pool) error {
+		p.actorOptions = options
+		return nil
+	}
+}
+
+//--------------------
+// POOL
+//--------------------
+
+// pooled is an Actor inside a Pool. Callers having chosen it are
+// counted, so that a removed Actor stops only after their actions
+// are queued.
+type pooled struct {
+	id       int
+	act      *Actor
+	users    atomic.Int64
+	retired  atomic.Bool
+	stopOnce sync.Once
+}
+
+// release ends the use of the pooled Actor by a caller.
+func (pa *pooled) release() {
+	if pa.users.Add(-1) == 0 && pa.retired.Load() {
+		pa.stop()
+	}
+}
+
+// stop lets the Actor stop after its queued actions are done.
+func (pa *pooled) stop() {
+	pa.stopOnce.Do(func() {
+		if err := pa.act.DoAsync(pa.act.Stop); err != nil {
+			pa.act.Stop()
+		}
+	})
+}
+
+// ringNode is a point on the consistent hash ring.
+type ringNode struct {
+	hash uint32
+	id   int
+}
+
+// Pool distributes actions to a number of Actors. Its size adapts
+// to the queue pressure within configured bounds.
+type Pool struct {
+	mu            sync.RWMutex
+	strategy      Strategy
+	minSize       int
+	maxSize       int
+	scaleInterval time.Duration
+	growLoad      int
+	actorOptions  []Option
+	actors        []*pooled
+	ring          []ringNode
+	nextID        int
+	next          atomic.Uint64
+	stopped       bool
+	errs          []error
+	wg            sync.WaitGroup
+	cancel        func()
+	done          chan struct{}
+}
+
+// GoPool starts a Pool of Actors with the given options.
+func GoPool(options ...PoolOption) (*Pool, error) {
+	p := &Pool{
+		strategy:      RoundRobin,
+		minSize:       1,
+		maxSize:       1,
+		scaleInterval: defaultScaleInterval,
+		growLoad:      defaultGrowLoad,
+		done:          make(chan struct{}),
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	// Start the minimum number of Actors.
+	p.mu.Lock()
+	for i := 0; i < p.minSize; i++ {
+		if err := p.grow(); err != nil {
+			p.mu.Unlock()
+			p.Stop()
+			return nil, err
+		}
+	}
+	p.mu.Unlock()
+	// Start scaling if the size may change.
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	if p.minSize < p.maxSize {
+		go p.scale(ctx)
+	}
+	return p, nil
+}
+
+// DoAsync sends the action to an Actor chosen by the strategy and
+// returns when it's queued. ConsistentHash uses an empty key.
+func (p *Pool) DoAsync(action Action) error {
+	return p.DoAsyncKey("", action)
+}
+
+// DoAsyncKey sends the action to an Actor chosen by the strategy and
+// returns when it's queued. The key is used by ConsistentHash only.
+func (p *Pool) DoAsyncKey(key string, action Action) error {
+	pa, err := p.choose(key)
+	if err != nil {
+		return err
+	}
+	defer pa.release()
+	return pa.act.DoAsync(action)
+}
+
+// DoSync executes the action in an Actor chosen by the strategy and
+// returns when it's done. ConsistentHash uses an empty key.
+func (p *Pool) DoSync(action Action) error {
+	return p.DoSyncKey("", action)
+}
+
+// DoSyncKey executes the action in an Actor chosen by the strategy
+// and returns when it's done. The key is used by ConsistentHash only.
+func (p *Pool) DoSyncKey(key string, action Action) error {
+	pa, err := p.choose(key)
+	if err != nil {
+		return err
+	}
+	defer pa.release()
+	return pa.act.DoSync(action)
+}
+
+// Size returns the current number of Actors.
+func (p *Pool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.actors)
+}
+
+// QueueLen returns the summarized queue length of all Actors.
+func (p *Pool) QueueLen() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	l := 0
+	for _, pa := range p.actors {
+		l += pa.act.QueueLen()
+	}
+	return l
+}
+
+// Done returns a channel that is closed when all Actors of the
+// Pool are terminated.
+func (p *Pool) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the joined errors of the failed Actors.
+func (p *Pool) Err() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return errors.Join(p.errs...)
+}
+
+// Stop terminates all Actors of the Pool.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	for _, pa := range p.actors {
+		pa.act.Stop()
+	}
+	p.mu.Unlock()
+	go func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+}
+
+// choose selects an Actor based on the strategy. The caller
+// has to release it after using.
+func (p *Pool) choose(key string) (*pooled, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return nil, fmt.Errorf("pool is stopped")
+	}
+	var chosen *pooled
+	switch p.strategy {
+	case LeastLoaded:
+		chosen = p.actors[0]
+		least := chosen.act.QueueLen()
+		for _, pa := range p.actors[1:] {
+			if l := pa.act.QueueLen(); l < least {
+				chosen = pa
+				least = l
+			}
+		}
+	case ConsistentHash:
+		h := hashKey(key)
+		i := sort.Search(len(p.ring), func(i int) bool {
+			return p.ring[i].hash >= h
+		})
+		if i == len(p.ring) {
+			i = 0
+		}
+		id := p.ring[i].id
+		for _, pa := range p.actors {
+			if pa.id == id {
+				chosen = pa
+				break
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("pool ring is inconsistent")
+		}
+	default:
+		n := p.next.Add(1) - 1
+		chosen = p.actors[n%uint64(len(p.actors))]
+	}
+	chosen.users.Add(1)
+	return chosen, nil
+}
+
+// grow starts a new Actor. The caller must hold the lock.
+func (p *Pool) grow() error {
+	act, err := Go(p.actorOptions...)
+	if err != nil {
+		return fmt.Errorf("cannot start pool actor: %v", err)
+	}
+	pa := &pooled{
+		id:  p.nextID,
+		act: act,
+	}
+	p.nextID++
+	p.actors = append(p.actors, pa)
+	p.buildRing()
+	// Watch the Actor for failures.
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		<-act.Done()
+		if err := act.Err(); err != nil {
+			p.fail(err)
+		}
+	}()
+	return nil
+}
+
+// shrink removes the newest Actor and lets it stop after its queued
+// actions and those of callers having chosen it before are done. The
+// caller must hold the lock.
+func (p *Pool) shrink() {
+	pa := p.actors[len(p.actors)-1]
+	p.actors = p.actors[:len(p.actors)-1]
+	p.buildRing()
+	pa.retired.Store(true)
+	if pa.users.Load() == 0 {
+		pa.stop()
+	}
+}
+
+// buildRing recreates the consistent hash ring. The caller must
+// hold the lock.
+func (p *Pool) buildRing() {
+	if p.strategy != ConsistentHash {
+		return
+	}
+	p.ring = p.ring[:0]
+	for _, pa := range p.actors {
+		for v := 0; v < virtualNodes; v++ {
+			p.ring = append(p.ring, ringNode{
+				hash: hashKey(strconv.Itoa(pa.id) + "#" + strconv.Itoa(v)),
+				id:   pa.id,
+			})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+}
+
+// fail records the error of an Actor and stops the Pool.
+func (p *Pool) fail(err error) {
+	p.mu.Lock()
+	p.errs = append(p.errs, err)
+	p.mu.Unlock()
+	p.Stop()
+}
+
+// scale periodically checks the queue pressure and lets the
+// Pool grow or shrink.
+func (p *Pool) scale(ctx context.Context) {
+	ticker := time.NewTicker(p.scaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.stopped {
+				p.mu.Unlock()
+				return
+			}
+			load := 0
+			for _, pa := range p.actors {
+				load += pa.act.QueueLen()
+			}
+			switch {
+			case load > p.growLoad*len(p.actors) && len(p.actors) < p.maxSize:
+				if err := p.grow(); err != nil {
+					p.errs = append(p.errs, err)
+				}
+			case load == 0 && len(p.actors) > p.minSize:
+				p.shrink()
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// hashKey returns the FNV-1a hash of the key.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// EOF
@@ -0,0 +1,198 @@
+// Tideland Go Stew - Actor - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/actor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestPoolRoundRobin verifies the round-robin distribution.
+func TestPoolRoundRobin(t *testing.T) {
+	p, err := actor.GoPool(actor.WithPoolSize(4, 4))
+	Assert(t, NoError(err), "pool started")
+	Assert(t, Equal(p.Size(), 4), "pool size")
+
+	// Four blocking actions can only run at the same time
+	// if they are distributed to all Actors.
+	var started sync.WaitGroup
+	gate := make(chan struct{})
+	started.Add(4)
+	for i := 0; i < 4; i++ {
+		err = p.DoAsync(func() {
+			started.Done()
+			<-gate
+		})
+		Assert(t, NoError(err), "blocking action queued")
+	}
+	Assert(t, GroupWaits(&started, time.Second), "all actors used")
+	close(gate)
+
+	p.Stop()
+	Assert(t, ChannelClosed(p.Done(), time.Second), "pool stopped")
+	Assert(t, NoError(p.Err()), "pool has no error")
+	Assert(t, ErrorContains(p.DoAsync(func() {}), "pool is stopped"), "stopped pool rejects actions")
+}
+
+// TestPoolLeastLoaded verifies the distribution to the Actor with
+// the shortest queue.
+func TestPoolLeastLoaded(t *testing.T) {
+	p, err := actor.GoPool(
+		actor.WithPoolSize(2, 2),
+		actor.WithStrategy(actor.LeastLoaded),
+	)
+	Assert(t, NoError(err), "pool started")
+
+	// Block the first Actor and fill its queue.
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	err = p.DoAsync(func() {
+		close(started)
+		<-gate
+	})
+	Assert(t, NoError(err), "blocking action queued")
+	<-started
+	err = p.DoAsync(func() {})
+	Assert(t, NoError(err), "action queued")
+
+	// The other Actor has to be chosen now.
+	for i := 0; i < 10; i++ {
+		err = p.DoSync(func() {})
+		Assert(t, NoError(err), "action done by free actor")
+	}
+
+	close(gate)
+	p.Stop()
+	Assert(t, ChannelClosed(p.Done(), time.Second), "pool stopped")
+}
+
+// TestPoolConsistentHash verifies that actions with the same key
+// are executed by the same Actor.
+func TestPoolConsistentHash(t *testing.T) {
+	p, err := actor.GoPool(
+		actor.WithPoolSize(8, 8),
+		actor.WithStrategy(actor.ConsistentHash),
+	)
+	Assert(t, NoError(err), "pool started")
+
+	// Per key the actions are executed sequentially, so the
+	// sequence numbers have to be in order.
+	var mu sync.Mutex
+	seqs := map[string][]int{}
+	keys := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+	for i := 0; i < 100; i++ {
+		i := i
+		key := keys[i%len(keys)]
+		err = p.DoAsyncKey(key, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			seqs[key] = append(seqs[key], i)
+		})
+		Assert(t, NoError(err), "action queued")
+	}
+	for _, key := range keys {
+		err = p.DoSyncKey(key, func() {})
+		Assert(t, NoError(err), "key processed")
+	}
+	mu.Lock()
+	for _, key := range keys {
+		Assert(t, Length(seqs[key], 20), "all actions of key executed")
+		for j := 1; j < len(seqs[key]); j++ {
+			Assert(t, True(seqs[key][j-1] < seqs[key][j]), "actions of key in order")
+		}
+	}
+	mu.Unlock()
+
+	p.Stop()
+}
+
+// TestPoolScaling verifies growing and shrinking of a Pool.
+func TestPoolScaling(t *testing.T) {
+	p, err := actor.GoPool(
+		actor.WithPoolSize(1, 4),
+		actor.WithScaling(10*time.Millisecond, 1),
+	)
+	Assert(t, NoError(err), "pool started")
+	Assert(t, Equal(p.Size(), 1), "initial pool size")
+
+	for i := 0; i < 200; i++ {
+		err = p.DoAsync(func() { time.Sleep(2 * time.Millisecond) })
+		Assert(t, NoError(err), "action queued")
+	}
+	Assert(t, Retries(func() (bool, error) {
+		time.Sleep(5 * time.Millisecond)
+		return p.Size() == 4, nil
+	}, time.Second), "pool grown")
+	Assert(t, Retries(func() (bool, error) {
+		time.Sleep(5 * time.Millisecond)
+		return p.Size() == 1, nil
+	}, 5*time.Second), "pool shrunk")
+
+	p.Stop()
+	Assert(t, ChannelClosed(p.Done(), time.Second), "pool stopped")
+}
+
+// TestPoolScalingCallers verifies that callers don't fail while
+// the Pool shrinks.
+func TestPoolScalingCallers(t *testing.T) {
+	p, err := actor.GoPool(
+		actor.WithPoolSize(1, 4),
+		actor.WithScaling(time.Millisecond, 1),
+	)
+	Assert(t, NoError(err), "pool started")
+
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if err := p.DoSync(func() {}); err != nil {
+					failed.Add(1)
+				}
+				if j%20 == 0 {
+					time.Sleep(2 * time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	Assert(t, Equal(failed.Load(), int64(0)), "no failed calls")
+
+	p.Stop()
+	Assert(t, ChannelClosed(p.Done(), time.Second), "pool stopped")
+}
+
+// TestPoolError verifies that a failing Actor stops the Pool.
+func TestPoolError(t *testing.T) {
+	p, err := actor.GoPool(actor.WithPoolSize(3, 3))
+	Assert(t, NoError(err), "pool started")
+
+	err = p.DoAsync(func() { panic("ouch") })
+	Assert(t, NoError(err), "panicking action queued")
+
+	Assert(t, ChannelClosed(p.Done(), time.Second), "pool stopped")
+	Assert(t, ErrorContains(p.Err(), "ouch"), "pool error")
+}
+
+// EOF
@@ -0,0 +1,239 @@
+// Tideland Go Stew - Actor
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor // import "tideland.dev/go/stew/actor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//--------------------
+// REGISTRY
+//--------------------
+
+// Receiver defines the signature of a function receiving published
+// messages. It is executed inside the subscribed Actor.
+type Receiver func(msg any)
+
+// Registry manages Actors by names. Registered Actors are removed
+// automatically when they are done. Additionally Actors can subscribe
+// to topics and receive published messages or actions.
+type Registry struct {
+	mu     sync.RWMutex
+	actors map[string]*registration
+	topics map[string]map[string]Receiver
+}
+
+// registration is a registered Actor. The cancel channel is closed
+// when it's unregistered to end the watching of the Actor.
+type registration struct {
+	act    *Actor
+	cancel chan struct{}
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		actors: make(map[string]*registration),
+		topics: make(map[string]map[string]Receiver),
+	}
+}
+
+// Register adds the Actor under the given name. It returns an error
+// if the name is already used or the Actor is done.
+func (r *Registry) Register(name string, act *Actor) error {
+	if act == nil {
+		return fmt.Errorf("cannot register actor %q: actor is nil", name)
+	}
+	if act.IsDone() {
+		return fmt.Errorf("cannot register actor %q: actor is done", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.actors[name]; ok {
+		return fmt.Errorf("cannot register actor %q: name already used", name)
+	}
+	reg := &registration{
+		act:    act,
+		cancel: make(chan struct{}),
+	}
+	r.actors[name] = reg
+	// Remove the Actor when it's done until it's unregistered.
+	go func() {
+		select {
+		case <-act.Done():
+			r.remove(name, reg)
+		case <-reg.cancel:
+		}
+	}()
+	return nil
+}
+
+// Unregister removes the Actor with the given name including
+// all its subscriptions. The Actor itself is not stopped.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unregister(name)
+}
+
+// Lookup returns the Actor registered under the given name.
+func (r *Registry) Lookup(name string) (*Actor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.actors[name]
+	if !ok {
+		return nil, false
+	}
+	return reg.act, true
+}
+
+// Names returns the sorted names of all registered Actors.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.actors))
+	for name := range r.actors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Subscribe lets the registered Actor with the given name subscribe
+// to the topic. Published messages are passed to the receiver, which
+// may be nil if the Actor only wants to get broadcasted actions.
+func (r *Registry) Subscribe(topic, name string, receiver Receiver) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.actors[name]; !ok {
+		return fmt.Errorf("cannot subscribe actor %q: not registered", name)
+	}
+	subscribers, ok := r.topics[topic]
+	if !ok {
+		subscribers = make(map[string]Receiver)
+		r.topics[topic] = subscribers
+	}
+	subscribers[name] = receiver
+	return nil
+}
+
+// Unsubscribe removes the subscription of the Actor with the given
+// name from the topic.
+func (r *Registry) Unsubscribe(topic, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unsubscribe(topic, name)
+}
+
+// Subscribers returns the sorted names of all Actors subscribed
+// to the topic.
+func (r *Registry) Subscribers(topic string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.topics[topic]))
+	for name := range r.topics[topic] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Publish delivers the message asynchronously to the receivers of
+// all Actors subscribed to the topic. Subscribers without receiver
+// are skipped. Errors of single deliveries are joined.
+func (r *Registry) Publish(topic string, msg any) error {
+	var errs []error
+	for name, sub := range r.subscriptions(topic) {
+		if sub.receiver == nil {
+			continue
+		}
+		receiver := sub.receiver
+		if err := sub.act.DoAsync(func() { receiver(msg) }); err != nil {
+			errs = append(errs, fmt.Errorf("cannot publish to actor %q: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Broadcast executes the action asynchronously in all Actors subscribed
+// to the topic. Errors of single deliveries are joined.
+func (r *Registry) Broadcast(topic string, action Action) error {
+	var errs []error
+	for name, sub := range r.subscriptions(topic) {
+		if err := sub.act.DoAsync(action); err != nil {
+			errs = append(errs, fmt.Errorf("cannot broadcast to actor %q: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// subscription combines a subscribed Actor with its receiver.
+type subscription struct {
+	act      *Actor
+	receiver Receiver
+}
+
+// subscriptions returns a copy of the subscriptions of a topic, so
+// that delivering doesn't hold the lock.
+func (r *Registry) subscriptions(topic string) map[string]subscription {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	subs := make(map[string]subscription, len(r.topics[topic]))
+	for name, receiver := range r.topics[topic] {
+		subs[name] = subscription{
+			act:      r.actors[name].act,
+			receiver: receiver,
+		}
+	}
+	return subs
+}
+
+// remove unregisters the Actor if it's still registered under
+// the given name.
+func (r *Registry) remove(name string, reg *registration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.actors[name] == reg {
+		r.unregister(name)
+	}
+}
+
+// unregister removes the Actor and its subscriptions and ends the
+// watching of the Actor without locking.
+func (r *Registry) unregister(name string) {
+	reg, ok := r.actors[name]
+	if !ok {
+		return
+	}
+	close(reg.cancel)
+	delete(r.actors, name)
+	for topic := range r.topics {
+		r.unsubscribe(topic, name)
+	}
+}
+
+// unsubscribe removes a subscription without locking.
+func (r *Registry) unsubscribe(topic, name string) {
+	subscribers, ok := r.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subscribers, name)
+	if len(subscribers) == 0 {
+		delete(r.topics, topic)
+	}
+}
+
+// EOF
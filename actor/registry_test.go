@@ -0,0 +1,134 @@
+// Tideland Go Stew - Actor - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/actor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRegistryLookup verifies registering and looking up Actors.
+func TestRegistryLookup(t *testing.T) {
+	reg := actor.NewRegistry()
+	one, err := actor.Go()
+	Assert(t, NoError(err), "actor one started")
+	two, err := actor.Go()
+	Assert(t, NoError(err), "actor two started")
+
+	Assert(t, NoError(reg.Register("one", one)), "actor one registered")
+	Assert(t, NoError(reg.Register("two", two)), "actor two registered")
+	Assert(t, ErrorContains(reg.Register("one", two), "name already used"), "name is used")
+	Assert(t, DeepEqual(reg.Names(), []string{"one", "two"}), "names of actors")
+
+	act, ok := reg.Lookup("one")
+	Assert(t, True(ok), "actor one found")
+	Assert(t, True(act == one), "actor one is correct")
+	_, ok = reg.Lookup("three")
+	Assert(t, False(ok), "actor three not found")
+
+	reg.Unregister("two")
+	_, ok = reg.Lookup("two")
+	Assert(t, False(ok), "actor two unregistered")
+	Assert(t, False(two.IsDone()), "actor two still works")
+
+	one.Stop()
+	two.Stop()
+}
+
+// TestRegistryDone verifies the automatic removal of done Actors.
+func TestRegistryDone(t *testing.T) {
+	reg := actor.NewRegistry()
+	act, err := actor.Go()
+	Assert(t, NoError(err), "actor started")
+	Assert(t, NoError(reg.Register("act", act)), "actor registered")
+	Assert(t, NoError(reg.Subscribe("topic", "act", nil)), "actor subscribed")
+
+	act.Stop()
+
+	Assert(t, Retries(func() (bool, error) {
+		_, ok := reg.Lookup("act")
+		return !ok, nil
+	}, time.Second), "actor removed")
+	Assert(t, Empty(reg.Subscribers("topic")), "subscription removed")
+	Assert(t, ErrorContains(reg.Register("act", act), "actor is done"), "done actor not registered")
+}
+
+// TestRegistryUnregisterWatcher verifies that unregistering ends
+// the watching of the Actor.
+func TestRegistryUnregisterWatcher(t *testing.T) {
+	reg := actor.NewRegistry()
+	act, err := actor.Go()
+	Assert(t, NoError(err), "actor started")
+	defer act.Stop()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("act-%d", i)
+		Assert(t, NoError(reg.Register(name, act)), "actor registered")
+		reg.Unregister(name)
+	}
+	Assert(t, Retries(func() (bool, error) {
+		return runtime.NumGoroutine() <= before, nil
+	}, time.Second), "no watchers left")
+}
+
+// TestRegistryPubSub verifies publishing messages and broadcasting
+// actions to subscribed Actors.
+func TestRegistryPubSub(t *testing.T) {
+	reg := actor.NewRegistry()
+	received := make(chan string, 10)
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		act, err := actor.Go()
+		Assert(t, NoError(err), "actor started")
+		Assert(t, NoError(reg.Register(name, act)), "actor registered")
+		defer act.Stop()
+	}
+	for _, name := range names[:2] {
+		name := name
+		err := reg.Subscribe("news", name, func(msg any) {
+			received <- name + ":" + msg.(string)
+		})
+		Assert(t, NoError(err), "actor subscribed")
+	}
+	Assert(t, ErrorContains(reg.Subscribe("news", "d", nil), "not registered"), "unknown actor not subscribed")
+	Assert(t, DeepEqual(reg.Subscribers("news"), []string{"a", "b"}), "subscribers of topic")
+
+	err := reg.Publish("news", "hello")
+	Assert(t, NoError(err), "message published")
+	got := []string{<-received, <-received}
+	Assert(t, Contains(got, "a:hello"), "a received message")
+	Assert(t, Contains(got, "b:hello"), "b received message")
+
+	err = reg.Broadcast("news", func() { received <- "action" })
+	Assert(t, NoError(err), "action broadcasted")
+	Assert(t, ChannelReceives(received, "action", time.Second), "first action executed")
+	Assert(t, ChannelReceives(received, "action", time.Second), "second action executed")
+
+	reg.Unsubscribe("news", "a")
+	err = reg.Publish("news", "bye")
+	Assert(t, NoError(err), "message published")
+	Assert(t, ChannelReceives(received, "b:bye", time.Second), "only b received message")
+	Assert(t, Empty(received), "no more messages")
+}
+
+// EOF
@@ -33,7 +33,7 @@ func (act *Actor) RepeatWithContext(
 	ctx, cancel := context.WithCancel(ctx)
 	// Goroutine to run the interval.
 	go func() {
-		ticker := time.NewTicker(interval)
+		ticker := act.clock.NewTicker(interval)
 		defer ticker.Stop()
 		for {
 			select {
@@ -41,7 +41,7 @@ func (act *Actor) RepeatWithContext(
 				return
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
+			case <-ticker.C():
 				if act.DoAsyncWithContext(ctx, action) != nil {
 					return
 				}
@@ -18,6 +18,7 @@ import (
 	. "tideland.dev/go/stew/qaone"
 
 	"tideland.dev/go/stew/actor"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -90,4 +91,30 @@ func TestIntervalStopInterval(t *testing.T) {
 	act.Stop()
 }
 
+// TestRepeatFakeClock verifies Repeat driven by a fake clock.
+func TestRepeatFakeClock(t *testing.T) {
+	clock := wait.NewFakeClock(time.Now())
+	act, err := actor.Go(actor.WithClock(clock))
+	Assert(t, NoError(err), "actor started")
+	defer act.Stop()
+
+	repeated := make(chan struct{}, 10)
+	stop, err := act.Repeat(time.Hour, func() {
+		repeated <- struct{}{}
+	})
+	Assert(t, NoError(err), "action repeated")
+	defer stop()
+
+	clock.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Hour)
+		<-repeated
+	}
+	select {
+	case <-repeated:
+		t.Fatalf("action repeated without advancing the clock")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
 // EOF
@@ -0,0 +1,241 @@
+// Tideland Go Stew - Actor
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor // import "tideland.dev/go/stew/actor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//--------------------
+// TYPED ACTOR
+//--------------------
+
+// Handler defines the signature of a function processing a message
+// of type M for a state of type S. It returns the new state. In case
+// of an error the state is not changed.
+type Handler[S, M any] func(state S, msg M) (S, error)
+
+// TypedActor owns a state of type S and processes messages of type M
+// sequentially through a Handler. This way the protocol of the actor
+// is explicit and the handler can be tested without any concurrency.
+type TypedActor[S, M any] struct {
+	mu       sync.Mutex
+	act      *Actor
+	handler  Handler[S, M]
+	state    S
+	draining bool
+	failure  atomic.Pointer[error]
+}
+
+// GoTyped starts a TypedActor with the initial state, the handler
+// and the options of an Actor.
+func GoTyped[S, M any](state S, handler Handler[S, M], options ...Option) (*TypedActor[S, M], error) {
+	if handler == nil {
+		return nil, fmt.Errorf("typed actor handler is nil")
+	}
+	act, err := Go(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedActor[S, M]{
+		act:     act,
+		handler: handler,
+		state:   state,
+	}, nil
+}
+
+// Send sends the message to the backend and returns when it's queued.
+// If the handler returns an error for it the TypedActor stops and
+// Err returns this error.
+func (ta *TypedActor[S, M]) Send(msg M) error {
+	return ta.SendWithContext(context.Background(), msg)
+}
+
+// SendWithContext sends the message to the backend and returns when
+// it's queued. A context allows to cancel the processing or add a
+// timeout.
+func (ta *TypedActor[S, M]) SendWithContext(ctx context.Context, msg M) error {
+	_, err := ta.enqueue(ctx, func() {
+		if err := ta.process(msg); err != nil {
+			ta.failure.Store(&err)
+			ta.act.Stop()
+		}
+	})
+	return err
+}
+
+// Call processes the message and returns the new state when it's done.
+// Errors of the handler are returned without stopping the TypedActor.
+func (ta *TypedActor[S, M]) Call(msg M) (S, error) {
+	return ta.CallWithContext(context.Background(), msg)
+}
+
+// CallWithContext processes the message and returns the new state when
+// it's done. A context allows to cancel the processing or add a timeout.
+func (ta *TypedActor[S, M]) CallWithContext(ctx context.Context, msg M) (S, error) {
+	var state S
+	var herr error
+	req, err := ta.enqueue(ctx, func() {
+		herr = ta.process(msg)
+		state = ta.state
+	})
+	if err != nil {
+		return state, err
+	}
+	if err := ta.act.wait(req); err != nil {
+		return state, err
+	}
+	return state, herr
+}
+
+// Snapshot returns the current state after all messages queued before
+// have been processed. If the state contains references, like pointers,
+// maps, or slices, the caller must not modify them.
+func (ta *TypedActor[S, M]) Snapshot() (S, error) {
+	var state S
+	if err := ta.act.DoSync(func() {
+		state = ta.state
+	}); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// Drain stops accepting new messages, waits until all queued messages
+// are processed, and stops the TypedActor afterwards. The context
+// limits the time to wait.
+func (ta *TypedActor[S, M]) Drain(ctx context.Context) error {
+	defer ta.act.Stop()
+	// Stop accepting and queue the marker at once, so it follows
+	// all accepted messages. The marker must not be dropped or
+	// rejected by the overflow policy.
+	ta.mu.Lock()
+	ta.draining = true
+	req := newRequest(ctx, func() {})
+	err := ta.act.sendLast(req)
+	ta.mu.Unlock()
+	if err == nil {
+		err = ta.act.wait(req)
+	}
+	if err != nil {
+		if ferr := ta.failure.Load(); ferr != nil {
+			return *ferr
+		}
+		return err
+	}
+	return nil
+}
+
+// Stop terminates the TypedActor immediately. Queued messages
+// are not processed anymore.
+func (ta *TypedActor[S, M]) Stop() {
+	ta.act.Stop()
+}
+
+// Done returns a channel that is closed when the TypedActor terminates.
+func (ta *TypedActor[S, M]) Done() <-chan struct{} {
+	return ta.act.Done()
+}
+
+// Err returns information if the TypedActor has an error, either
+// returned by the handler or by the underlying Actor.
+func (ta *TypedActor[S, M]) Err() error {
+	if err := ta.failure.Load(); err != nil {
+		return *err
+	}
+	return ta.act.Err()
+}
+
+// enqueue queues the action unless the TypedActor is draining. The
+// check and the queuing are done at once, so no accepted message
+// gets lost when draining.
+func (ta *TypedActor[S, M]) enqueue(ctx context.Context, action Action) (*request, error) {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	if ta.draining {
+		return nil, fmt.Errorf("typed actor is draining")
+	}
+	req := newRequest(ctx, action)
+	if err := ta.act.send(ta.act.requests, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// process lets the handler process the message and updates
+// the state if there's no error.
+func (ta *TypedActor[S, M]) process(msg M) error {
+	state, err := ta.handler(ta.state, msg)
+	if err != nil {
+		return err
+	}
+	ta.state = state
+	return nil
+}
+
+//--------------------
+// HARNESS
+//--------------------
+
+// Harness allows testing a Handler step by step without starting
+// a TypedActor. It follows the same rules for state changes.
+type Harness[S, M any] struct {
+	handler Handler[S, M]
+	state   S
+	steps   int
+}
+
+// NewHarness creates a Harness for the initial state and the handler.
+func NewHarness[S, M any](state S, handler Handler[S, M]) *Harness[S, M] {
+	return &Harness[S, M]{
+		handler: handler,
+		state:   state,
+	}
+}
+
+// Step lets the handler process one message and returns the
+// new state. In case of an error the state is not changed.
+func (h *Harness[S, M]) Step(msg M) (S, error) {
+	state, err := h.handler(h.state, msg)
+	if err != nil {
+		return h.state, fmt.Errorf("step %d: %v", h.steps+1, err)
+	}
+	h.state = state
+	h.steps++
+	return h.state, nil
+}
+
+// Steps lets the handler process all messages in order. It stops
+// at the first error.
+func (h *Harness[S, M]) Steps(msgs ...M) (S, error) {
+	for _, msg := range msgs {
+		if _, err := h.Step(msg); err != nil {
+			return h.state, err
+		}
+	}
+	return h.state, nil
+}
+
+// State returns the current state.
+func (h *Harness[S, M]) State() S {
+	return h.state
+}
+
+// Processed returns the number of successfully processed messages.
+func (h *Harness[S, M]) Processed() int {
+	return h.steps
+}
+
+// EOF
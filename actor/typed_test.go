@@ -0,0 +1,180 @@
+// Tideland Go Stew - Actor - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package actor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/actor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTypedActor verifies sending and calling a TypedActor.
+func TestTypedActor(t *testing.T) {
+	ta, err := actor.GoTyped(0, counterHandler)
+	Assert(t, NoError(err), "typed actor started")
+
+	for i := 0; i < 10; i++ {
+		Assert(t, NoError(ta.Send(1)), "message sent")
+	}
+	state, err := ta.Snapshot()
+	Assert(t, NoError(err), "snapshot taken")
+	Assert(t, Equal(state, 10), "state after sending")
+
+	state, err = ta.Call(-3)
+	Assert(t, NoError(err), "message called")
+	Assert(t, Equal(state, 7), "state after calling")
+
+	state, err = ta.Call(0)
+	Assert(t, ErrorContains(err, "zero"), "handler error returned")
+	Assert(t, Equal(state, 7), "state not changed")
+	Assert(t, NoError(ta.Err()), "calling errors don't stop")
+
+	ta.Stop()
+	<-ta.Done()
+}
+
+// TestTypedActorFailure verifies stopping a TypedActor in case
+// of an handler error for a sent message.
+func TestTypedActorFailure(t *testing.T) {
+	ta, err := actor.GoTyped(0, counterHandler)
+	Assert(t, NoError(err), "typed actor started")
+
+	Assert(t, NoError(ta.Send(0)), "message sent")
+	Assert(t, ChannelClosed(ta.Done(), time.Second), "typed actor stopped")
+	Assert(t, ErrorContains(ta.Err(), "zero"), "handler error is actor error")
+}
+
+// TestTypedActorDrain verifies the graceful draining of a TypedActor.
+func TestTypedActorDrain(t *testing.T) {
+	processed := 0
+	ta, err := actor.GoTyped(0, func(state, msg int) (int, error) {
+		time.Sleep(time.Millisecond)
+		processed++
+		return state + msg, nil
+	})
+	Assert(t, NoError(err), "typed actor started")
+
+	for i := 0; i < 50; i++ {
+		Assert(t, NoError(ta.Send(1)), "message sent")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Assert(t, NoError(ta.Drain(ctx)), "typed actor drained")
+	Assert(t, ErrorContains(ta.Send(1), "draining"), "no more messages accepted")
+	Assert(t, ChannelClosed(ta.Done(), time.Second), "typed actor stopped")
+	Assert(t, Equal(processed, 50), "all queued messages processed")
+}
+
+// TestTypedActorDrainRace verifies that every message accepted
+// while draining concurrently is processed.
+func TestTypedActorDrainRace(t *testing.T) {
+	var processed atomic.Int64
+	ta, err := actor.GoTyped(0, func(state, msg int) (int, error) {
+		processed.Add(1)
+		return state + msg, nil
+	})
+	Assert(t, NoError(err), "typed actor started")
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ta.Send(1) == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Assert(t, NoError(ta.Drain(ctx)), "typed actor drained")
+	wg.Wait()
+	Assert(t, Equal(processed.Load(), accepted.Load()), "all accepted messages processed")
+}
+
+// TestTypedActorDrainFullQueue verifies draining with a full queue
+// and an overflow policy rejecting new messages.
+func TestTypedActorDrainFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	var processed atomic.Int64
+	ta, err := actor.GoTyped(0, func(state, msg int) (int, error) {
+		<-release
+		processed.Add(1)
+		return state + msg, nil
+	}, actor.WithOverflowPolicy(actor.OverflowReject))
+	Assert(t, NoError(err), "typed actor started")
+
+	accepted := int64(0)
+	for ta.Send(1) == nil {
+		accepted++
+	}
+	Assert(t, ErrorContains(ta.Send(1), "queue is full"), "queue is full")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- ta.Drain(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	Assert(t, NoError(<-drained), "typed actor drained")
+	Assert(t, Equal(processed.Load(), accepted), "all accepted messages processed")
+}
+
+// TestHarness verifies testing a handler step by step.
+func TestHarness(t *testing.T) {
+	h := actor.NewHarness(0, counterHandler)
+
+	state, err := h.Step(5)
+	Assert(t, NoError(err), "first step")
+	Assert(t, Equal(state, 5), "state after first step")
+
+	state, err = h.Steps(1, 2, 3)
+	Assert(t, NoError(err), "more steps")
+	Assert(t, Equal(state, 11), "state after more steps")
+
+	state, err = h.Steps(1, 0, 1)
+	Assert(t, ErrorMatches(err, "step 6: .*zero.*"), "failing step")
+	Assert(t, Equal(state, 12), "state before failing step")
+	Assert(t, Equal(h.State(), 12), "state of harness")
+	Assert(t, Equal(h.Processed(), 5), "processed steps")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// counterHandler adds the message to the state. Zero is not allowed.
+func counterHandler(state, msg int) (int, error) {
+	if msg == 0 {
+		return state, errors.New("zero is not allowed")
+	}
+	return state + msg, nil
+}
+
+// EOF
@@ -16,6 +16,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 )
 
 //--------------------
@@ -49,57 +50,37 @@ func (c Captured) Len() int {
 // CAPTURING
 //--------------------
 
-// Stdout captures Stdout.
-func Stdout(f func()) Captured {
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	f()
-
-	outC := make(chan []byte)
-
-	go func() {
-		var buf bytes.Buffer
-		if _, err := io.Copy(&buf, r); err != nil {
-			log.Fatalf("error capturing stdout: %v", err)
-		}
-		outC <- buf.Bytes()
-	}()
+// stdoutMu and stderrMu serialize the exchange of the
+// process-global os.Stdout and os.Stderr.
+var (
+	stdoutMu sync.Mutex
+	stderrMu sync.Mutex
+)
 
-	w.Close()
-	os.Stdout = old
+// Stdout captures Stdout. As os.Stdout is global, calls are serialized,
+// parallel tests wait for each other. Nesting is not supported, a
+// nested call blocks forever.
+func Stdout(f func()) Captured {
+	var buf bytes.Buffer
+	redirect(&stdoutMu, &os.Stdout, f, &buf)
 	return Captured{
-		buffer: <-outC,
+		buffer: buf.Bytes(),
 	}
 }
 
-// Stderr captures Stderr.
+// Stderr captures Stderr. As os.Stderr is global, calls are serialized,
+// parallel tests wait for each other. Nesting is not supported, a
+// nested call blocks forever.
 func Stderr(f func()) Captured {
-	old := os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stderr = w
-
-	f()
-
-	outC := make(chan []byte)
-
-	go func() {
-		var buf bytes.Buffer
-		if _, err := io.Copy(&buf, r); err != nil {
-			log.Fatalf("error capturing stderr: %v", err)
-		}
-		outC <- buf.Bytes()
-	}()
-
-	w.Close()
-	os.Stderr = old
+	var buf bytes.Buffer
+	redirect(&stderrMu, &os.Stderr, f, &buf)
 	return Captured{
-		buffer: <-outC,
+		buffer: buf.Bytes(),
 	}
 }
 
-// Both captures Stdout and Stderr.
+// Both captures Stdout and Stderr. Calls of Stdout or Stderr
+// inside of f are not supported.
 func Both(f func()) (Captured, Captured) {
 	var cerr Captured
 	ff := func() {
@@ -109,4 +90,125 @@ func Both(f func()) (Captured, Captured) {
 	return cout, cerr
 }
 
+// Writer passes a writer to f and captures what is written to it. It
+// helps with code taking its own writer, e.g. loggers, and is safe for
+// parallel tests.
+func Writer(f func(w io.Writer)) Captured {
+	var buf bytes.Buffer
+	w := &lockedWriter{w: &buf}
+	f(w)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Captured{
+		buffer: buf.Bytes(),
+	}
+}
+
+//--------------------
+// STREAMING
+//--------------------
+
+// StdoutStream captures Stdout like Stdout but passes each written line
+// without the line ending to onLine while f is running.
+func StdoutStream(f func(), onLine func(line string)) {
+	lw := NewLineWriter(onLine)
+	redirect(&stdoutMu, &os.Stdout, f, lw)
+	lw.Flush()
+}
+
+// StderrStream captures Stderr like Stderr but passes each written line
+// without the line ending to onLine while f is running.
+func StderrStream(f func(), onLine func(line string)) {
+	lw := NewLineWriter(onLine)
+	redirect(&stderrMu, &os.Stderr, f, lw)
+	lw.Flush()
+}
+
+// LineWriter is an io.Writer passing each written line without the
+// line ending to a function. It can be used concurrently.
+type LineWriter struct {
+	mu     sync.Mutex
+	onLine func(line string)
+	buf    []byte
+}
+
+// NewLineWriter creates a line writer calling onLine for each line.
+func NewLineWriter(onLine func(line string)) *LineWriter {
+	return &LineWriter{
+		onLine: onLine,
+	}
+}
+
+// Write implements io.Writer.
+func (lw *LineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.onLine(string(bytes.TrimSuffix(lw.buf[:i], []byte{'\r'})))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes a remaining line without line ending.
+func (lw *LineWriter) Flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.buf) > 0 {
+		lw.onLine(string(lw.buf))
+		lw.buf = nil
+	}
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// redirect exchanges the file with a pipe while f is running and
+// copies the output to the writer. It's restored even if f panics.
+func redirect(mu *sync.Mutex, file **os.File, f func(), w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("error creating pipe for capturing: %v", err)
+	}
+	old := *file
+	*file = pw
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		if _, err := io.Copy(w, r); err != nil {
+			log.Fatalf("error capturing output: %v", err)
+		}
+		r.Close()
+	}()
+	defer func() {
+		pw.Close()
+		<-copied
+		*file = old
+	}()
+
+	f()
+}
+
+// lockedWriter serializes the writing to a writer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write implements io.Writer.
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
 // EOF
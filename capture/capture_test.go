@@ -13,7 +13,12 @@ package capture_test
 
 import (
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	. "tideland.dev/go/stew/qaone"
@@ -89,4 +94,176 @@ func TestRestore(t *testing.T) {
 	Assert(t, Equal(os.Stderr, oldErr), "os.Stderr not restored")
 }
 
+// TestSerialized tests concurrent capturing of stdout.
+func TestSerialized(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out := fmt.Sprintf("goroutine %d", i)
+			cout := capture.Stdout(func() {
+				fmt.Print(out)
+			})
+			if cout.String() != out {
+				t.Errorf("captured %q instead of %q", cout.String(), out)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestLargeOutput tests capturing more than a pipe buffer.
+func TestLargeOutput(t *testing.T) {
+	line := strings.Repeat("x", 1023) + "\n"
+	cout := capture.Stdout(func() {
+		for i := 0; i < 256; i++ {
+			fmt.Print(line)
+		}
+	})
+	Assert(t, Length(cout, 256*1024), "all output captured")
+}
+
+// TestRestoreOnPanic tests the restoring of os.Stdout if the
+// function panics.
+func TestRestoreOnPanic(t *testing.T) {
+	oldOut := os.Stdout
+	Assert(t, Panics(func() {
+		capture.Stdout(func() {
+			panic("ouch")
+		})
+	}), "panic passed")
+	Assert(t, Equal(os.Stdout, oldOut), "os.Stdout restored")
+}
+
+// TestWriter tests capturing a passed writer.
+func TestWriter(t *testing.T) {
+	t.Parallel()
+	cptrd := capture.Writer(func(w io.Writer) {
+		logger := log.New(w, "[test] ", 0)
+		logger.Print("hello")
+	})
+	Assert(t, Equal(cptrd.String(), "[test] hello\n"), "writer captured")
+}
+
+// TestStream tests the streaming of lines.
+func TestStream(t *testing.T) {
+	var lines []string
+	capture.StdoutStream(func() {
+		fmt.Println("one")
+		fmt.Print("two\r\nthr")
+		fmt.Print("ee\nfour")
+	}, func(line string) {
+		lines = append(lines, line)
+	})
+	Assert(t, DeepEqual(lines, []string{"one", "two", "three", "four"}), "lines streamed")
+
+	lines = nil
+	capture.StderrStream(func() {
+		fmt.Fprintln(os.Stderr, "ouch")
+	}, func(line string) {
+		lines = append(lines, line)
+	})
+	Assert(t, DeepEqual(lines, []string{"ouch"}), "stderr lines streamed")
+}
+
+// TestLog tests capturing the default logger.
+func TestLog(t *testing.T) {
+	oldWriter := log.Writer()
+	oldFlags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(oldFlags)
+
+	cptrd := capture.Log(func() {
+		log.Print("hello")
+		slog.Info("world", "answer", 42)
+	})
+	Assert(t, True(strings.Contains(cptrd.String(), "hello\n")), "log output captured")
+	Assert(t, True(strings.Contains(cptrd.String(), "INFO world answer=42")), "default slog output captured")
+	Assert(t, Equal(log.Writer(), oldWriter), "log writer restored")
+
+	var lines []string
+	capture.LogStream(func() {
+		log.Print("one")
+		log.Print("two")
+	}, func(line string) {
+		lines = append(lines, line)
+	})
+	Assert(t, DeepEqual(lines, []string{"one", "two"}), "log lines streamed")
+}
+
+// TestSlog tests capturing slog records.
+func TestSlog(t *testing.T) {
+	oldLogger := slog.Default()
+	oldWriter := log.Writer()
+	oldFlags := log.Flags()
+
+	records := capture.Slog(func() {
+		slog.Debug("debugging", "step", 1)
+		slog.Info("request", slog.Group("request", "method", "GET", "path", "/"))
+		slog.With("user", "alice").WithGroup("db").Warn("slow query", "ms", 250)
+		log.Print("from log")
+	})
+	Assert(t, Equal(records.Len(), 4), "all records captured")
+	Assert(t, DeepEqual(records.Messages(), []string{"debugging", "request", "slow query", "from log"}), "messages")
+	Assert(t, Length(records.Level(slog.LevelWarn), 1), "one warning")
+
+	r, ok := records.Find("request")
+	Assert(t, True(ok), "request found")
+	Assert(t, Equal(r.Level, slog.LevelInfo), "level captured")
+	method, ok := r.Attr("request.method")
+	Assert(t, True(ok), "grouped attribute captured")
+	Assert(t, Equal(method, any("GET")), "grouped attribute value")
+
+	r, _ = records.Find("slow query")
+	Assert(t, DeepEqual(r.Attrs, map[string]any{"user": "alice", "db.ms": int64(250)}), "attributes with groups")
+
+	Assert(t, Equal(slog.Default(), oldLogger), "slog logger restored")
+	Assert(t, Equal(log.Writer(), oldWriter), "log writer restored")
+	Assert(t, Equal(log.Flags(), oldFlags), "log flags restored")
+
+	var messages []string
+	capture.SlogStream(func() {
+		slog.Info("one")
+		slog.Error("two")
+	}, func(record capture.Record) {
+		messages = append(messages, record.Level.String()+" "+record.Message)
+	})
+	Assert(t, DeepEqual(messages, []string{"INFO one", "ERROR two"}), "records streamed")
+
+	// Records of concurrent loggers are passed one after another.
+	count := 0
+	capture.SlogStream(func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					slog.Info("concurrent")
+				}
+			}()
+		}
+		wg.Wait()
+	}, func(record capture.Record) {
+		count++
+	})
+	Assert(t, Equal(count, 50), "concurrent records streamed")
+}
+
+// TestHandler tests capturing records of an own logger.
+func TestHandler(t *testing.T) {
+	t.Parallel()
+	h := capture.NewHandler(slog.LevelInfo)
+	logger := slog.New(h)
+	logger.Debug("ignored")
+	logger.Info("hello", "answer", 42)
+	logger.WithGroup("sub").Info("grouped", "flag", true)
+
+	records := h.Records()
+	Assert(t, Equal(records.Len(), 2), "debug ignored")
+	Assert(t, Equal(records[0].Attrs["answer"], any(int64(42))), "attribute captured")
+	Assert(t, Equal(records[1].Attrs["sub.flag"], any(true)), "grouped attribute captured")
+}
+
 // EOF
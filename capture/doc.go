@@ -19,6 +19,23 @@
 //	cout, cerr = capture.Both(func() { ... })
 //
 // The captured content data also can be retrieved as bytes.
+//
+// Log captures the output of the default logger of the log package, Slog
+// the records of the default slog logger as structured data.
+//
+//	records := capture.Slog(func() { ... })
+//	r, ok := records.Find("request done")
+//	status, ok := r.Attr("response.status")
+//
+// The stream variants like StdoutStream or SlogStream pass each line or
+// record to a callback while the function is running. The calls of the
+// callback are serialized.
+//
+// The exchanged os.Stdout, os.Stderr, and default loggers are global for
+// the process. So the capturing of each of them is serialized, parallel
+// tests wait for each other. Nesting them is not supported, a nested
+// call blocks forever. Code taking an own writer or slog.Handler can be
+// tested in parallel using Writer or NewHandler instead.
 package capture // import "tideland.dev/go/stew/capture"
 
 // EOF
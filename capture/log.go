@@ -0,0 +1,280 @@
+// Tideland Go Stew - Capture
+//
+// Copyright (C) 2017-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package capture // import "tideland.dev/go/stew/capture"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+//--------------------
+// LOG
+//--------------------
+
+// logMu serializes the exchange of the process-global default
+// loggers of the log and the log/slog package.
+var logMu sync.Mutex
+
+// Log captures the output of the default logger of the log package. If
+// the default slog logger hasn't been replaced it writes through it, so
+// its output is captured too. Calls are serialized with Slog, nesting
+// of both is not supported.
+func Log(f func()) Captured {
+	var buf bytes.Buffer
+	redirectLog(f, &buf)
+	return Captured{
+		buffer: buf.Bytes(),
+	}
+}
+
+// LogStream captures the output of the default logger like Log but
+// passes each written line without the line ending to onLine.
+func LogStream(f func(), onLine func(line string)) {
+	lw := NewLineWriter(onLine)
+	redirectLog(f, lw)
+	lw.Flush()
+}
+
+// redirectLog sets the output of the default logger while
+// f is running.
+func redirectLog(f func(), w io.Writer) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	old := log.Writer()
+	log.SetOutput(w)
+	defer log.SetOutput(old)
+
+	f()
+}
+
+//--------------------
+// SLOG
+//--------------------
+
+// Record is a captured slog record. The keys of attributes in groups
+// are joined with dots, e.g. "request.method".
+type Record struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   map[string]any
+}
+
+// Attr returns the value of an attribute.
+func (r Record) Attr(key string) (any, bool) {
+	v, ok := r.Attrs[key]
+	return v, ok
+}
+
+// Records contains captured slog records.
+type Records []Record
+
+// Len returns the number of records.
+func (rs Records) Len() int {
+	return len(rs)
+}
+
+// Messages returns the messages of all records.
+func (rs Records) Messages() []string {
+	msgs := make([]string, len(rs))
+	for i, r := range rs {
+		msgs[i] = r.Message
+	}
+	return msgs
+}
+
+// Level returns the records with the level.
+func (rs Records) Level(level slog.Level) Records {
+	var lrs Records
+	for _, r := range rs {
+		if r.Level == level {
+			lrs = append(lrs, r)
+		}
+	}
+	return lrs
+}
+
+// Find returns the first record with the message.
+func (rs Records) Find(message string) (Record, bool) {
+	for _, r := range rs {
+		if r.Message == message {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// Slog captures the records of the default slog logger. While f is
+// running the output of the default logger of the log package is
+// passed as records with level info too. Calls are serialized with
+// Log, nesting of both is not supported.
+func Slog(f func()) Records {
+	h := NewHandler(nil)
+	redirectSlog(f, h)
+	return h.Records()
+}
+
+// SlogStream captures the records of the default slog logger like
+// Slog but passes them to onRecord while f is running. The calls of
+// onRecord are serialized.
+func SlogStream(f func(), onRecord func(record Record)) {
+	h := NewHandler(nil)
+	h.store.onRecord = onRecord
+	redirectSlog(f, h)
+}
+
+// redirectSlog sets the handler of the default slog logger while f
+// is running. Afterwards the old logger and the output and flags of
+// the default logger of the log package are restored.
+func redirectSlog(f func(), h slog.Handler) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	oldLogger := slog.Default()
+	oldWriter := log.Writer()
+	oldFlags := log.Flags()
+	slog.SetDefault(slog.New(h))
+	defer func() {
+		slog.SetDefault(oldLogger)
+		log.SetOutput(oldWriter)
+		log.SetFlags(oldFlags)
+	}()
+
+	f()
+}
+
+//--------------------
+// HANDLER
+//--------------------
+
+// recordStore collects the records of a handler and those derived
+// from it or passes them to onRecord.
+type recordStore struct {
+	mu       sync.Mutex
+	records  Records
+	onRecord func(record Record)
+}
+
+// Handler is a slog.Handler capturing the records. It can be passed to
+// own loggers and is safe for parallel tests.
+type Handler struct {
+	level  slog.Leveler
+	store  *recordStore
+	prefix string
+	attrs  map[string]any
+}
+
+// NewHandler creates a handler capturing records with the level or
+// above. A nil level captures all records.
+func NewHandler(level slog.Leveler) *Handler {
+	return &Handler{
+		level: level,
+		store: &recordStore{},
+		attrs: map[string]any{},
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.level == nil || level >= h.level.Level()
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	record := Record{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+		Attrs:   make(map[string]any, len(h.attrs)+r.NumAttrs()),
+	}
+	for key, value := range h.attrs {
+		record.Attrs[key] = value
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		addAttr(record.Attrs, h.prefix, attr)
+		return true
+	})
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	if h.store.onRecord != nil {
+		h.store.onRecord(record)
+		return nil
+	}
+	h.store.records = append(h.store.records, record)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hc := h.clone()
+	for _, attr := range attrs {
+		addAttr(hc.attrs, hc.prefix, attr)
+	}
+	return hc
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	hc := h.clone()
+	hc.prefix += name + "."
+	return hc
+}
+
+// Records returns the captured records of the handler and
+// of those derived from it.
+func (h *Handler) Records() Records {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	return append(Records(nil), h.store.records...)
+}
+
+// clone copies the handler sharing the store.
+func (h *Handler) clone() *Handler {
+	hc := &Handler{
+		level:  h.level,
+		store:  h.store,
+		prefix: h.prefix,
+		attrs:  make(map[string]any, len(h.attrs)),
+	}
+	for key, value := range h.attrs {
+		hc.attrs[key] = value
+	}
+	return hc
+}
+
+// addAttr adds the resolved attribute, groups are flattened.
+func addAttr(attrs map[string]any, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, ga := range value.Group() {
+			addAttr(attrs, groupPrefix, ga)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	attrs[prefix+attr.Key] = value.Any()
+}
+
+// EOF
@@ -1,6 +1,6 @@
 module tideland.dev/go/stew
 
-go 1.20
+go 1.21
 
 require (
 	golang.org/x/exp v0.0.0-20230713183714-613f0c0eb8a1
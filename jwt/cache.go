@@ -17,6 +17,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -29,6 +31,23 @@ type cacheEntry struct {
 	accessed time.Time
 }
 
+//--------------------
+// CACHE OPTIONS
+//--------------------
+
+// CacheOption defines the signature of a cache option setting function.
+type CacheOption func(c *Cache)
+
+// WithClock sets the clock used for the access times and the
+// background cleanup. By default it's the real clock.
+func WithClock(clock wait.Clock) CacheOption {
+	return func(c *Cache) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
+
 //--------------------
 // CACHE
 //--------------------
@@ -45,6 +64,7 @@ type Cache struct {
 	leeway     time.Duration
 	interval   time.Duration
 	maxEntries int
+	clock      wait.Clock
 	actionc    chan func()
 }
 
@@ -54,8 +74,9 @@ type Cache struct {
 // The duration of the interval controls how often the background
 // cleanup is running. Final configuration parameter is the maximum
 // number of entries inside the cache. If these grow too fast the
-// ttl will be temporarily reduced for cleanup.
-func NewCache(ctx context.Context, ttl, leeway, interval time.Duration, maxEntries int) *Cache {
+// ttl will be temporarily reduced for cleanup. Options can change
+// further settings.
+func NewCache(ctx context.Context, ttl, leeway, interval time.Duration, maxEntries int, options ...CacheOption) *Cache {
 	c := &Cache{
 		ctx:        ctx,
 		entries:    map[string]*cacheEntry{},
@@ -63,8 +84,12 @@ func NewCache(ctx context.Context, ttl, leeway, interval time.Duration, maxEntri
 		leeway:     leeway,
 		interval:   interval,
 		maxEntries: maxEntries,
+		clock:      wait.RealClock(),
 		actionc:    make(chan func(), 1),
 	}
+	for _, option := range options {
+		option(c)
+	}
 	go c.backend()
 	return c
 }
@@ -84,7 +109,7 @@ func (c *Cache) Get(st string) (*JWT, error) {
 			// Remove invalid token.
 			delete(c.entries, st)
 		}
-		entry.accessed = time.Now()
+		entry.accessed = c.clock.Now()
 		token = entry.token
 	}, defaultTimeout)
 	if aerr != nil {
@@ -152,7 +177,7 @@ func (c *Cache) Put(token *JWT) (int, error) {
 			return
 		}
 		if token.IsValid(c.leeway) {
-			c.entries[token.String()] = &cacheEntry{token, time.Now()}
+			c.entries[token.String()] = &cacheEntry{token, c.clock.Now()}
 			lenEntries := len(c.entries)
 			if lenEntries > c.maxEntries {
 				ttl := int64(c.ttl) / int64(lenEntries) * int64(c.maxEntries)
@@ -190,7 +215,7 @@ func (c *Cache) requestToken(req *http.Request) (string, error) {
 // cleanup checks for invalid or unused tokens.
 func (c *Cache) cleanup(ttl time.Duration) {
 	valids := map[string]*cacheEntry{}
-	now := time.Now()
+	now := c.clock.Now()
 	for key, entry := range c.entries {
 		if entry.token.IsValid(c.leeway) {
 			if entry.accessed.Add(ttl).After(now) {
@@ -219,7 +244,7 @@ func (c *Cache) doSync(action func(), timeout time.Duration) error {
 
 // backend is the goroutine of the cache.
 func (c *Cache) backend() {
-	ticker := time.NewTicker(c.interval)
+	ticker := c.clock.NewTicker(c.interval)
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -228,7 +253,7 @@ func (c *Cache) backend() {
 			return
 		case action := <-c.actionc:
 			action()
-		case <-ticker.C:
+		case <-ticker.C():
 			if c.entries != nil {
 				c.cleanup(c.ttl)
 			}
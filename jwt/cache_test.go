@@ -20,6 +20,7 @@ import (
 	. "tideland.dev/go/stew/qaone"
 
 	"tideland.dev/go/stew/jwt"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -52,7 +53,8 @@ func TestCachePutGet(t *testing.T) {
 func TestCacheAccessCleanup(t *testing.T) {
 	ctx := context.Background()
 	maxEntries := 10
-	cache := jwt.NewCache(ctx, time.Second, time.Second, time.Second, maxEntries)
+	clock := wait.NewFakeClock(time.Now())
+	cache := jwt.NewCache(ctx, time.Second, time.Second, time.Second, maxEntries, jwt.WithClock(clock))
 	key := []byte("secret")
 	claims := initClaims()
 	jwtIn, err := jwt.Encode(claims, key, jwt.HS512)
@@ -63,8 +65,9 @@ func TestCacheAccessCleanup(t *testing.T) {
 	jwtOut, err := cache.Get(jwt)
 	Assert(t, NoError(err), "getting of token failed")
 	Assert(t, Equal(jwtIn, jwtOut), "token is correct")
-	// Now wait a bit an try again.
-	time.Sleep(5 * time.Second)
+	// Now advance the time and try again.
+	clock.Advance(5 * time.Second)
+	Assert(t, NoError(cache.Cleanup()), "cleanup done")
 	jwtOut, err = cache.Get(jwt)
 	Assert(t, NoError(err), "getting of token failed")
 	Assert(t, Nil(jwtOut), "token is not there")
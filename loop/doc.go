@@ -48,6 +48,16 @@
 // a possible internal error. Also recovering of internal panics with
 // a repairer function passed as option is possible. See the code
 // examples.
+//
+// With the option WithRestart a worker returning an error is restarted
+// after a backoff delay, e.g. created by a wait.TickChangerFunc, until
+// a restart budget is exhausted. WithNotifier sets a function receiving
+// each StatusChange together with the error causing it.
+//
+// A Group starts loops as named members with dependencies and stops them
+// in reverse dependency order, each with its own timeout. The errors of
+// the members are collected, a failing critical member stops the whole
+// Group.
 package loop // import "tideland.dev/go/stew/loop"
 
 // EOF
@@ -0,0 +1,219 @@
+// Tideland Go Stew - Loop
+//
+// Copyright (C) 2017-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop // import "tideland.dev/go/stew/loop"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// defaultStopTimeout is the time a group waits for a member
+// to stop if nothing else is configured.
+const defaultStopTimeout = 5 * time.Second
+
+//--------------------
+// GROUP
+//--------------------
+
+// Member describes a loop inside a Group. Dependencies have to be
+// started before the member and are stopped after it. A failing
+// critical member stops the whole Group.
+type Member struct {
+	Name        string
+	DependsOn   []string
+	Critical    bool
+	StopTimeout time.Duration
+}
+
+// member is a started loop of a Group.
+type member struct {
+	Member
+	loop    *Loop
+	watched chan struct{}
+}
+
+// Group starts loops as members and stops them in reverse dependency
+// order. Errors of the members are collected.
+type Group struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	members  []*member
+	names    map[string]*member
+	errs     []error
+	stopping bool
+	stopOnce sync.Once
+	stopped  chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewGroup creates a Group. The context is passed to all member loops.
+func NewGroup(ctx context.Context) *Group {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Group{
+		ctx:     ctx,
+		names:   make(map[string]*member),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Go starts a loop with the worker and the options as member of the Group.
+// All dependencies of the member have to be started before.
+func (g *Group) Go(m Member, worker Worker, options ...Option) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopping {
+		return fmt.Errorf("loop group is stopping")
+	}
+	if m.Name == "" {
+		return fmt.Errorf("loop group member needs a name")
+	}
+	if _, ok := g.names[m.Name]; ok {
+		return fmt.Errorf("loop group member %q already exists", m.Name)
+	}
+	for _, dep := range m.DependsOn {
+		if _, ok := g.names[dep]; !ok {
+			return fmt.Errorf("loop group member %q depends on unknown member %q", m.Name, dep)
+		}
+	}
+	if m.StopTimeout <= 0 {
+		m.StopTimeout = defaultStopTimeout
+	}
+	options = append([]Option{WithContext(g.ctx)}, options...)
+	l, err := Go(worker, options...)
+	if err != nil {
+		return fmt.Errorf("loop group member %q: %w", m.Name, err)
+	}
+	gm := &member{
+		Member:  m,
+		loop:    l,
+		watched: make(chan struct{}),
+	}
+	g.members = append(g.members, gm)
+	g.names[m.Name] = gm
+	// Watch the member for errors.
+	g.wg.Add(1)
+	go g.watch(gm)
+	return nil
+}
+
+// Loop returns the loop of the member with the given name.
+func (g *Group) Loop(name string) (*Loop, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gm, ok := g.names[name]
+	if !ok {
+		return nil, false
+	}
+	return gm.loop, true
+}
+
+// Statuses returns the current status of all members.
+func (g *Group) Statuses() map[string]Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	statuses := make(map[string]Status, len(g.members))
+	for _, gm := range g.members {
+		statuses[gm.Name] = gm.loop.Status()
+	}
+	return statuses
+}
+
+// Stop terminates all members in reverse dependency order. Each member
+// gets its stop timeout to finalize. It returns the joined errors of
+// all members.
+func (g *Group) Stop() error {
+	g.stopOnce.Do(func() {
+		g.mu.Lock()
+		g.stopping = true
+		members := make([]*member, len(g.members))
+		copy(members, g.members)
+		g.mu.Unlock()
+		for i := len(members) - 1; i >= 0; i-- {
+			g.stop(members[i])
+		}
+		close(g.stopped)
+	})
+	<-g.stopped
+	return g.Err()
+}
+
+// Wait waits until all members are terminated, either by stopping
+// the Group or by their own, and returns the joined errors.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	return g.Err()
+}
+
+// FirstErr returns the first error of a member.
+func (g *Group) FirstErr() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	return g.errs[0]
+}
+
+// Err returns the joined errors of all members in the order
+// of their occurrence.
+func (g *Group) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+// stop terminates one member and waits for its finalization.
+func (g *Group) stop(gm *member) {
+	gm.loop.Stop()
+	select {
+	case <-gm.watched:
+	case <-time.After(gm.StopTimeout):
+		g.mu.Lock()
+		g.errs = append(g.errs, fmt.Errorf("loop group member %q did not stop within %v", gm.Name, gm.StopTimeout))
+		g.mu.Unlock()
+	}
+}
+
+// watch waits for the termination of a member, records its error,
+// and stops the siblings if it's critical.
+func (g *Group) watch(gm *member) {
+	defer g.wg.Done()
+	defer close(gm.watched)
+	<-gm.loop.Done()
+	err := gm.loop.Err()
+	if err == nil {
+		return
+	}
+	g.mu.Lock()
+	stopping := g.stopping || g.ctx.Err() != nil
+	if stopping && errors.Is(err, context.Canceled) {
+		// Regular termination by the Group.
+		g.mu.Unlock()
+		return
+	}
+	g.errs = append(g.errs, fmt.Errorf("loop group member %q: %w", gm.Name, err))
+	g.mu.Unlock()
+	if gm.Critical && !stopping {
+		go g.Stop()
+	}
+}
+
+// EOF
@@ -0,0 +1,138 @@
+// Tideland Go Stew - Loop - Unit Tests
+//
+// Copyright (C) 2017-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package loop_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/loop"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestGroupStopOrder tests stopping the members of a group in
+// reverse dependency order.
+func TestGroupStopOrder(t *testing.T) {
+	var mu sync.Mutex
+	order := []string{}
+	worker := func(name string) loop.Worker {
+		return func(ctx context.Context) error {
+			<-ctx.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return ctx.Err()
+		}
+	}
+	g := loop.NewGroup(context.Background())
+
+	err := g.Go(loop.Member{Name: "db"}, worker("db"))
+	Assert(t, NoError(err), "db started")
+	err = g.Go(loop.Member{Name: "cache", DependsOn: []string{"db"}}, worker("cache"))
+	Assert(t, NoError(err), "cache started")
+	err = g.Go(loop.Member{Name: "api", DependsOn: []string{"db", "cache"}}, worker("api"))
+	Assert(t, NoError(err), "api started")
+	err = g.Go(loop.Member{Name: "web", DependsOn: []string{"unknown"}}, worker("web"))
+	Assert(t, ErrorContains(err, "unknown member"), "dependency must exist")
+	err = g.Go(loop.Member{Name: "db"}, worker("db"))
+	Assert(t, ErrorContains(err, "already exists"), "names are unique")
+
+	statuses := g.Statuses()
+	Assert(t, Length(statuses, 3), "three members")
+	Assert(t, Equal(statuses["api"], loop.StatusWorking), "api is working")
+
+	Assert(t, NoError(g.Stop()), "group stopped without error")
+	Assert(t, DeepEqual(order, []string{"api", "cache", "db"}), "reverse order")
+	Assert(t, ErrorContains(g.Go(loop.Member{Name: "late"}, worker("late")), "stopping"), "no start after stop")
+}
+
+// TestGroupErrors tests collecting the errors of the members.
+func TestGroupErrors(t *testing.T) {
+	g := loop.NewGroup(context.Background())
+	failing := func(msg string) loop.Worker {
+		return func(ctx context.Context) error {
+			<-ctx.Done()
+			return errors.New(msg)
+		}
+	}
+	Assert(t, NoError(g.Go(loop.Member{Name: "a"}, failing("a failed"))), "a started")
+	Assert(t, NoError(g.Go(loop.Member{Name: "b"}, failing("b failed"))), "b started")
+
+	err := g.Stop()
+	Assert(t, ErrorContains(err, "a failed"), "error of a")
+	Assert(t, ErrorContains(err, "b failed"), "error of b")
+	Assert(t, Retries(func() (bool, error) {
+		return g.FirstErr() != nil, nil
+	}, time.Second), "first error")
+	Assert(t, ErrorContains(g.FirstErr(), `member "b"`), "b stopped first")
+}
+
+// TestGroupCritical tests stopping all siblings if a critical
+// member fails.
+func TestGroupCritical(t *testing.T) {
+	g := loop.NewGroup(context.Background())
+	blocking := func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+	failing := make(chan struct{})
+	critical := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-failing:
+			return errors.New("critical failure")
+		}
+	}
+	Assert(t, NoError(g.Go(loop.Member{Name: "one"}, blocking)), "one started")
+	Assert(t, NoError(g.Go(loop.Member{Name: "critical", Critical: true}, critical)), "critical started")
+	Assert(t, NoError(g.Go(loop.Member{Name: "two"}, blocking)), "two started")
+
+	close(failing)
+
+	err := g.Wait()
+	Assert(t, ErrorContains(err, "critical failure"), "critical error returned")
+	Assert(t, ErrorContains(g.FirstErr(), `member "critical"`), "critical is first")
+	for name, status := range g.Statuses() {
+		Assert(t, True(status == loop.StatusStopped || status == loop.StatusError), "%s terminated", name)
+	}
+}
+
+// TestGroupStopTimeout tests the timeout when stopping members.
+func TestGroupStopTimeout(t *testing.T) {
+	g := loop.NewGroup(context.Background())
+	release := make(chan struct{})
+	stubborn := func(ctx context.Context) error {
+		<-ctx.Done()
+		<-release
+		return nil
+	}
+	err := g.Go(loop.Member{Name: "stubborn", StopTimeout: 20 * time.Millisecond}, stubborn)
+	Assert(t, NoError(err), "stubborn started")
+
+	err = g.Stop()
+	Assert(t, ErrorContains(err, `member "stubborn" did not stop within 20ms`), "stop timeout")
+
+	close(release)
+	Assert(t, ErrorContains(g.Wait(), "did not stop"), "timeout stays recorded")
+	Assert(t, Equal(g.Statuses()["stubborn"], loop.StatusStopped), "stubborn finally stopped")
+}
+
+// EOF
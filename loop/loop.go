@@ -16,6 +16,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -36,8 +38,41 @@ const (
 	StatusStopping
 	StatusFinalizing
 	StatusError
+	StatusRestarting
 )
 
+// String returns the name of the status.
+func (s Status) String() string {
+	switch s {
+	case StatusStopped:
+		return "stopped"
+	case StatusStarting:
+		return "starting"
+	case StatusWorking:
+		return "working"
+	case StatusRepairing:
+		return "repairing"
+	case StatusStopping:
+		return "stopping"
+	case StatusFinalizing:
+		return "finalizing"
+	case StatusError:
+		return "error"
+	case StatusRestarting:
+		return "restarting"
+	default:
+		return fmt.Sprintf("status(%d)", int(s))
+	}
+}
+
+// StatusChange describes the change of the status of a Loop
+// together with the error causing it.
+type StatusChange struct {
+	From Status
+	To   Status
+	Err  error
+}
+
 //--------------------
 // FUNCTION TYPES
 //--------------------
@@ -54,6 +89,13 @@ type Repairer func(reason interface{}) error
 // loop terminates.
 type Finalizer func(err error) error
 
+// Notifier is called for each status change of the loop. The calls
+// are done in order by the loop goroutine or by the caller of Stop,
+// but outside the lock, so the Notifier may ask the loop for its
+// status. A blocking Notifier stalls the loop, so it has to return
+// quickly or hand the change over to another goroutine.
+type Notifier func(change StatusChange)
+
 //--------------------
 // LOOP
 //--------------------
@@ -69,8 +111,17 @@ type Loop struct {
 	worker    Worker
 	repairer  Repairer
 	finalizer Finalizer
+	backoff   wait.TickChangerFunc
+	budget    int
+	restarts  int
+	lastDelay time.Duration
+	clock     wait.Clock
+	notifier  Notifier
+	notifyMu  sync.Mutex
+	changes   []StatusChange
 	status    Status
 	err       error
+	done      chan struct{}
 }
 
 // Go starts a loop running the given worker with the
@@ -80,6 +131,8 @@ func Go(worker Worker, options ...Option) (*Loop, error) {
 	l := &Loop{
 		worker: worker,
 		status: StatusStarting,
+		clock:  wait.RealClock(),
+		done:   make(chan struct{}),
 	}
 	for _, option := range options {
 		if err := option(l); err != nil {
@@ -97,11 +150,13 @@ func Go(worker Worker, options ...Option) (*Loop, error) {
 	go l.backend(started)
 	select {
 	case <-started:
-		l.status = StatusWorking
 		return l, nil
 	case <-time.After(timeout):
-		l.status = StatusError
-		return nil, fmt.Errorf("loop starting timeout after %.1f seconds", timeout.Seconds())
+		l.mu.Lock()
+		l.setStatus(StatusError, fmt.Errorf("loop starting timeout after %.1f seconds", timeout.Seconds()))
+		l.mu.Unlock()
+		l.notify()
+		return nil, l.Err()
 	}
 }
 
@@ -119,26 +174,43 @@ func (l *Loop) Err() error {
 	return l.err
 }
 
+// Done returns a channel that is closed when the Loop is finalized.
+func (l *Loop) Done() <-chan struct{} {
+	return l.done
+}
+
+// Restarts returns the number of restarts after errors.
+func (l *Loop) Restarts() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.restarts
+}
+
 // Stop terminates the Loop backend. It works asynchronous as
 // the goroutine may need time for cleanup. Anyone wanting to
 // be notified on state has to handle it in a Finalizer.
 func (l *Loop) Stop() {
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	if l.status == StatusWorking {
-		l.status = StatusStopping
+	if l.status == StatusWorking || l.status == StatusRestarting {
+		l.setStatus(StatusStopping, nil)
 		l.cancel()
 	}
+	l.mu.Unlock()
+	l.notify()
 }
 
 // backend runs the loop worker as goroutine as long as
 // the it isn't terminated or recovery returned false.
 func (l *Loop) backend(started chan struct{}) {
 	defer l.finalize()
-	l.status = StatusWorking
+	l.mu.Lock()
+	l.setStatus(StatusWorking, nil)
+	l.mu.Unlock()
+	l.notify()
 	close(started)
-	for l.status == StatusWorking {
+	for l.Status() == StatusWorking || l.restart() {
 		l.work()
+		l.notify()
 	}
 }
 
@@ -151,47 +223,125 @@ func (l *Loop) work() {
 		case reason != nil && l.repairer != nil:
 			// Try to repair.
 			l.mu.Lock()
-			l.status = StatusRepairing
+			l.setStatus(StatusRepairing, fmt.Errorf("loop panic: %v", reason))
 			err := l.repairer(reason)
-			l.err = err
-			if l.err == nil {
+			if err == nil {
 				// Success, continue.
-				l.status = StatusWorking
+				l.setStatus(StatusWorking, nil)
 			} else {
 				// Failure, stop.
-				l.status = StatusError
+				l.setStatus(StatusError, err)
 			}
 			l.mu.Unlock()
 		case reason != nil && l.repairer == nil:
 			// Accept panic.
 			l.mu.Lock()
-			l.err = fmt.Errorf("loop panic: %v", reason)
-			l.status = StatusError
+			l.setStatus(StatusError, fmt.Errorf("loop panic: %v", reason))
 			l.mu.Unlock()
 		}
 	}()
 	// Work without panic.
 	err := l.worker(l.ctx)
 	l.mu.Lock()
-	l.err = err
-	if l.err == nil {
-		l.status = StatusStopped
+	if err == nil {
+		l.setStatus(StatusStopped, nil)
 	} else {
-		l.status = StatusError
+		l.setStatus(StatusError, err)
+	}
+	l.mu.Unlock()
+}
+
+// restart checks if the loop has to be restarted after an error. In
+// this case it waits for the backoff and returns true.
+func (l *Loop) restart() bool {
+	l.mu.Lock()
+	if l.status != StatusError || l.backoff == nil || l.ctx.Err() != nil {
+		l.mu.Unlock()
+		return false
+	}
+	if l.budget > 0 && l.restarts >= l.budget {
+		l.mu.Unlock()
+		return false
+	}
+	delay, ok := l.backoff(l.lastDelay)
+	if !ok {
+		l.mu.Unlock()
+		return false
 	}
+	l.restarts++
+	l.lastDelay = delay
+	err := l.err
+	l.setStatus(StatusRestarting, err)
 	l.mu.Unlock()
+	l.notify()
+	// Wait for the backoff.
+	timer := l.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+	case <-l.ctx.Done():
+		l.mu.Lock()
+		l.setStatus(StatusError, err)
+		l.mu.Unlock()
+		l.notify()
+		return false
+	}
+	l.mu.Lock()
+	if l.status != StatusRestarting {
+		l.mu.Unlock()
+		return false
+	}
+	l.setStatus(StatusWorking, nil)
+	l.mu.Unlock()
+	l.notify()
+	return true
+}
+
+// setStatus changes status and error and records the change
+// for the notifier. The caller must hold the lock.
+func (l *Loop) setStatus(status Status, err error) {
+	from := l.status
+	l.status = status
+	l.err = err
+	if l.notifier != nil {
+		l.changes = append(l.changes, StatusChange{
+			From: from,
+			To:   status,
+			Err:  err,
+		})
+	}
+}
+
+// notify passes the recorded status changes in order to the notifier.
+// The caller must not hold the lock.
+func (l *Loop) notify() {
+	if l.notifier == nil {
+		return
+	}
+	l.notifyMu.Lock()
+	defer l.notifyMu.Unlock()
+	l.mu.Lock()
+	changes := l.changes
+	l.changes = nil
+	l.mu.Unlock()
+	for _, change := range changes {
+		l.notifier(change)
+	}
 }
 
 // finalize takes care for a clean loop finalization.
 func (l *Loop) finalize() {
 	l.mu.Lock()
-	defer l.mu.Unlock()
 	status := l.status
-	l.status = StatusFinalizing
+	err := l.err
+	l.setStatus(StatusFinalizing, err)
 	if l.finalizer != nil {
-		l.err = l.finalizer(l.err)
+		err = l.finalizer(err)
 	}
-	l.status = status
+	l.setStatus(status, err)
+	l.mu.Unlock()
+	l.notify()
+	close(l.done)
 }
 
 // EOF
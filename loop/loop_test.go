@@ -21,6 +21,7 @@ import (
 	. "tideland.dev/go/stew/qaone"
 
 	"tideland.dev/go/stew/loop"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -345,6 +346,153 @@ func TestRecoverPanicsOK(t *testing.T) {
 	Assert(t, ErrorContains(l.Err(), "too many panics: bam"), "stopped loop.Err() returned wrong error")
 }
 
+// TestRestartBudget tests restarting a failing worker with a backoff
+// until the restart budget is exhausted.
+func TestRestartBudget(t *testing.T) {
+	runs := 0
+	stopped := make(chan struct{})
+	delays := []time.Duration{}
+	worker := func(ctx context.Context) error {
+		runs++
+		return fmt.Errorf("run %d failed", runs)
+	}
+	backoff := func(in time.Duration) (time.Duration, bool) {
+		out := 2*in + time.Millisecond
+		delays = append(delays, out)
+		return out, true
+	}
+	l, err := loop.Go(
+		worker,
+		loop.WithRestart(backoff, 3),
+		loop.WithFinalizer(func(err error) error {
+			defer close(stopped)
+			return err
+		}),
+	)
+	Assert(t, NoError(err), "loop.Go() failed")
+
+	<-stopped
+
+	Assert(t, Equal(runs, 4), "worker started initially and restarted three times")
+	Assert(t, Equal(l.Restarts(), 3), "restarts counted")
+	Assert(t, DeepEqual(delays, []time.Duration{time.Millisecond, 3 * time.Millisecond, 7 * time.Millisecond}), "backoff delays")
+	Assert(t, ErrorContains(l.Err(), "run 4 failed"), "last error returned")
+	Assert(t, Equal(l.Status(), loop.StatusError), "loop ended with error")
+}
+
+// TestRestartRecovered tests restarting a worker which works
+// after some failures.
+func TestRestartRecovered(t *testing.T) {
+	runs := 0
+	worked := make(chan struct{})
+	worker := func(ctx context.Context) error {
+		runs++
+		if runs < 3 {
+			panic("not yet")
+		}
+		close(worked)
+		<-ctx.Done()
+		return nil
+	}
+	backoff := func(in time.Duration) (time.Duration, bool) {
+		return time.Millisecond, true
+	}
+	l, err := loop.Go(worker, loop.WithRestart(backoff, 0))
+	Assert(t, NoError(err), "loop.Go() failed")
+
+	<-worked
+
+	Assert(t, Equal(l.Status(), loop.StatusWorking), "loop is working")
+	Assert(t, NoError(l.Err()), "no error after restart")
+	Assert(t, Equal(l.Restarts(), 2), "restarted twice")
+
+	l.Stop()
+}
+
+// TestRestartFakeClock tests the restart delay with a fake clock.
+func TestRestartFakeClock(t *testing.T) {
+	clock := wait.NewFakeClock(time.Now())
+	runs := make(chan int, 4)
+	run := 0
+	worker := func(ctx context.Context) error {
+		run++
+		runs <- run
+		if run == 1 {
+			return errors.New("first run failed")
+		}
+		<-ctx.Done()
+		return nil
+	}
+	backoff := func(in time.Duration) (time.Duration, bool) {
+		return time.Hour, true
+	}
+	l, err := loop.Go(worker, loop.WithRestart(backoff, 0), loop.WithClock(clock))
+	Assert(t, NoError(err), "loop.Go() failed")
+	Assert(t, Equal(<-runs, 1), "first run")
+
+	clock.BlockUntil(1)
+	Assert(t, Equal(l.Status(), loop.StatusRestarting), "loop is restarting")
+	clock.Advance(time.Hour)
+	Assert(t, Equal(<-runs, 2), "second run after fake delay")
+	Assert(t, Equal(l.Restarts(), 1), "restarted once")
+
+	l.Stop()
+}
+
+// TestNotifier tests the notification about status changes.
+func TestNotifier(t *testing.T) {
+	changes := make(chan loop.StatusChange, 16)
+	runs := 0
+	worker := func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			return errors.New("first run failed")
+		}
+		<-ctx.Done()
+		return nil
+	}
+	backoff := func(in time.Duration) (time.Duration, bool) {
+		return time.Millisecond, true
+	}
+	l, err := loop.Go(
+		worker,
+		loop.WithRestart(backoff, 1),
+		loop.WithNotifier(func(change loop.StatusChange) {
+			changes <- change
+		}),
+	)
+	Assert(t, NoError(err), "loop.Go() failed")
+
+	expected := []loop.StatusChange{
+		{loop.StatusStarting, loop.StatusWorking, nil},
+		{loop.StatusWorking, loop.StatusError, errors.New("first run failed")},
+		{loop.StatusError, loop.StatusRestarting, errors.New("first run failed")},
+		{loop.StatusRestarting, loop.StatusWorking, nil},
+	}
+	for _, e := range expected {
+		change := <-changes
+		Assert(t, Equal(change.From, e.From), "from %v", e.From)
+		Assert(t, Equal(change.To, e.To), "to %v", e.To)
+		Assert(t, Equal(fmt.Sprint(change.Err), fmt.Sprint(e.Err)), "error of change to %v", e.To)
+	}
+
+	l.Stop()
+
+	expected = []loop.StatusChange{
+		{loop.StatusWorking, loop.StatusStopping, nil},
+		{loop.StatusStopping, loop.StatusStopped, nil},
+		{loop.StatusStopped, loop.StatusFinalizing, nil},
+		{loop.StatusFinalizing, loop.StatusStopped, nil},
+	}
+	for _, e := range expected {
+		change := <-changes
+		Assert(t, Equal(change.From, e.From), "from %v", e.From)
+		Assert(t, Equal(change.To, e.To), "to %v", e.To)
+		Assert(t, NoError(change.Err), "no error")
+	}
+	Assert(t, Equal(loop.StatusRestarting.String(), "restarting"), "status name")
+}
+
 //--------------------
 // EXAMPLES
 //--------------------
@@ -14,6 +14,8 @@ package loop // import "tideland.dev/go/stew/loop"
 import (
 	"context"
 	"fmt"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -57,4 +59,47 @@ func WithFinalizer(finalizer Finalizer) Option {
 	}
 }
 
+// WithRestart lets the loop restart its worker after it returned an
+// error or panicked without a repairer. The backoff is called with the
+// previous delay, initially zero, and returns the delay before the next
+// restart. If it returns false the loop stops with the error. The budget
+// limits the number of restarts, zero means unlimited.
+func WithRestart(backoff wait.TickChangerFunc, budget int) Option {
+	return func(l *Loop) error {
+		if backoff == nil {
+			return fmt.Errorf("invalid loop option: backoff is nil")
+		}
+		if budget < 0 {
+			return fmt.Errorf("invalid loop option: restart budget is negative")
+		}
+		l.backoff = backoff
+		l.budget = budget
+		return nil
+	}
+}
+
+// WithClock sets the clock used for the restart delays. By default
+// it's the real clock.
+func WithClock(clock wait.Clock) Option {
+	return func(l *Loop) error {
+		if clock == nil {
+			return fmt.Errorf("invalid loop option: clock is nil")
+		}
+		l.clock = clock
+		return nil
+	}
+}
+
+// WithNotifier sets a function called for each status change
+// of the loop.
+func WithNotifier(notifier Notifier) Option {
+	return func(l *Loop) error {
+		if notifier == nil {
+			return fmt.Errorf("invalid loop option: notifier is nil")
+		}
+		l.notifier = notifier
+		return nil
+	}
+}
+
 // EOF
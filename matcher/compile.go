@@ -0,0 +1,459 @@
+// Tideland Go Stew - Matcher
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package matcher // import "tideland.dev/go/stew/matcher"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"unicode"
+)
+
+//--------------------
+// CHARACTER CLASSES
+//--------------------
+
+// namedClasses contains the POSIX-style named classes usable as
+// [[:name:]] inside of brackets. They are Unicode aware.
+var namedClasses = map[string]func(r rune) bool{
+	"alpha":  unicode.IsLetter,
+	"digit":  unicode.IsDigit,
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"upper":  unicode.IsUpper,
+	"lower":  unicode.IsLower,
+	"space":  unicode.IsSpace,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"punct":  unicode.IsPunct,
+	"print":  unicode.IsPrint,
+	"graph":  func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"cntrl":  unicode.IsControl,
+	"xdigit": func(r rune) bool { return unicode.Is(unicode.ASCII_Hex_Digit, r) },
+	"word":   func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) },
+}
+
+// runeRange is a range of runes inside a class.
+type runeRange struct {
+	lo rune
+	hi rune
+}
+
+// class is a set of runes defined in brackets.
+type class struct {
+	negated bool
+	ranges  []runeRange
+	named   []func(r rune) bool
+}
+
+// contains checks if the rune is in the class. With folding
+// all case variants of the rune are checked.
+func (c *class) contains(r rune, fold bool) bool {
+	in := c.containsRune(r)
+	if fold && !in {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if c.containsRune(f) {
+				in = true
+				break
+			}
+		}
+	}
+	return in != c.negated
+}
+
+// containsRune checks if exactly the rune is in the class.
+func (c *class) containsRune(r rune) bool {
+	for _, rr := range c.ranges {
+		if r >= rr.lo && r <= rr.hi {
+			return true
+		}
+	}
+	for _, is := range c.named {
+		if is(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldRune returns the canonical case folded rune, the smallest
+// one of all its case variants.
+func foldRune(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+//--------------------
+// PROGRAM
+//--------------------
+
+// opcode describes the operation of an instruction.
+type opcode int
+
+const (
+	opRune   opcode = iota // matches one rune
+	opClass                // matches one rune of a class
+	opAny                  // matches one rune except the separator
+	opAnyAll               // matches any rune
+	opSplit                // continues at out and out1
+	opNop                  // continues at out
+	opMatch                // the pattern matched
+)
+
+// inst is one instruction of the compiled pattern.
+type inst struct {
+	op    opcode
+	r     rune
+	class *class
+	id    int
+	out   int
+	out1  int
+}
+
+// hole is an instruction output still to be set.
+type hole struct {
+	pc  int
+	alt bool
+}
+
+// frag is a compiled fragment of a pattern with the
+// outputs to be set to the following fragment.
+type frag struct {
+	start int
+	outs  []hole
+}
+
+// program is one or more compiled patterns.
+type program struct {
+	insts []inst
+	start int
+}
+
+//--------------------
+// COMPILER
+//--------------------
+
+// compiler parses a pattern and compiles it into a program.
+type compiler struct {
+	pattern   []rune
+	pos       int
+	fold      bool
+	separator rune
+	extended  bool
+	prog      *program
+}
+
+// compile compiles the pattern with the settings of the options. A
+// separator of zero means there is none and * matches any rune.
+func compile(pattern string, options *Pattern) (*program, error) {
+	prog := &program{}
+	start, err := prog.compile(pattern, 0, options)
+	if err != nil {
+		return nil, err
+	}
+	prog.start = start
+	return prog, nil
+}
+
+// compile appends the pattern to the program and returns its start.
+// Its match instruction contains the ID, so multiple patterns can
+// share one program.
+func (prog *program) compile(pattern string, id int, options *Pattern) (int, error) {
+	c := &compiler{
+		pattern:   []rune(pattern),
+		fold:      options.fold,
+		separator: options.separator,
+		extended:  !options.legacy,
+		prog:      prog,
+	}
+	f, err := c.sequence(false)
+	if err != nil {
+		return 0, err
+	}
+	if c.pos < len(c.pattern) {
+		return 0, fmt.Errorf("invalid pattern %q: unexpected %q at %d", pattern, c.pattern[c.pos], c.pos)
+	}
+	match := c.emit(inst{op: opMatch, id: id})
+	c.patch(f.outs, match)
+	return f.start, nil
+}
+
+// sequence compiles runes until the end of the pattern or, inside
+// of braces, until a comma or a closing brace. Without the extended
+// syntax braces are matched like any other rune.
+func (c *compiler) sequence(inBraces bool) (frag, error) {
+	var frags []frag
+	for c.pos < len(c.pattern) {
+		r := c.pattern[c.pos]
+		if inBraces && (r == ',' || r == '}') {
+			break
+		}
+		var f frag
+		var err error
+		switch r {
+		case '\\':
+			c.pos++
+			if c.pos < len(c.pattern) {
+				r = c.pattern[c.pos]
+			}
+			c.pos++
+			f = c.literal(r)
+		case '?':
+			c.pos++
+			f = c.single(inst{op: opAny})
+		case '*':
+			f = c.asterisks()
+		case '[':
+			if !c.extended {
+				f = c.lenientClass()
+				break
+			}
+			f, err = c.class()
+		case '{':
+			if !c.extended {
+				c.pos++
+				f = c.literal(r)
+				break
+			}
+			f, err = c.alternation()
+		default:
+			c.pos++
+			f = c.literal(r)
+		}
+		if err != nil {
+			return frag{}, err
+		}
+		frags = append(frags, f)
+	}
+	return c.concat(frags), nil
+}
+
+// asterisks compiles * and **. Without separator both match any runes.
+// Otherwise * stops at the separator while ** matches across it. A
+// ** between separators also matches no path element at all.
+func (c *compiler) asterisks() frag {
+	begin := c.pos
+	for c.pos < len(c.pattern) && c.pattern[c.pos] == '*' {
+		c.pos++
+	}
+	if c.separator == 0 {
+		return c.star(c.single(inst{op: opAnyAll}))
+	}
+	if c.pos-begin == 1 {
+		return c.star(c.single(inst{op: opAny}))
+	}
+	atStart := begin == 0 || c.pattern[begin-1] == c.separator
+	beforeSep := c.pos < len(c.pattern) && c.pattern[c.pos] == c.separator
+	if atStart && beforeSep {
+		c.pos++
+		dirs := c.concat([]frag{
+			c.star(c.single(inst{op: opAnyAll})),
+			c.literal(c.separator),
+		})
+		return c.optional(dirs)
+	}
+	return c.star(c.single(inst{op: opAnyAll}))
+}
+
+// class compiles a class in brackets.
+func (c *compiler) class() (frag, error) {
+	begin := c.pos
+	c.pos++
+	cl := &class{}
+	if c.pos < len(c.pattern) && c.pattern[c.pos] == '^' {
+		cl.negated = true
+		c.pos++
+	}
+	for {
+		if c.pos >= len(c.pattern) {
+			return frag{}, fmt.Errorf("invalid pattern %q: unclosed class at %d", string(c.pattern), begin)
+		}
+		r := c.pattern[c.pos]
+		switch {
+		case r == ']':
+			c.pos++
+			return c.single(inst{op: opClass, class: cl}), nil
+		case r == '[' && c.hasPrefix("[:"):
+			end := c.index(":]")
+			if end < 0 {
+				return frag{}, fmt.Errorf("invalid pattern %q: unclosed named class at %d", string(c.pattern), c.pos)
+			}
+			name := string(c.pattern[c.pos+2 : end])
+			is, ok := namedClasses[name]
+			if !ok {
+				return frag{}, fmt.Errorf("invalid pattern %q: unknown named class %q", string(c.pattern), name)
+			}
+			cl.named = append(cl.named, is)
+			c.pos = end + 2
+			continue
+		case r == '\\' && c.pos+1 < len(c.pattern):
+			c.pos++
+			r = c.pattern[c.pos]
+		}
+		c.pos++
+		lo, hi := r, r
+		if c.pos+1 < len(c.pattern) && c.pattern[c.pos] == '-' && c.pattern[c.pos+1] != ']' {
+			hi = c.pattern[c.pos+1]
+			c.pos += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		cl.ranges = append(cl.ranges, runeRange{lo, hi})
+	}
+}
+
+// lenientClass compiles a class in brackets like Matches always did.
+// There are no named classes, escaped runes don't start a range, a
+// range may end with the closing bracket, and an unclosed class takes
+// the rest of the pattern.
+func (c *compiler) lenientClass() frag {
+	c.pos++
+	cl := &class{}
+	if c.pos < len(c.pattern) && c.pattern[c.pos] == '^' {
+		cl.negated = true
+		c.pos++
+	}
+	for c.pos < len(c.pattern) {
+		r := c.pattern[c.pos]
+		switch {
+		case r == '\\':
+			c.pos++
+			if c.pos < len(c.pattern) {
+				r = c.pattern[c.pos]
+			}
+			cl.ranges = append(cl.ranges, runeRange{r, r})
+		case r == ']':
+			c.pos++
+			return c.single(inst{op: opClass, class: cl})
+		case c.pos+2 < len(c.pattern) && c.pattern[c.pos+1] == '-':
+			lo, hi := r, c.pattern[c.pos+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			cl.ranges = append(cl.ranges, runeRange{lo, hi})
+			c.pos += 2
+		default:
+			cl.ranges = append(cl.ranges, runeRange{r, r})
+		}
+		c.pos++
+	}
+	return c.single(inst{op: opClass, class: cl})
+}
+
+// alternation compiles comma separated alternatives in braces.
+func (c *compiler) alternation() (frag, error) {
+	begin := c.pos
+	c.pos++
+	var alts []frag
+	for {
+		f, err := c.sequence(true)
+		if err != nil {
+			return frag{}, err
+		}
+		alts = append(alts, f)
+		if c.pos >= len(c.pattern) {
+			return frag{}, fmt.Errorf("invalid pattern %q: unclosed alternation at %d", string(c.pattern), begin)
+		}
+		c.pos++
+		if c.pattern[c.pos-1] == '}' {
+			break
+		}
+	}
+	f := alts[len(alts)-1]
+	for i := len(alts) - 2; i >= 0; i-- {
+		split := c.emit(inst{op: opSplit, out: alts[i].start, out1: f.start})
+		f = frag{split, append(alts[i].outs, f.outs...)}
+	}
+	return f, nil
+}
+
+// literal compiles a rune to match.
+func (c *compiler) literal(r rune) frag {
+	if c.fold {
+		r = foldRune(r)
+	}
+	return c.single(inst{op: opRune, r: r})
+}
+
+// single compiles one instruction with one output.
+func (c *compiler) single(in inst) frag {
+	pc := c.emit(in)
+	return frag{pc, []hole{{pc, false}}}
+}
+
+// star repeats the fragment zero or more times.
+func (c *compiler) star(f frag) frag {
+	split := c.emit(inst{op: opSplit, out: f.start})
+	c.patch(f.outs, split)
+	return frag{split, []hole{{split, true}}}
+}
+
+// optional matches the fragment zero or one times.
+func (c *compiler) optional(f frag) frag {
+	split := c.emit(inst{op: opSplit, out: f.start})
+	return frag{split, append(f.outs, hole{split, true})}
+}
+
+// concat chains the fragments.
+func (c *compiler) concat(frags []frag) frag {
+	if len(frags) == 0 {
+		return c.single(inst{op: opNop})
+	}
+	f := frags[0]
+	for _, next := range frags[1:] {
+		c.patch(f.outs, next.start)
+		f.outs = next.outs
+	}
+	return f
+}
+
+// emit appends the instruction and returns its position.
+func (c *compiler) emit(in inst) int {
+	c.prog.insts = append(c.prog.insts, in)
+	return len(c.prog.insts) - 1
+}
+
+// patch sets the outputs of the holes to the position.
+func (c *compiler) patch(holes []hole, pc int) {
+	for _, h := range holes {
+		if h.alt {
+			c.prog.insts[h.pc].out1 = pc
+		} else {
+			c.prog.insts[h.pc].out = pc
+		}
+	}
+}
+
+// hasPrefix checks if the pattern continues with the prefix.
+func (c *compiler) hasPrefix(prefix string) bool {
+	for i, r := range []rune(prefix) {
+		if c.pos+i >= len(c.pattern) || c.pattern[c.pos+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// index returns the position of the next substring or -1.
+func (c *compiler) index(sub string) int {
+	srs := []rune(sub)
+	for i := c.pos; i+len(srs) <= len(c.pattern); i++ {
+		if string(c.pattern[i:i+len(srs)]) == sub {
+			return i
+		}
+	}
+	return -1
+}
+
+// EOF
@@ -10,10 +10,35 @@
 //
 // - ? matches one char
 // - * matches a group of chars
+// - ** matches a group of chars including separators
 // - [abc] matches any of the chars inside the brackets
 // - [a-z] matches any of the chars of the range
 // - [^abc] matches any but the chars inside the brackets
+// - [[:alpha:]] matches any char of the named class, see below
+// - {a,b,c} matches any of the comma separated alternatives
 // - \ escapes any of the pattern chars
+//
+// Named classes are alpha, digit, alnum, upper, lower, space, blank, punct,
+// print, graph, cntrl, xdigit, and word. They are Unicode aware.
+//
+// Matches keeps the syntax of earlier releases. Braces and named classes
+// are matched literally there, an unclosed class takes the rest of the
+// pattern, and a range may end with the closing bracket. Ignoring the case
+// compares the lower case pattern and value. Some inputs the earlier
+// matcher handled inconsistently now give different results: an unclosed
+// class or a trailing backslash after an asterisk are matched like anywhere
+// else, and a negated class never matches the end of the value. Matches
+// caches the recently used patterns.
+//
+// Compile returns a reusable Pattern. Options set Unicode case folding,
+// a separator like '/' for paths or '.' for topics, and if any substring
+// may match instead of the whole value. With a separator * and ? don't
+// match it while ** does, "a/**/b" also matches "a/b". FindAll returns
+// the positions of all matches.
+//
+// A Set matches a value against many patterns in one pass, e.g. for routing
+// topics or paths. It returns the IDs of all matching patterns. Patterns can
+// be added and removed at runtime.
 package matcher // import "tideland.dev/go/stew/matcher"
 
 // EOF
@@ -2,7 +2,7 @@
 //
 // Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
 //
-// All rights reserved. Use of this source code valuePos governed
+// All rights reserved. Use of this source code is governed
 // by the new BSD license.
 
 package matcher // import "tideland.dev/go/stew/matcher"
@@ -12,7 +12,9 @@ package matcher // import "tideland.dev/go/stew/matcher"
 //--------------------
 
 import (
+	"container/list"
 	"strings"
+	"sync"
 )
 
 //--------------------
@@ -22,196 +24,99 @@ import (
 const (
 	IgnoreCase   bool = true
 	ValidateCase bool = false
-
-	matchSuccess int = iota
-	matchCont
-	matchFail
 )
 
-// matcher is a helper type for string pattern matching.
-type matcher struct {
-	patternRunes []rune
-	patternLen   int
-	patternPos   int
-	valueRunes   []rune
-	valueLen     int
-	valuePos     int
-}
-
-// newMatcher creates the helper type for string pattern matching.
-func newMatcher(pattern, value string, ignoreCase bool) *matcher {
-	if ignoreCase {
-		return newMatcher(strings.ToLower(pattern), strings.ToLower(value), false)
+// Matches checks if the pattern matches a given value. It keeps the
+// syntax it always had: braces and named classes are matched literally,
+// an unclosed class takes the rest of the pattern, and ignoring the case
+// compares the lower case pattern and value. Recently used patterns
+// are cached, compile a Pattern for the extended syntax and options.
+func Matches(pattern, value string, ignoreCase bool) bool {
+	p := patterns.get(pattern, ignoreCase)
+	if p == nil {
+		return false
 	}
-	prs := append([]rune(pattern), '\u0000')
-	vrs := append([]rune(value), '\u0000')
-	return &matcher{
-		patternRunes: prs,
-		patternLen:   len(prs) - 1,
-		patternPos:   0,
-		valueRunes:   vrs,
-		valueLen:     len(vrs) - 1,
-		valuePos:     0,
+	if ignoreCase {
+		value = strings.ToLower(value)
 	}
+	return p.Matches(value)
 }
 
-// matches checks if the value matches the pattern.
-func (m *matcher) matches() bool {
-	// Loop over the pattern.
-	for m.patternLen > 0 {
-		switch m.processPatternRune() {
-		case matchSuccess:
-			return true
-		case matchFail:
-			return false
+//--------------------
+// PATTERN CACHE
+//--------------------
 
-		}
-		m.patternPos++
-		m.patternLen--
-		if m.valueLen == 0 {
-			for m.patternRunes[m.patternPos] == '*' {
-				m.patternPos++
-				m.patternLen--
-			}
-			break
-		}
-	}
-	if m.patternLen == 0 && m.valueLen == 0 {
-		return true
-	}
-	return false
+// maxCachedPatterns limits the number of patterns cached for Matches.
+const maxCachedPatterns = 256
+
+// patterns caches the compiled patterns of Matches.
+var patterns = newPatternCache(maxCachedPatterns)
+
+// cacheKey identifies a cached pattern.
+type cacheKey struct {
+	pattern    string
+	ignoreCase bool
 }
 
-// processPatternRune handles the current leading pattern rune.
-func (m *matcher) processPatternRune() int {
-	switch m.patternRunes[m.patternPos] {
-	case '*':
-		return m.processAsterisk()
-	case '?':
-		return m.processQuestionMark()
-	case '[':
-		return m.processOpenBracket()
-	case '\\':
-		m.processBackslash()
-		fallthrough
-	default:
-		return m.processDefault()
-	}
+// cacheEntry is a cached pattern, nil if it is invalid.
+type cacheEntry struct {
+	key     cacheKey
+	pattern *Pattern
 }
 
-// processAsterisk handles groups of characters.
-func (m *matcher) processAsterisk() int {
-	for m.patternRunes[m.patternPos+1] == '*' {
-		m.patternPos++
-		m.patternLen--
-	}
-	if m.patternLen == 1 {
-		return matchSuccess
-	}
-	for m.valueLen > 0 {
-		patternCopy := make([]rune, len(m.patternRunes[m.patternPos+1:]))
-		valueCopy := make([]rune, len(m.valueRunes[m.valuePos:]))
-		copy(patternCopy, m.patternRunes[m.patternPos+1:])
-		copy(valueCopy, m.valueRunes[m.valuePos:])
-		pam := newMatcher(string(patternCopy), string(valueCopy), false)
-		if pam.matches() {
-			return matchSuccess
-		}
-		m.valuePos++
-		m.valueLen--
-	}
-	return matchFail
+// patternCache caches the least recently used compiled patterns.
+type patternCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	order   *list.List
 }
 
-// processQuestionMark handles a single character.
-func (m *matcher) processQuestionMark() int {
-	if m.valueLen == 0 {
-		return matchFail
+// newPatternCache creates a cache for the given number of patterns.
+func newPatternCache(size int) *patternCache {
+	return &patternCache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
 	}
-	m.valuePos++
-	m.valueLen--
-	return matchCont
 }
 
-// processOpenBracket handles an open bracket for a group of characters.
-func (m *matcher) processOpenBracket() int {
-	m.patternPos++
-	m.patternLen--
-	not := (m.patternRunes[m.patternPos] == '^')
-	match := false
-	if not {
-		m.patternPos++
-		m.patternLen--
+// get returns the cached pattern or compiles and caches it. It
+// returns nil if the pattern is invalid.
+func (pc *patternCache) get(pattern string, ignoreCase bool) *Pattern {
+	key := cacheKey{pattern, ignoreCase}
+	pc.mu.Lock()
+	if elem, ok := pc.entries[key]; ok {
+		pc.order.MoveToFront(elem)
+		pc.mu.Unlock()
+		return elem.Value.(*cacheEntry).pattern
 	}
-group:
-	for {
-		switch {
-		case m.patternRunes[m.patternPos] == '\\':
-			m.patternPos++
-			m.patternLen--
-			if m.patternRunes[m.patternPos] == m.valueRunes[m.valuePos] {
-				match = true
-			}
-		case m.patternRunes[m.patternPos] == ']':
-			break group
-		case m.patternLen == 0:
-			m.patternPos--
-			m.patternLen++
-			break group
-		case m.patternRunes[m.patternPos+1] == '-' && m.patternLen >= 3:
-			start := m.patternRunes[m.patternPos]
-			end := m.patternRunes[m.patternPos+2]
-			vr := m.valueRunes[m.valuePos]
-			if start > end {
-				start, end = end, start
-			}
-			m.patternPos += 2
-			m.patternLen -= 2
-			if vr >= start && vr <= end {
-				match = true
-			}
-		default:
-			if m.patternRunes[m.patternPos] == m.valueRunes[m.valuePos] {
-				match = true
-			}
-		}
-		m.patternPos++
-		m.patternLen--
+	pc.mu.Unlock()
+	// Compile outside the lock, concurrent compilations of the
+	// same pattern are harmless.
+	p := &Pattern{
+		source: pattern,
+		legacy: true,
 	}
-	if not {
-		match = !match
+	if ignoreCase {
+		p.source = strings.ToLower(pattern)
 	}
-	if !match {
-		return matchFail
+	if err := p.compile(); err != nil {
+		p = nil
 	}
-	m.valuePos++
-	m.valueLen--
-	return matchCont
-}
-
-// processBackslash handles escaping via baskslash.
-func (m *matcher) processBackslash() int {
-	if m.patternLen >= 2 {
-		m.patternPos++
-		m.patternLen--
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if elem, ok := pc.entries[key]; ok {
+		pc.order.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).pattern
 	}
-	return matchCont
-}
-
-// processDefault handles any other rune.
-func (m *matcher) processDefault() int {
-	if m.patternRunes[m.patternPos] != m.valueRunes[m.valuePos] {
-		return matchFail
+	pc.entries[key] = pc.order.PushFront(&cacheEntry{key, p})
+	if pc.order.Len() > pc.size {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*cacheEntry).key)
 	}
-	m.valuePos++
-	m.valueLen--
-	return matchCont
-}
-
-// Matches checks if the pattern matches a given value.
-func Matches(pattern, value string, ignoreCase bool) bool {
-	m := newMatcher(pattern, value, ignoreCase)
-	return m.matches()
+	return p
 }
 
 // EOF
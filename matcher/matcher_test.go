@@ -114,4 +114,159 @@ func TestMatches(t *testing.T) {
 	}
 }
 
+// TestPattern tests matching with compiled patterns.
+func TestPattern(t *testing.T) {
+	path := matcher.WithSeparator('/')
+	topic := matcher.WithSeparator('.')
+	fold := matcher.WithIgnoreCase()
+	sub := matcher.WithSubstring()
+	tests := []struct {
+		pattern string
+		options []matcher.Option
+		value   string
+		out     bool
+	}{
+		{"*.go", nil, "cmd/main.go", true},
+		{"*.go", []matcher.Option{path}, "cmd/main.go", false},
+		{"*/*.go", []matcher.Option{path}, "cmd/main.go", true},
+		{"**.go", []matcher.Option{path}, "cmd/main.go", true},
+		{"src/**/*.go", []matcher.Option{path}, "src/main.go", true},
+		{"src/**/*.go", []matcher.Option{path}, "src/a/b/main.go", true},
+		{"src/**/*.go", []matcher.Option{path}, "lib/a/main.go", false},
+		{"**/test", []matcher.Option{path}, "test", true},
+		{"**/test", []matcher.Option{path}, "a/b/test", true},
+		{"src/**", []matcher.Option{path}, "src/a/b", true},
+		{"src/?", []matcher.Option{path}, "src/a", true},
+		{"src?a", []matcher.Option{path}, "src/a", false},
+		{"orders.*.created", []matcher.Option{topic}, "orders.eu.created", true},
+		{"orders.*.created", []matcher.Option{topic}, "orders.eu.de.created", false},
+		{"orders.**", []matcher.Option{topic}, "orders.eu.de.created", true},
+		{"*.{go,md,txt}", nil, "readme.md", true},
+		{"*.{go,md,txt}", nil, "readme.rst", false},
+		{"{src,lib}/{*.go,**/*_test.go}", []matcher.Option{path}, "lib/a/b_test.go", true},
+		{"{src,lib}/{*.go,**/*_test.go}", []matcher.Option{path}, "lib/a/b.go", false},
+		{"file{,.bak}", nil, "file", true},
+		{"file{,.bak}", nil, "file.bak", true},
+		{"[[:alpha:]][[:digit:]]", nil, "ä7", true},
+		{"[[:alpha:]][[:digit:]]", nil, "77", false},
+		{"[[:upper:][:digit:]_]*", nil, "X_9", true},
+		{"[^[:space:]]", nil, "x", true},
+		{"[^[:space:]]", nil, " ", false},
+		{"[[:xdigit:]]", nil, "F", true},
+		{`\{a,b\}`, nil, "{a,b}", true},
+		{"STRASSE", []matcher.Option{fold}, "straße", false},
+		{"ÄRGER", []matcher.Option{fold}, "ärger", true},
+		{"σ*", []matcher.Option{fold}, "ΣΑΣ", true},
+		{"[α-ω]", []matcher.Option{fold}, "Ω", true},
+		{"[α-ω]", nil, "Ω", false},
+		{"fox", []matcher.Option{sub}, "quick brown fox jumps", true},
+		{"f?x", []matcher.Option{sub, fold}, "QUICK BROWN FOX", true},
+		{"cat", []matcher.Option{sub}, "quick brown fox", false},
+		{"fox", nil, "quick brown fox jumps", false},
+	}
+	for _, test := range tests {
+		p, err := matcher.Compile(test.pattern, test.options...)
+		Assert(t, NoError(err), "pattern compiled: "+test.pattern)
+		Assert(t, Equal(p.Matches(test.value), test.out), "match result: "+test.pattern+" / "+test.value)
+	}
+}
+
+// TestPatternErrors tests the compiling of invalid patterns.
+func TestPatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{"[abc", "unclosed class"},
+		{"{a,b", "unclosed alternation"},
+		{"[[:alpha]]", "unclosed named class"},
+		{"[[:greek:]]", "unknown named class"},
+	}
+	for _, test := range tests {
+		_, err := matcher.Compile(test.pattern)
+		Assert(t, ErrorContains(err, test.err), "compile error: "+test.pattern)
+	}
+	Assert(t, Panics(func() { matcher.MustCompile("[abc") }), "must compile panics")
+}
+
+// TestMatchesLegacySyntax tests that Matches keeps its earlier syntax
+// with literal braces and named classes and lenient classes. The last
+// cases pin the results changed for inputs the earlier matcher handled
+// inconsistently.
+func TestMatchesLegacySyntax(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		out     bool
+	}{
+		{"{x}", "{x}", true},
+		{"{a,b", "{a,b", true},
+		{"*.{go,md}", "main.go", false},
+		{"*.{go,md}", "main.{go,md}", true},
+		{"[[:digit:]]", "d]", true},
+		{"[[:digit:]]", "7", false},
+		{"[abc", "b", true},
+		{"[abc", "[abc", false},
+		{"x[^a", "xb", true},
+		{"[", "", false},
+		{"[a-]", "_", true},
+		{"[\\a-c]", "-", true},
+		{"[\\a-c]", "b", false},
+		// Changed results.
+		{"*[abc", "xa", true},
+		{"*?[", "{", false},
+		{"*\\", "a\\", true},
+		{"*\\", "a", false},
+		{"[^]*", "", false},
+	}
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			// Second run uses the cached pattern.
+			out := matcher.Matches(test.pattern, test.value, matcher.ValidateCase)
+			Assert(t, Equal(out, test.out), "match result: "+test.pattern+" / "+test.value)
+		}
+	}
+	Assert(t, True(matcher.Matches("[A-Z]x", "QX", matcher.IgnoreCase)), "lower case pattern and value")
+	Assert(t, True(matcher.Matches("[Z-a]", "m", matcher.IgnoreCase)), "lower case range")
+	Assert(t, True(matcher.Matches("{X}", "{x}", matcher.IgnoreCase)), "cached per case mode")
+	Assert(t, False(matcher.Matches("{X}", "{x}", matcher.ValidateCase)), "cached per case mode")
+}
+
+// TestFindAll tests finding the positions of matches.
+func TestFindAll(t *testing.T) {
+	p := matcher.MustCompile("f?x", matcher.WithSubstring(), matcher.WithIgnoreCase())
+	Assert(t, DeepEqual(p.FindAll("a fox, a FAX, a fix", -1), [][]int{{2, 5}, {9, 12}, {16, 19}}), "all matches")
+	Assert(t, DeepEqual(p.FindAll("a fox, a FAX, a fix", 2), [][]int{{2, 5}, {9, 12}}), "limited matches")
+	Assert(t, Nil(p.FindAll("no match", -1)), "no matches")
+
+	p = matcher.MustCompile("b*", matcher.WithSubstring())
+	Assert(t, DeepEqual(p.FindAll("abbcb", -1), [][]int{{1, 5}}), "longest match")
+
+	p = matcher.MustCompile("[[:digit:]][[:digit:]]", matcher.WithSubstring())
+	Assert(t, DeepEqual(p.FindAll("ä 12 öü 345", -1), [][]int{{3, 5}, {11, 13}}), "byte positions with multibyte runes")
+
+	p = matcher.MustCompile("src/*.go", matcher.WithSeparator('/'))
+	Assert(t, DeepEqual(p.FindAll("src/main.go", -1), [][]int{{0, 11}}), "anchored match")
+	Assert(t, Nil(p.FindAll("src/cmd/main.go", -1)), "no anchored match")
+}
+
+//--------------------
+// BENCHMARKS
+//--------------------
+
+// BenchmarkMatches checks the performance of repeated one-off matching.
+func BenchmarkMatches(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		matcher.Matches("src/*/[a-z]*.go", "src/matcher/pattern.go", matcher.ValidateCase)
+	}
+}
+
+// BenchmarkPattern checks the performance of a compiled pattern.
+func BenchmarkPattern(b *testing.B) {
+	p := matcher.MustCompile("src/**/{*.go,*.md}", matcher.WithSeparator('/'))
+	for i := 0; i < b.N; i++ {
+		p.Matches("src/tideland/go/stew/matcher/pattern.go")
+	}
+}
+
 // EOF
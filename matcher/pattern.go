@@ -0,0 +1,281 @@
+// Tideland Go Stew - Matcher
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package matcher // import "tideland.dev/go/stew/matcher"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option defines the signature of a pattern option setting function.
+type Option func(p *Pattern) error
+
+// WithIgnoreCase lets the pattern ignore the case using Unicode
+// case folding.
+func WithIgnoreCase() Option {
+	return func(p *Pattern) error {
+		p.fold = true
+		return nil
+	}
+}
+
+// WithSeparator sets a separator, e.g. '/' for paths or '.' for topics.
+// Then * and ? don't match it while ** matches across it.
+func WithSeparator(separator rune) Option {
+	return func(p *Pattern) error {
+		p.separator = separator
+		return nil
+	}
+}
+
+// WithSubstring lets the pattern match any substring of a value
+// instead of the whole value.
+func WithSubstring() Option {
+	return func(p *Pattern) error {
+		p.substring = true
+		return nil
+	}
+}
+
+//--------------------
+// PATTERN
+//--------------------
+
+// Pattern is a compiled pattern. It can be used concurrently.
+type Pattern struct {
+	source    string
+	fold      bool
+	separator rune
+	substring bool
+	legacy    bool
+	prog      *program
+	machines  sync.Pool
+}
+
+// Compile compiles the pattern with the options.
+func Compile(pattern string, options ...Option) (*Pattern, error) {
+	p := &Pattern{
+		source: pattern,
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// MustCompile compiles the pattern like Compile but panics
+// if it is invalid.
+func MustCompile(pattern string, options ...Option) *Pattern {
+	p, err := Compile(pattern, options...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the pattern.
+func (p *Pattern) String() string {
+	return p.source
+}
+
+// compile compiles the source with the settings of the pattern.
+func (p *Pattern) compile() error {
+	prog, err := compile(p.source, p)
+	if err != nil {
+		return err
+	}
+	p.prog = prog
+	p.machines.New = func() any {
+		return newMachine(p.prog, p.fold, p.separator)
+	}
+	return nil
+}
+
+// Matches checks if the pattern matches the value or, with
+// WithSubstring, a part of it.
+func (p *Pattern) Matches(value string) bool {
+	m := p.machines.Get().(*machine)
+	defer p.machines.Put(m)
+	if !p.substring {
+		return m.longest(value, 0) == len(value)
+	}
+	return m.anywhere(value)
+}
+
+// FindAll returns the byte positions of the successive not overlapping
+// matches in the value like the regexp package. At each position the
+// longest match is taken, empty ones are skipped. Without WithSubstring
+// only the whole value can match. A negative n returns all matches,
+// otherwise at most n.
+func (p *Pattern) FindAll(value string, n int) [][]int {
+	if n == 0 {
+		return nil
+	}
+	m := p.machines.Get().(*machine)
+	defer p.machines.Put(m)
+	if !p.substring {
+		if m.longest(value, 0) == len(value) {
+			return [][]int{{0, len(value)}}
+		}
+		return nil
+	}
+	var matches [][]int
+	for pos := 0; pos < len(value); {
+		end := m.longest(value, pos)
+		if end > pos {
+			matches = append(matches, []int{pos, end})
+			if len(matches) == n {
+				break
+			}
+			pos = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(value[pos:])
+		pos += size
+	}
+	return matches
+}
+
+//--------------------
+// MACHINE
+//--------------------
+
+// machine runs a program on values simulating all possible
+// paths in parallel.
+type machine struct {
+	prog      *program
+	fold      bool
+	separator rune
+	marks     []int
+	gen       int
+	lists     [2][]int
+}
+
+// newMachine creates a machine for the program.
+func newMachine(prog *program, fold bool, separator rune) *machine {
+	return &machine{
+		prog:      prog,
+		fold:      fold,
+		separator: separator,
+		marks:     make([]int, len(prog.insts)),
+		lists: [2][]int{
+			make([]int, 0, len(prog.insts)),
+			make([]int, 0, len(prog.insts)),
+		},
+	}
+}
+
+// longest returns the end of the longest match starting at the
+// position or -1 if there's none.
+func (m *machine) longest(value string, pos int) int {
+	m.gen++
+	current := m.add(m.lists[0][:0], m.prog.start)
+	last := -1
+	for {
+		if m.matched(current) {
+			last = pos
+		}
+		if len(current) == 0 || pos >= len(value) {
+			return last
+		}
+		r, size := utf8.DecodeRuneInString(value[pos:])
+		current = m.step(current, r)
+		pos += size
+	}
+}
+
+// anywhere checks if the program matches any substring.
+func (m *machine) anywhere(value string) bool {
+	m.gen++
+	current := m.add(m.lists[0][:0], m.prog.start)
+	for pos := 0; ; {
+		if m.matched(current) {
+			return true
+		}
+		if pos >= len(value) {
+			return false
+		}
+		r, size := utf8.DecodeRuneInString(value[pos:])
+		current = m.step(current, r)
+		current = m.add(current, m.prog.start)
+		pos += size
+	}
+}
+
+// step returns the instructions following the current ones
+// matching the rune. The two lists of the machine are used
+// alternately.
+func (m *machine) step(current []int, r rune) []int {
+	m.gen++
+	folded := r
+	if m.fold {
+		folded = foldRune(r)
+	}
+	next := m.lists[1][:0]
+	m.lists[0], m.lists[1] = m.lists[1], m.lists[0]
+	for _, pc := range current {
+		in := &m.prog.insts[pc]
+		ok := false
+		switch in.op {
+		case opRune:
+			ok = in.r == folded
+		case opClass:
+			ok = in.class.contains(r, m.fold)
+		case opAny:
+			ok = m.separator == 0 || r != m.separator
+		case opAnyAll:
+			ok = true
+		}
+		if ok {
+			next = m.add(next, in.out)
+		}
+	}
+	return next
+}
+
+// add adds the instruction and those reachable without consuming
+// a rune to the list.
+func (m *machine) add(list []int, pc int) []int {
+	if m.marks[pc] == m.gen {
+		return list
+	}
+	m.marks[pc] = m.gen
+	switch in := &m.prog.insts[pc]; in.op {
+	case opSplit:
+		list = m.add(list, in.out)
+		return m.add(list, in.out1)
+	case opNop:
+		return m.add(list, in.out)
+	}
+	return append(list, pc)
+}
+
+// matched checks if the list contains the match instruction.
+func (m *machine) matched(list []int) bool {
+	for _, pc := range list {
+		if m.prog.insts[pc].op == opMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,308 @@
+// Tideland Go Stew - Matcher
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package matcher // import "tideland.dev/go/stew/matcher"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//--------------------
+// SET
+//--------------------
+
+// maxSetStates limits the number of cached states of a set. If it
+// is reached the cache is cleared.
+const maxSetStates = 10000
+
+// Set matches values against many patterns in one pass. The patterns
+// are compiled into one shared automaton whose states are cached while
+// matching. So the costs per rune are independent of the number of
+// patterns. Patterns can be added and removed at runtime, a set can
+// be used concurrently. Matching needs no locks.
+type Set struct {
+	mu       sync.Mutex
+	options  Pattern
+	patterns map[string]string
+	version  uint64
+	automat  atomic.Pointer[automaton]
+}
+
+// NewSet creates an empty set. The options are used for all patterns.
+func NewSet(options ...Option) (*Set, error) {
+	s := &Set{
+		patterns: make(map[string]string),
+	}
+	for _, option := range options {
+		if err := option(&s.options); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add compiles the pattern and adds it with the ID. A pattern with
+// the same ID is replaced.
+func (s *Set) Add(id, pattern string) error {
+	if _, err := compile(pattern, &s.options); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[id] = pattern
+	s.invalidate()
+	return nil
+}
+
+// Remove removes the pattern with the ID.
+func (s *Set) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.patterns[id]; ok {
+		delete(s.patterns, id)
+		s.invalidate()
+	}
+}
+
+// Len returns the number of patterns.
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.patterns)
+}
+
+// Match returns the sorted IDs of all patterns matching the value.
+func (s *Set) Match(value string) []string {
+	a, err := s.automaton()
+	if err != nil {
+		return nil
+	}
+	return a.match(value)
+}
+
+// invalidate drops the automaton after a change of the patterns.
+// The caller must hold the lock.
+func (s *Set) invalidate() {
+	s.version++
+	s.automat.Store(nil)
+}
+
+// automaton returns the current automaton. After changes of the
+// patterns it's created lazily outside of the lock and only published
+// if the patterns haven't changed in between.
+func (s *Set) automaton() (*automaton, error) {
+	if a := s.automat.Load(); a != nil {
+		return a, nil
+	}
+	s.mu.Lock()
+	version := s.version
+	patterns := make(map[string]string, len(s.patterns))
+	for id, pattern := range s.patterns {
+		patterns[id] = pattern
+	}
+	s.mu.Unlock()
+	a, err := newAutomaton(patterns, &s.options)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.version == version {
+		s.automat.CompareAndSwap(nil, a)
+	}
+	s.mu.Unlock()
+	return a, nil
+}
+
+//--------------------
+// AUTOMATON
+//--------------------
+
+// transitions maps runes to the following states.
+type transitions map[rune]*state
+
+// state is a cached state of the automaton. It contains the
+// instructions of all patterns active at this point. The transitions
+// are copied on write, so they can be read without a lock.
+type state struct {
+	pcs     []int
+	matches []int
+	next    atomic.Pointer[transitions]
+}
+
+// lookup returns the cached state following for the rune.
+func (st *state) lookup(r rune) (*state, bool) {
+	next := st.next.Load()
+	if next == nil {
+		return nil, false
+	}
+	nst, ok := (*next)[r]
+	return nst, ok
+}
+
+// dead checks if no pattern can match anymore.
+func (st *state) dead() bool {
+	return len(st.pcs) == 0
+}
+
+// automaton is the shared program of the patterns of a set. Its states
+// are created on demand and cached. Only their creation is locked.
+type automaton struct {
+	mu        sync.Mutex
+	prog      *program
+	ids       []string
+	substring bool
+	machine   *machine
+	states    map[string]*state
+	start     atomic.Pointer[state]
+}
+
+// newAutomaton compiles the patterns with the options into one program.
+func newAutomaton(patterns map[string]string, options *Pattern) (*automaton, error) {
+	a := &automaton{
+		prog:      &program{},
+		substring: options.substring,
+	}
+	for id := range patterns {
+		a.ids = append(a.ids, id)
+	}
+	sort.Strings(a.ids)
+	// Chain the starts of all patterns by splits. Without patterns
+	// the start matches no rune.
+	start := len(a.prog.insts)
+	a.prog.insts = append(a.prog.insts, inst{op: opRune, r: -1})
+	for i := len(a.ids) - 1; i >= 0; i-- {
+		pc, err := a.prog.compile(patterns[a.ids[i]], i, options)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile pattern %q: %v", a.ids[i], err)
+		}
+		if i == len(a.ids)-1 {
+			start = pc
+			continue
+		}
+		a.prog.insts = append(a.prog.insts, inst{op: opSplit, out: pc, out1: start})
+		start = len(a.prog.insts) - 1
+	}
+	a.prog.start = start
+	a.machine = newMachine(a.prog, options.fold, options.separator)
+	a.reset()
+	return a, nil
+}
+
+// reset clears the cache and creates the start state. Running
+// matches continue with the states they already have.
+func (a *automaton) reset() {
+	a.states = make(map[string]*state)
+	a.machine.gen++
+	a.start.Store(a.cache(a.machine.add(a.machine.lists[0][:0], a.prog.start)))
+}
+
+// match returns the IDs of the patterns matching the value.
+func (a *automaton) match(value string) []string {
+	st := a.start.Load()
+	var matched []int
+	if a.substring {
+		matched = append(matched, st.matches...)
+	}
+	for _, r := range value {
+		st = a.step(st, r)
+		if a.substring {
+			matched = append(matched, st.matches...)
+		} else if st.dead() {
+			return nil
+		}
+	}
+	if !a.substring {
+		matched = append(matched, st.matches...)
+	}
+	return a.names(matched)
+}
+
+// step returns the state following the state for the rune.
+func (a *automaton) step(st *state, r rune) *state {
+	if next, ok := st.lookup(r); ok {
+		return next
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if next, ok := st.lookup(r); ok {
+		return next
+	}
+	if len(a.states) >= maxSetStates {
+		a.reset()
+	}
+	pcs := a.machine.step(st.pcs, r)
+	if a.substring {
+		pcs = a.machine.add(pcs, a.prog.start)
+	}
+	next := a.cache(pcs)
+	old := st.next.Load()
+	size := 1
+	if old != nil {
+		size += len(*old)
+	}
+	ts := make(transitions, size)
+	if old != nil {
+		for or, ost := range *old {
+			ts[or] = ost
+		}
+	}
+	ts[r] = next
+	st.next.Store(&ts)
+	return next
+}
+
+// cache returns the cached state for the instructions or
+// creates it. The caller must hold the lock.
+func (a *automaton) cache(pcs []int) *state {
+	sorted := append([]int(nil), pcs...)
+	sort.Ints(sorted)
+	var key strings.Builder
+	for _, pc := range sorted {
+		key.WriteString(strconv.Itoa(pc))
+		key.WriteByte(',')
+	}
+	if st, ok := a.states[key.String()]; ok {
+		return st
+	}
+	st := &state{
+		pcs: sorted,
+	}
+	for _, pc := range sorted {
+		if in := a.prog.insts[pc]; in.op == opMatch {
+			st.matches = append(st.matches, in.id)
+		}
+	}
+	a.states[key.String()] = st
+	return st
+}
+
+// names returns the sorted unique IDs of the matched patterns.
+func (a *automaton) names(matched []int) []string {
+	if len(matched) == 0 {
+		return nil
+	}
+	sort.Ints(matched)
+	var ids []string
+	for i, id := range matched {
+		if i > 0 && matched[i-1] == id {
+			continue
+		}
+		ids = append(ids, a.ids[id])
+	}
+	return ids
+}
+
+// EOF
@@ -0,0 +1,163 @@
+// Tideland Go Stew - Matcher - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package matcher_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/matcher"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSet tests matching many patterns at once.
+func TestSet(t *testing.T) {
+	s, err := matcher.NewSet(matcher.WithSeparator('.'))
+	Assert(t, NoError(err), "set created")
+	Assert(t, Nil(s.Match("orders.eu.created")), "empty set matches nothing")
+
+	Assert(t, NoError(s.Add("all", "**")), "pattern added")
+	Assert(t, NoError(s.Add("orders", "orders.**")), "pattern added")
+	Assert(t, NoError(s.Add("created", "*.*.created")), "pattern added")
+	Assert(t, NoError(s.Add("eu", "orders.{eu,uk}.*")), "pattern added")
+	Assert(t, NoError(s.Add("us", "orders.us.*")), "pattern added")
+	Assert(t, ErrorContains(s.Add("invalid", "orders.[eu"), "unclosed class"), "invalid pattern rejected")
+	Assert(t, Equal(s.Len(), 5), "five patterns")
+
+	Assert(t, DeepEqual(s.Match("orders.eu.created"), []string{"all", "created", "eu", "orders"}), "matching IDs")
+	Assert(t, DeepEqual(s.Match("orders.us.deleted"), []string{"all", "orders", "us"}), "matching IDs")
+	Assert(t, DeepEqual(s.Match("users.de.created"), []string{"all", "created"}), "matching IDs")
+	Assert(t, DeepEqual(s.Match("orders.eu.created"), []string{"all", "created", "eu", "orders"}), "cached states")
+
+	s.Remove("all")
+	s.Remove("unknown")
+	Assert(t, Equal(s.Len(), 4), "four patterns")
+	Assert(t, Nil(s.Match("users.de")), "no match after removal")
+	Assert(t, NoError(s.Add("us", "users.*")), "pattern replaced")
+	Assert(t, DeepEqual(s.Match("users.de"), []string{"us"}), "replaced pattern matches")
+	Assert(t, DeepEqual(s.Match("orders.us.created.now"), []string{"orders"}), "only globstar matches deeper")
+}
+
+// TestSetOptions tests sets with case folding and substring matching.
+func TestSetOptions(t *testing.T) {
+	s, err := matcher.NewSet(matcher.WithIgnoreCase(), matcher.WithSubstring())
+	Assert(t, NoError(err), "set created")
+	Assert(t, NoError(s.Add("fox", "f?x")), "pattern added")
+	Assert(t, NoError(s.Add("dog", "lazy d[[:alpha:]]g")), "pattern added")
+	Assert(t, NoError(s.Add("cat", "cat")), "pattern added")
+
+	Assert(t, DeepEqual(s.Match("The quick brown FOX jumps over the LAZY DOG"), []string{"dog", "fox"}), "substrings matched")
+	Assert(t, DeepEqual(s.Match("Über die Straße fix"), []string{"fox"}), "substring with multibyte runes")
+	Assert(t, Nil(s.Match("nothing here")), "no substring matched")
+}
+
+// TestSetConcurrent tests matching and changing a set concurrently.
+func TestSetConcurrent(t *testing.T) {
+	s, err := matcher.NewSet(matcher.WithSeparator('/'))
+	Assert(t, NoError(err), "set created")
+	for i := 0; i < 50; i++ {
+		Assert(t, NoError(s.Add(fmt.Sprintf("p%02d", i), fmt.Sprintf("api/v%d/**", i))), "pattern added")
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				value := fmt.Sprintf("api/v%d/users/%d", i%50, g)
+				ids := s.Match(value)
+				if len(ids) != 1 || ids[0] != fmt.Sprintf("p%02d", i%50) {
+					t.Errorf("unexpected match of %q: %v", value, ids)
+				}
+			}
+		}(g)
+	}
+	for i := 50; i < 60; i++ {
+		s.Add(fmt.Sprintf("x%02d", i), fmt.Sprintf("other/v%d/*", i))
+	}
+	wg.Wait()
+}
+
+//--------------------
+// BENCHMARKS
+//--------------------
+
+// routes returns the patterns and a value to route.
+func routes(n int) ([]string, string) {
+	patterns := make([]string, n)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("service%d.*.{created,deleted}.**", i)
+	}
+	return patterns, fmt.Sprintf("service%d.eu.created.order.4711", n/2)
+}
+
+// BenchmarkMatchesLoop checks routing by calling Matches for each pattern.
+func BenchmarkMatchesLoop(b *testing.B) {
+	patterns, value := routes(200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, pattern := range patterns {
+			matcher.Matches(pattern, value, matcher.ValidateCase)
+		}
+	}
+}
+
+// BenchmarkPatternLoop checks routing by a loop over compiled patterns.
+func BenchmarkPatternLoop(b *testing.B) {
+	patterns, value := routes(200)
+	compiled := make([]*matcher.Pattern, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = matcher.MustCompile(pattern, matcher.WithSeparator('.'))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range compiled {
+			p.Matches(value)
+		}
+	}
+}
+
+// BenchmarkSet checks routing by a set.
+func BenchmarkSet(b *testing.B) {
+	patterns, value := routes(200)
+	s, _ := matcher.NewSet(matcher.WithSeparator('.'))
+	for i, pattern := range patterns {
+		s.Add(fmt.Sprint(i), pattern)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Match(value)
+	}
+}
+
+// BenchmarkSetParallel checks routing by a set used concurrently.
+func BenchmarkSetParallel(b *testing.B) {
+	patterns, value := routes(200)
+	s, _ := matcher.NewSet(matcher.WithSeparator('.'))
+	for i, pattern := range patterns {
+		s.Add(fmt.Sprint(i), pattern)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Match(value)
+		}
+	})
+}
+
+// EOF
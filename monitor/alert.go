@@ -0,0 +1,373 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tideland.dev/go/stew/timex"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// SOURCES
+//--------------------
+
+// Source reads a value of a monitor at the time of the evaluation
+// given by the clock of the alerter. The bool is false if there's
+// no value yet.
+type Source func(m *Monitor, now time.Time) (float64, bool)
+
+// ReadPercentile reads the percentile of a stop watch in seconds, e.g.
+// 0.99 for p99. A window larger than zero only covers the measurings
+// of the last minutes before now.
+func ReadPercentile(id string, q float64, window time.Duration) Source {
+	return func(m *Monitor, now time.Time) (float64, bool) {
+		w, ok := m.sw.watches.lookup(id, nil)
+		if !ok {
+			return 0, false
+		}
+		h := w.histogram(now, window)
+		if h.Count() == 0 {
+			return 0, false
+		}
+		return h.Quantile(q).Seconds(), true
+	}
+}
+
+// ReadIndicator reads the current value of a stay-set indicator.
+func ReadIndicator(id string) Source {
+	return func(m *Monitor, _ time.Time) (float64, bool) {
+		iv, err := m.ssi.Read(id)
+		if err != nil {
+			return 0, false
+		}
+		return float64(iv.Current), true
+	}
+}
+
+// ReadCounter reads the value of a counter.
+func ReadCounter(name string, labels Labels) Source {
+	return func(m *Monitor, _ time.Time) (float64, bool) {
+		c, ok := m.counters.lookup(name, labels)
+		if !ok {
+			return 0, false
+		}
+		return float64(c.Value()), true
+	}
+}
+
+// ReadGauge reads the value of a gauge.
+func ReadGauge(name string, labels Labels) Source {
+	return func(m *Monitor, _ time.Time) (float64, bool) {
+		g, ok := m.gauges.lookup(name, labels)
+		if !ok {
+			return 0, false
+		}
+		return g.Value(), true
+	}
+}
+
+//--------------------
+// RULES
+//--------------------
+
+// Rule describes a condition of monitor values. The check returns the
+// current value and if the condition is violated. An alert fires if it
+// is violated at least for the duration and resolves as soon as it is
+// not violated anymore.
+type Rule struct {
+	ID    string
+	For   time.Duration
+	Check func(m *Monitor, now time.Time) (float64, bool)
+}
+
+// Above creates a rule violated when the value of the source is
+// above the threshold, e.g. the p99 of a stop watch in seconds.
+func Above(id string, source Source, threshold float64, duration time.Duration) Rule {
+	return Rule{
+		ID:  id,
+		For: duration,
+		Check: func(m *Monitor, now time.Time) (float64, bool) {
+			value, ok := source(m, now)
+			return value, ok && value > threshold
+		},
+	}
+}
+
+// Below creates a rule violated when the value of the source is
+// below the threshold.
+func Below(id string, source Source, threshold float64, duration time.Duration) Rule {
+	return Rule{
+		ID:  id,
+		For: duration,
+		Check: func(m *Monitor, now time.Time) (float64, bool) {
+			value, ok := source(m, now)
+			return value, ok && value < threshold
+		},
+	}
+}
+
+// Absent creates a rule violated when the stop watch with the ID had
+// no measuring during the last duration, e.g. a heartbeat. The value is
+// the age of the last measuring in seconds. If there never was one it's
+// counted from the first check.
+func Absent(id, watchID string, duration time.Duration) Rule {
+	var first time.Time
+	return Rule{
+		ID: id,
+		Check: func(m *Monitor, now time.Time) (float64, bool) {
+			if first.IsZero() {
+				first = now
+			}
+			last := first
+			if w, ok := m.sw.watches.lookup(watchID, nil); ok {
+				if lm := w.lastMeasuring(); !lm.IsZero() {
+					last = lm
+				}
+			}
+			age := now.Sub(last)
+			return age.Seconds(), age > duration
+		},
+	}
+}
+
+//--------------------
+// ALERTS
+//--------------------
+
+// Alert describes the state of a rule when firing or resolving.
+type Alert struct {
+	RuleID string
+	Firing bool
+	Value  float64
+	Since  time.Time
+	Time   time.Time
+}
+
+// String implements fmt.Stringer.
+func (a Alert) String() string {
+	state := "resolved"
+	if a.Firing {
+		state = "firing"
+	}
+	return fmt.Sprintf("%s %s since %v (value %g)", a.RuleID, state, a.Since.Format(time.RFC3339), a.Value)
+}
+
+// AlertHandler is called when an alert fires or resolves.
+type AlertHandler func(alert Alert)
+
+// AlerterOption defines the signature of an alerter option setting function.
+type AlerterOption func(a *Alerter) error
+
+// WithFireHandler sets the handler called when an alert fires.
+func WithFireHandler(handler AlertHandler) AlerterOption {
+	return func(a *Alerter) error {
+		a.onFire = handler
+		return nil
+	}
+}
+
+// WithResolveHandler sets the handler called when a firing alert resolves.
+func WithResolveHandler(handler AlertHandler) AlerterOption {
+	return func(a *Alerter) error {
+		a.onResolve = handler
+		return nil
+	}
+}
+
+// WithAlerterClock sets the clock of the alerter and its schedule. By
+// default it's the real clock, a fake one allows deterministic tests.
+// Rules are evaluated at the time of this clock while the measurings
+// of the stop watches carry the real time. So a fake clock has to be
+// started at the real time, e.g. wait.NewFakeClock(time.Now()).
+func WithAlerterClock(clock wait.Clock) AlerterOption {
+	return func(a *Alerter) error {
+		if clock == nil {
+			return fmt.Errorf("invalid alerter option: clock is nil")
+		}
+		a.clock = clock
+		return nil
+	}
+}
+
+// ruleState contains a rule and its evaluation state.
+type ruleState struct {
+	rule     Rule
+	violated time.Time
+	firing   bool
+	value    float64
+}
+
+// alerterJobID is the ID of the evaluation job in the crontab.
+const alerterJobID = "monitor-alerter"
+
+// Alerter evaluates rules over the values of a monitor in an interval.
+// Rules firing or resolving are passed to the handlers.
+type Alerter struct {
+	mu        sync.Mutex
+	monitor   *Monitor
+	clock     wait.Clock
+	rules     map[string]*ruleState
+	onFire    AlertHandler
+	onResolve AlertHandler
+	crontab   *timex.Crontab
+}
+
+// NewAlerter creates an alerter for the monitor evaluating the rules in
+// the interval until the context is canceled or the alerter is stopped.
+func NewAlerter(ctx context.Context, m *Monitor, interval time.Duration, options ...AlerterOption) (*Alerter, error) {
+	a := &Alerter{
+		monitor: m,
+		clock:   wait.RealClock(),
+		rules:   make(map[string]*ruleState),
+	}
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+	ct, err := timex.NewCrontab(ctx, interval, timex.WithClock(a.clock))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create alerter schedule: %v", err)
+	}
+	err = ct.AddJob(alerterJobID, timex.Every(interval), func(ctx context.Context) (bool, error) {
+		a.Evaluate()
+		return true, nil
+	}, timex.WithOverlap(timex.OverlapSkip))
+	if err != nil {
+		ct.Stop()
+		return nil, fmt.Errorf("cannot schedule alerter: %v", err)
+	}
+	a.crontab = ct
+	return a, nil
+}
+
+// Add adds a rule. An existing one with the same ID is replaced.
+func (a *Alerter) Add(rule Rule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("invalid rule: empty ID")
+	}
+	if rule.Check == nil {
+		return fmt.Errorf("invalid rule %q: no check", rule.ID)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules[rule.ID] = &ruleState{rule: rule}
+	return nil
+}
+
+// Remove removes a rule. A firing alert of it doesn't resolve.
+func (a *Alerter) Remove(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.rules, id)
+}
+
+// Evaluate checks all rules immediately.
+func (a *Alerter) Evaluate() {
+	a.mu.Lock()
+	now := a.clock.Now()
+	var fired, resolved []Alert
+	for _, rs := range a.rules {
+		value, violated := rs.rule.Check(a.monitor, now)
+		rs.value = value
+		switch {
+		case violated && rs.violated.IsZero():
+			rs.violated = now
+			fallthrough
+		case violated:
+			if !rs.firing && now.Sub(rs.violated) >= rs.rule.For {
+				rs.firing = true
+				fired = append(fired, rs.alert(now))
+			}
+		case rs.firing:
+			alert := rs.alert(now)
+			alert.Firing = false
+			resolved = append(resolved, alert)
+			rs.firing = false
+			rs.violated = time.Time{}
+		default:
+			rs.violated = time.Time{}
+		}
+	}
+	a.mu.Unlock()
+	// Call the handlers outside the lock.
+	for _, alert := range sortAlerts(fired) {
+		if a.onFire != nil {
+			a.onFire(alert)
+		}
+	}
+	for _, alert := range sortAlerts(resolved) {
+		if a.onResolve != nil {
+			a.onResolve(alert)
+		}
+	}
+}
+
+// Alerts returns the currently firing alerts sorted by rule ID.
+func (a *Alerter) Alerts() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock.Now()
+	var alerts []Alert
+	for _, rs := range a.rules {
+		if rs.firing {
+			alerts = append(alerts, rs.alert(now))
+		}
+	}
+	return sortAlerts(alerts)
+}
+
+// HealthCheck returns a health check failing while alerts are firing.
+func (a *Alerter) HealthCheck() HealthCheck {
+	return func(ctx context.Context) error {
+		alerts := a.Alerts()
+		if len(alerts) == 0 {
+			return nil
+		}
+		ids := make([]string, len(alerts))
+		for i, alert := range alerts {
+			ids[i] = alert.RuleID
+		}
+		return fmt.Errorf("alerts firing: %s", strings.Join(ids, ", "))
+	}
+}
+
+// Stop terminates the evaluation of the rules.
+func (a *Alerter) Stop() error {
+	return a.crontab.Stop()
+}
+
+// alert returns the alert for the rule state.
+func (rs *ruleState) alert(now time.Time) Alert {
+	return Alert{
+		RuleID: rs.rule.ID,
+		Firing: rs.firing,
+		Value:  rs.value,
+		Since:  rs.violated,
+		Time:   now,
+	}
+}
+
+// sortAlerts sorts the alerts by rule ID.
+func sortAlerts(alerts []Alert) []Alert {
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].RuleID < alerts[j].RuleID })
+	return alerts
+}
+
+// EOF
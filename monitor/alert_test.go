@@ -0,0 +1,210 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestAlertRules verifies firing and resolving of threshold rules.
+func TestAlertRules(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+	clock := wait.NewFakeClock(time.Now())
+	events := &alertEvents{}
+	a, err := monitor.NewAlerter(context.Background(), m, time.Hour,
+		monitor.WithAlerterClock(clock),
+		monitor.WithFireHandler(events.add),
+		monitor.WithResolveHandler(events.add))
+	Assert(t, NoError(err), "alerter created")
+	defer a.Stop()
+
+	err = a.Add(monitor.Above("conns", monitor.ReadIndicator("conns"), 2, 0))
+	Assert(t, NoError(err), "indicator rule added")
+	err = a.Add(monitor.Above("slow-db", monitor.ReadPercentile("db.query", 0.99, 0), 0.002, 2*time.Minute))
+	Assert(t, NoError(err), "percentile rule added")
+	err = a.Add(monitor.Rule{ID: "invalid"})
+	Assert(t, ErrorContains(err, "no check"), "rule without check rejected")
+
+	// No values yet.
+	a.Evaluate()
+	Assert(t, Empty(events.get()), "no alerts without values")
+
+	// Indicator fires immediately.
+	for i := 0; i < 3; i++ {
+		m.StaySetIndicator().Increase("conns")
+	}
+	a.Evaluate()
+	Assert(t, Length(events.get(), 1), "indicator alert fired")
+	Assert(t, Equal(events.get()[0].RuleID, "conns"), "indicator alert")
+	Assert(t, True(events.get()[0].Firing), "alert is firing")
+	Assert(t, Equal(events.get()[0].Value, 4.0), "value of alert")
+
+	// Percentile has to be violated for two minutes.
+	m.StopWatch().Measure("db.query", func() { time.Sleep(5 * time.Millisecond) })
+	a.Evaluate()
+	Assert(t, Length(events.get(), 1), "not yet violated long enough")
+	clock.Advance(time.Minute)
+	a.Evaluate()
+	Assert(t, Length(events.get(), 1), "still not violated long enough")
+	clock.Advance(time.Minute)
+	a.Evaluate()
+	Assert(t, Length(events.get(), 2), "slow alert fired")
+	Assert(t, Equal(events.get()[1].RuleID, "slow-db"), "slow alert")
+	Assert(t, Length(a.Alerts(), 2), "two alerts firing")
+
+	// Resolve both.
+	m.Reset()
+	a.Evaluate()
+	Assert(t, Length(events.get(), 4), "alerts resolved")
+	Assert(t, False(events.get()[2].Firing), "alert resolved")
+	Assert(t, Empty(a.Alerts()), "no alerts firing")
+}
+
+// TestAlertPercentile verifies the percentile source.
+func TestAlertPercentile(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	source := monitor.ReadPercentile("sleep", 0.5, time.Minute)
+	_, ok := source(m, time.Now())
+	Assert(t, False(ok), "no value without watch")
+	m.StopWatch().Measure("sleep", func() { time.Sleep(5 * time.Millisecond) })
+	value, ok := source(m, time.Now())
+	Assert(t, True(ok), "value of watch")
+	Assert(t, True(value >= 0.005), "percentile in seconds")
+
+	// The window ends at the time of the evaluation.
+	_, ok = source(m, time.Now().Add(5*time.Minute))
+	Assert(t, False(ok), "measuring outside of window")
+}
+
+// TestAlertAbsent verifies the rule for missing measurings scheduled
+// by the fake clock.
+func TestAlertAbsent(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+	clock := wait.NewFakeClock(time.Now())
+	events := &alertEvents{}
+	a, err := monitor.NewAlerter(context.Background(), m, 10*time.Second,
+		monitor.WithAlerterClock(clock),
+		monitor.WithFireHandler(events.add))
+	Assert(t, NoError(err), "alerter created")
+	defer a.Stop()
+	err = a.Add(monitor.Absent("heartbeat", "heartbeat", 30*time.Second))
+	Assert(t, NoError(err), "absent rule added")
+
+	m.StopWatch().Measure("heartbeat", func() {})
+	clock.BlockUntil(1)
+	for i := 0; i < 10 && len(events.get()) == 0; i++ {
+		clock.Advance(10 * time.Second)
+		time.Sleep(20 * time.Millisecond)
+	}
+	Assert(t, Length(events.get(), 1), "missing heartbeat fired")
+	Assert(t, Equal(events.get()[0].RuleID, "heartbeat"), "heartbeat alert")
+
+	err = a.HealthCheck()(context.Background())
+	Assert(t, ErrorContains(err, "alerts firing: heartbeat"), "health check fails")
+}
+
+// TestHealth verifies the combination of health checks.
+func TestHealth(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+	h := monitor.NewHealth(m, 50*time.Millisecond)
+	h.Register("db", func(ctx context.Context) error { return nil })
+	h.Register("cache", func(ctx context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	report := h.Check(context.Background())
+	Assert(t, Equal(report.Status, monitor.HealthOK), "all checks ok")
+	Assert(t, Length(report.Checks, 2), "two checks")
+	Assert(t, True(report.Checks["cache"].Latency >= 5*time.Millisecond), "latency measured")
+	wv, err := m.StopWatch().ReadWithLabels("health_check", monitor.Labels{"check": "cache"})
+	Assert(t, NoError(err), "latency recorded")
+	Assert(t, Equal(wv.Count, 1), "one measuring")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	Assert(t, NoError(err), "healthy request")
+	resp.Body.Close()
+	Assert(t, Equal(resp.StatusCode, http.StatusOK), "healthy status")
+
+	h.Register("broken", func(ctx context.Context) error { return errors.New("ouch") })
+	h.Register("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+		return nil
+	})
+	h.Register("panicking", func(ctx context.Context) error { panic("boom") })
+	resp, err = http.Get(srv.URL)
+	Assert(t, NoError(err), "failing request")
+	defer resp.Body.Close()
+	Assert(t, Equal(resp.StatusCode, http.StatusServiceUnavailable), "failing status")
+	report = monitor.HealthReport{}
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	Assert(t, NoError(err), "report decoded")
+	Assert(t, Equal(report.Status, monitor.HealthFailing), "report failing")
+	Assert(t, Equal(report.Checks["db"].Status, monitor.HealthOK), "db still ok")
+	Assert(t, Equal(report.Checks["broken"].Error, "ouch"), "error reported")
+	Assert(t, ErrorContains(errors.New(report.Checks["hanging"].Error), "deadline exceeded"), "timeout reported")
+	Assert(t, ErrorContains(errors.New(report.Checks["panicking"].Error), "boom"), "panic reported")
+
+	h.Unregister("broken")
+	h.Unregister("hanging")
+	h.Unregister("panicking")
+	report = h.Check(context.Background())
+	Assert(t, Equal(report.Status, monitor.HealthOK), "ok again")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// alertEvents collects the alerts passed to handlers.
+type alertEvents struct {
+	mu     sync.Mutex
+	alerts []monitor.Alert
+}
+
+func (ae *alertEvents) add(alert monitor.Alert) {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	ae.alerts = append(ae.alerts, alert)
+}
+
+func (ae *alertEvents) get() []monitor.Alert {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	return append([]monitor.Alert{}, ae.alerts...)
+}
+
+// EOF
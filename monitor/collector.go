@@ -0,0 +1,367 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tideland.dev/go/stew/loop"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	// defaultCollectInterval is the default interval of the collector.
+	defaultCollectInterval = 10 * time.Second
+
+	// defaultProcDir is the default process directory on Linux.
+	defaultProcDir = "/proc/self"
+
+	// userHZ is the number of clock ticks per second of the CPU
+	// times in /proc/self/stat.
+	userHZ = 100
+)
+
+// runtimeGauges maps the gauge names to the names of the Go runtime
+// metrics. The first supported one is sampled, older ones are kept
+// for older runtimes.
+var runtimeGauges = []struct {
+	gauge   string
+	samples []string
+}{
+	{"go_goroutines", []string{"/sched/goroutines:goroutines"}},
+	{"go_gomaxprocs", []string{"/sched/gomaxprocs:threads"}},
+	{"go_memory_total_bytes", []string{"/memory/classes/total:bytes"}},
+	{"go_memory_heap_objects_bytes", []string{"/memory/classes/heap/objects:bytes"}},
+	{"go_memory_heap_free_bytes", []string{"/memory/classes/heap/free:bytes"}},
+	{"go_memory_stacks_bytes", []string{"/memory/classes/heap/stacks:bytes"}},
+	{"go_gc_heap_allocs_bytes", []string{"/gc/heap/allocs:bytes"}},
+	{"go_gc_heap_objects", []string{"/gc/heap/objects:objects"}},
+	{"go_gc_heap_goal_bytes", []string{"/gc/heap/goal:bytes"}},
+	{"go_gc_cycles", []string{"/gc/cycles/total:gc-cycles"}},
+	{"go_gc_pause_seconds", []string{"/sched/pauses/total/gc:seconds", "/gc/pauses:seconds"}},
+	{"go_sched_latency_seconds", []string{"/sched/latencies:seconds"}},
+}
+
+// histogramQuantiles are the quantiles published for runtime histograms.
+var histogramQuantiles = []struct {
+	suffix   string
+	quantile float64
+}{
+	{"_p50", 0.5},
+	{"_p99", 0.99},
+	{"_max", 1.0},
+}
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// CollectorOption defines the signature of a collector option setting function.
+type CollectorOption func(c *Collector) error
+
+// WithCollectorInterval sets the interval of the collector. Default
+// is 10 seconds.
+func WithCollectorInterval(interval time.Duration) CollectorOption {
+	return func(c *Collector) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid collector option: interval %v must be positive", interval)
+		}
+		c.interval = interval
+		return nil
+	}
+}
+
+// WithCollectorContext sets the context of the collector. Canceling
+// it stops the collector.
+func WithCollectorContext(ctx context.Context) CollectorOption {
+	return func(c *Collector) error {
+		if ctx == nil {
+			return fmt.Errorf("invalid collector option: context is nil")
+		}
+		c.ctx = ctx
+		return nil
+	}
+}
+
+// WithCollectorClock sets the clock of the collector. By default it's the
+// real clock, a fake one allows deterministic tests.
+func WithCollectorClock(clock wait.Clock) CollectorOption {
+	return func(c *Collector) error {
+		if clock == nil {
+			return fmt.Errorf("invalid collector option: clock is nil")
+		}
+		c.clock = clock
+		return nil
+	}
+}
+
+// WithCollectorProcDir sets the directory of the process statistics.
+// Default is /proc/self. If it doesn't exist, e.g. on other systems
+// than Linux, only the runtime metrics are collected.
+func WithCollectorProcDir(dir string) CollectorOption {
+	return func(c *Collector) error {
+		c.procDir = dir
+		return nil
+	}
+}
+
+//--------------------
+// COLLECTOR
+//--------------------
+
+// Collector periodically samples Go runtime metrics and the statistics
+// of the process and sets them as gauges of a monitor. Failed readings
+// are counted by the counter collector_errors.
+type Collector struct {
+	mu       sync.Mutex
+	monitor  *Monitor
+	ctx      context.Context
+	interval time.Duration
+	clock    wait.Clock
+	procDir  string
+	samples  []metrics.Sample
+	gauges   []string
+	loop     *loop.Loop
+}
+
+// NewCollector creates a collector for the monitor and starts it. The
+// first collection is done immediately.
+func NewCollector(m *Monitor, options ...CollectorOption) (*Collector, error) {
+	c := &Collector{
+		monitor:  m,
+		ctx:      context.Background(),
+		interval: defaultCollectInterval,
+		clock:    wait.RealClock(),
+		procDir:  defaultProcDir,
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	c.initSamples()
+	if _, err := os.Stat(c.procDir); err != nil {
+		c.procDir = ""
+	}
+	l, err := loop.Go(c.worker, loop.WithContext(c.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error starting collector worker: %v", err)
+	}
+	c.loop = l
+	return c, nil
+}
+
+// Stop terminates the collector.
+func (c *Collector) Stop() error {
+	c.loop.Stop()
+	<-c.loop.Done()
+	return c.loop.Err()
+}
+
+// Status returns the current status of the collector.
+func (c *Collector) Status() loop.Status {
+	return c.loop.Status()
+}
+
+// Collect samples all metrics once.
+func (c *Collector) Collect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.collectRuntime()
+	if c.procDir != "" {
+		if err := c.collectProcess(); err != nil {
+			c.monitor.Counter("collector_errors", nil).Inc()
+		}
+	}
+}
+
+// worker collects the metrics in the interval.
+func (c *Collector) worker(ctx context.Context) error {
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	c.Collect()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			c.Collect()
+		}
+	}
+}
+
+// initSamples prepares the samples of the runtime metrics supported
+// by the running Go version.
+func (c *Collector) initSamples() {
+	supported := make(map[string]bool)
+	for _, desc := range metrics.All() {
+		supported[desc.Name] = true
+	}
+	for _, rg := range runtimeGauges {
+		for _, name := range rg.samples {
+			if supported[name] {
+				c.samples = append(c.samples, metrics.Sample{Name: name})
+				c.gauges = append(c.gauges, rg.gauge)
+				break
+			}
+		}
+	}
+}
+
+// collectRuntime samples the runtime metrics. Histograms are published
+// as gauges of some quantiles.
+func (c *Collector) collectRuntime() {
+	metrics.Read(c.samples)
+	for i, sample := range c.samples {
+		name := c.gauges[i]
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			c.monitor.Gauge(name, nil).Set(float64(sample.Value.Uint64()))
+		case metrics.KindFloat64:
+			c.monitor.Gauge(name, nil).Set(sample.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			h := sample.Value.Float64Histogram()
+			for _, hq := range histogramQuantiles {
+				c.monitor.Gauge(name+hq.suffix, nil).Set(histogramQuantile(h, hq.quantile))
+			}
+		}
+	}
+}
+
+// histogramQuantile returns the upper bound of the bucket containing
+// the quantile. Infinite bounds are replaced by the lower ones.
+func histogramQuantile(h *metrics.Float64Histogram, q float64) float64 {
+	total := uint64(0)
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for i, count := range h.Counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		bound := h.Buckets[i+1]
+		if math.IsInf(bound, 1) {
+			bound = h.Buckets[i]
+		}
+		return bound
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
+
+//--------------------
+// PROCESS
+//--------------------
+
+// collectProcess reads the process statistics.
+func (c *Collector) collectProcess() error {
+	if err := c.collectStat(); err != nil {
+		return err
+	}
+	fds, err := os.ReadDir(filepath.Join(c.procDir, "fd"))
+	if err != nil {
+		return fmt.Errorf("cannot read file descriptors: %v", err)
+	}
+	c.monitor.Gauge("process_open_fds", nil).Set(float64(len(fds)))
+	maxFDs, err := c.readMaxFDs()
+	if err != nil {
+		return err
+	}
+	if maxFDs > 0 {
+		c.monitor.Gauge("process_max_fds", nil).Set(maxFDs)
+	}
+	return nil
+}
+
+// collectStat reads CPU times, threads, and memory of /proc/self/stat.
+func (c *Collector) collectStat() error {
+	data, err := os.ReadFile(filepath.Join(c.procDir, "stat"))
+	if err != nil {
+		return fmt.Errorf("cannot read process stat: %v", err)
+	}
+	// The command name in parentheses may contain spaces, so the
+	// fields are split after it. The first one is the state.
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return fmt.Errorf("invalid process stat: %q", stat)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return fmt.Errorf("invalid process stat: only %d fields", len(fields))
+	}
+	field := func(n int) (float64, error) {
+		v, err := strconv.ParseFloat(fields[n-3], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid process stat field %d: %v", n, err)
+		}
+		return v, nil
+	}
+	values := map[int]float64{}
+	for _, n := range []int{14, 15, 20, 23, 24} {
+		if values[n], err = field(n); err != nil {
+			return err
+		}
+	}
+	c.monitor.Gauge("process_cpu_seconds", nil).Set((values[14] + values[15]) / userHZ)
+	c.monitor.Gauge("process_threads", nil).Set(values[20])
+	c.monitor.Gauge("process_virtual_memory_bytes", nil).Set(values[23])
+	c.monitor.Gauge("process_resident_memory_bytes", nil).Set(values[24] * float64(os.Getpagesize()))
+	return nil
+}
+
+// readMaxFDs reads the soft limit of open files of /proc/self/limits.
+// Zero means unlimited.
+func (c *Collector) readMaxFDs() (float64, error) {
+	data, err := os.ReadFile(filepath.Join(c.procDir, "limits"))
+	if err != nil {
+		return 0, fmt.Errorf("cannot read process limits: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 || fields[0] == "unlimited" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid process limit of open files: %v", err)
+		}
+		return v, nil
+	}
+	return 0, nil
+}
+
+// EOF
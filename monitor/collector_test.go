@@ -0,0 +1,151 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tideland.dev/go/stew/qaenv"
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCollector verifies collecting runtime and process metrics
+// from a prepared process directory.
+func TestCollector(t *testing.T) {
+	td, err := qaenv.MkdirTemp("collector")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	writeProcDir(t, td.String(), 250, 4)
+
+	m := monitor.New()
+	defer m.Stop()
+	clock := wait.NewFakeClock(time.Now())
+	c, err := monitor.NewCollector(m,
+		monitor.WithCollectorInterval(time.Minute),
+		monitor.WithCollectorClock(clock),
+		monitor.WithCollectorProcDir(td.String()))
+	Assert(t, NoError(err), "collector created")
+	defer c.Stop()
+
+	c.Collect()
+	gauges := readGauges(m)
+	Assert(t, True(gauges["go_goroutines"] > 0), "goroutines collected")
+	Assert(t, True(gauges["go_memory_total_bytes"] > 0), "memory collected")
+	Assert(t, True(gauges["go_gomaxprocs"] > 0), "gomaxprocs collected")
+	_, ok := gauges["go_gc_pause_seconds_p99"]
+	Assert(t, True(ok), "gc pauses collected")
+	Assert(t, Equal(gauges["process_cpu_seconds"], 2.5), "cpu seconds collected")
+	Assert(t, Equal(gauges["process_threads"], 4.0), "threads collected")
+	Assert(t, Equal(gauges["process_virtual_memory_bytes"], 1048576.0), "virtual memory collected")
+	Assert(t, Equal(gauges["process_resident_memory_bytes"], float64(10*os.Getpagesize())), "resident memory collected")
+	Assert(t, Equal(gauges["process_open_fds"], 3.0), "open fds collected")
+	Assert(t, Equal(gauges["process_max_fds"], 1024.0), "max fds collected")
+
+	// Change the statistics and let the fake clock tick.
+	writeProcDir(t, td.String(), 500, 8)
+	clock.BlockUntil(1)
+	Assert(t, True(waitForGauge(m, clock, "process_threads", 8.0)), "collected in interval")
+	Assert(t, Equal(readGauges(m)["process_cpu_seconds"], 5.0), "cpu seconds updated")
+}
+
+// TestCollectorErrors verifies the validation of options, missing
+// process directories, and counting of errors.
+func TestCollectorErrors(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	_, err := monitor.NewCollector(m, monitor.WithCollectorInterval(0))
+	Assert(t, ErrorContains(err, "interval"), "invalid interval")
+	_, err = monitor.NewCollector(m, monitor.WithCollectorClock(nil))
+	Assert(t, ErrorContains(err, "clock is nil"), "invalid clock")
+
+	c, err := monitor.NewCollector(m, monitor.WithCollectorProcDir("/does/not/exist"))
+	Assert(t, NoError(err), "collector without process directory created")
+	c.Collect()
+	Assert(t, NoError(c.Stop()), "collector stopped")
+	gauges := readGauges(m)
+	_, ok := gauges["process_threads"]
+	Assert(t, False(ok), "no process metrics")
+	Assert(t, True(gauges["go_goroutines"] > 0), "runtime metrics collected")
+
+	td, err := qaenv.MkdirTemp("collector")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	err = os.WriteFile(filepath.Join(td.String(), "stat"), []byte("garbage"), 0644)
+	Assert(t, NoError(err), "stat written")
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err = monitor.NewCollector(m, monitor.WithCollectorContext(ctx), monitor.WithCollectorProcDir(td.String()))
+	Assert(t, NoError(err), "collector created")
+	c.Collect()
+	cancel()
+	c.Stop()
+	Assert(t, True(m.Counter("collector_errors", nil).Value() > 0), "errors counted")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// writeProcDir writes the files of a process directory.
+func writeProcDir(t *testing.T, dir string, ticks, threads int) {
+	stat := fmt.Sprintf("4711 (my (odd) cmd) S 1 4711 4711 0 -1 4194560 100 0 0 0 %d 0 0 0 20 0 %d 0 12345 1048576 10 18446744073709551615\n",
+		ticks, threads)
+	limits := "Limit                     Soft Limit           Hard Limit           Units     \n" +
+		"Max open files            1024                 4096                 files     \n"
+	err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644)
+	Assert(t, NoError(err), "stat written")
+	err = os.WriteFile(filepath.Join(dir, "limits"), []byte(limits), 0644)
+	Assert(t, NoError(err), "limits written")
+	fdDir := filepath.Join(dir, "fd")
+	err = os.MkdirAll(fdDir, 0755)
+	Assert(t, NoError(err), "fd directory created")
+	for i := 0; i < 3; i++ {
+		err = os.WriteFile(filepath.Join(fdDir, fmt.Sprint(i)), nil, 0644)
+		Assert(t, NoError(err), "fd written")
+	}
+}
+
+// readGauges returns the values of all gauges without labels.
+func readGauges(m *monitor.Monitor) map[string]float64 {
+	gauges := make(map[string]float64)
+	m.DoGauges(func(gv monitor.GaugeValue) error {
+		gauges[gv.Name] = gv.Value
+		return nil
+	})
+	return gauges
+}
+
+// waitForGauge advances the fake clock until the gauge has the value.
+func waitForGauge(m *monitor.Monitor, clock *wait.FakeClock, name string, value float64) bool {
+	for i := 0; i < 100; i++ {
+		clock.Advance(time.Minute)
+		if m.Gauge(name, nil).Value() == value {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// EOF
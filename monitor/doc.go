@@ -10,6 +10,44 @@
 // maximum, and average durations. Another one is the stay-set indicator allowing
 // to increase and decrease values and retrieve count, maximum, minimum, and
 // current value. This can help to control and manage limiters or pools.
+//
+// The durations of each stop watch are also collected in a Histogram. So
+// the watch values contain the percentiles p50, p90, p99, and p999. With
+// ReadWindow the values of only the last minutes, e.g. 1, 5, or 15, can
+// be read without resetting the monitor. Histograms can be merged.
+//
+// Counters are monotonic increasing, gauges can be set to any value. Like
+// the stop watches and the stay-set indicators they can have Labels, e.g.
+// the route and the status code of a request. All values are stored without
+// a central lock or goroutine.
+//
+// Stop watches and stay-set indicators accumulate their values in shards per
+// processor. They are aggregated periodically, see WithAggregation, and when
+// read. So minimum and maximum of the indicators are those seen during the
+// aggregations.
+//
+// NewHandler returns a http.Handler serving all measurings in the Prometheus
+// text or the OpenMetrics format. A namespace and constant labels can be
+// configured. Labels of measurings clashing with the constant ones or with
+// id, quantile, and le are exported with the prefix "exported_".
+//
+// StartSpan starts a tracing span using the monitor of the context. Spans
+// have trace and span IDs, attributes, events, and errors. Their durations
+// are measured by the stop watch and an Exporter set with WithExporter
+// writes them, e.g. as JSON lines or OTLP-JSON. The span context is
+// propagated via the W3C traceparent header, see NewTracingHandler and
+// NewTracingTransport.
+//
+// NewCollector starts a loop periodically sampling the Go runtime metrics,
+// e.g. goroutines, memory, and GC pauses, and on Linux the statistics of
+// the process in /proc/self. They are set as gauges of the monitor, so
+// they are exported together with all other values.
+//
+// An Alerter evaluates rules like Above, Below, or Absent over the values
+// of the monitor on a schedule. Rules violated for their duration fire,
+// and resolve when they are fine again. Both is passed to handlers. Health
+// runs registered health checks in parallel and serves the combined report
+// including their latencies as /healthz-style handler.
 package monitor // import "tideland.dev/go/stew/monitor"
 
 // EOF
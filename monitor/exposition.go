@@ -0,0 +1,446 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Content types of the exposition formats.
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+//--------------------
+// HANDLER OPTIONS
+//--------------------
+
+// HandlerOption defines the signature of a handler option setting function.
+type HandlerOption func(h *handler) error
+
+// WithNamespace sets the namespace prefixed to all metric names.
+func WithNamespace(namespace string) HandlerOption {
+	return func(h *handler) error {
+		if !validName(namespace) {
+			return fmt.Errorf("invalid handler option: invalid namespace %q", namespace)
+		}
+		h.namespace = namespace
+		return nil
+	}
+}
+
+// WithConstLabels sets labels added to all metrics, e.g. the
+// name of the service or the instance.
+func WithConstLabels(labels map[string]string) HandlerOption {
+	return func(h *handler) error {
+		for name, value := range labels {
+			if !validName(name) || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("invalid handler option: invalid label name %q", name)
+			}
+			if reservedLabel(name) {
+				return fmt.Errorf("invalid handler option: reserved label name %q", name)
+			}
+			h.labels = append(h.labels, label{name, value})
+		}
+		sort.Slice(h.labels, func(i, j int) bool { return h.labels[i].name < h.labels[j].name })
+		return nil
+	}
+}
+
+// WithBuckets lets the handler export the stop watches as histograms
+// with the given upper bounds instead of summaries with quantiles.
+func WithBuckets(bounds ...time.Duration) HandlerOption {
+	return func(h *handler) error {
+		if len(bounds) == 0 {
+			return fmt.Errorf("invalid handler option: no buckets")
+		}
+		h.buckets = append([]time.Duration(nil), bounds...)
+		sort.Slice(h.buckets, func(i, j int) bool { return h.buckets[i] < h.buckets[j] })
+		return nil
+	}
+}
+
+//--------------------
+// HANDLER
+//--------------------
+
+// label is a name/value pair of a metric.
+type label struct {
+	name  string
+	value string
+}
+
+// handler serves the measurings of a monitor.
+type handler struct {
+	monitor   *Monitor
+	namespace string
+	labels    []label
+	buckets   []time.Duration
+}
+
+// NewHandler returns a http.Handler serving all measurings of the monitor
+// in the Prometheus text format or, if requested by the Accept header, in
+// the OpenMetrics format. Stop watches are exported as summaries, or as
+// histograms if buckets are set, stay-set indicators as gauges.
+func NewHandler(m *Monitor, options ...HandlerOption) (http.Handler, error) {
+	h := &handler{
+		monitor: m,
+	}
+	for _, option := range options {
+		if err := option(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var buf bytes.Buffer
+	if err := h.write(&buf, openMetrics); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+	} else {
+		w.Header().Set("Content-Type", contentTypeText)
+	}
+	w.Write(buf.Bytes())
+}
+
+// write writes all metrics in the requested format.
+func (h *handler) write(w io.Writer, openMetrics bool) error {
+	ew := &expositionWriter{
+		w:           w,
+		openMetrics: openMetrics,
+		labels:      h.labels,
+	}
+	if err := h.writeStopWatches(ew); err != nil {
+		return err
+	}
+	if err := h.writeIndicators(ew); err != nil {
+		return err
+	}
+	if err := h.writeCounters(ew); err != nil {
+		return err
+	}
+	if err := h.writeGauges(ew); err != nil {
+		return err
+	}
+	if openMetrics {
+		ew.printf("# EOF\n")
+	}
+	return ew.err
+}
+
+// writeStopWatches writes the stop watches as summary or histogram.
+func (h *handler) writeStopWatches(ew *expositionWriter) error {
+	var wvs WatchValues
+	if err := h.monitor.StopWatch().Do(func(wv WatchValue) error {
+		wvs = append(wvs, wv)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(wvs) == 0 {
+		return nil
+	}
+	sort.Sort(wvs)
+	name := h.name("stopwatch_seconds")
+	if h.buckets == nil {
+		ew.family(name, "summary", "Execution times measured by the stop watch.")
+		for _, wv := range wvs {
+			id := h.metricLabels(wv.ID, wv.Labels)
+			for _, q := range []struct {
+				quantile string
+				value    time.Duration
+			}{{"0.5", wv.P50}, {"0.9", wv.P90}, {"0.99", wv.P99}, {"0.999", wv.P999}} {
+				ew.sample(name, seconds(q.value), append(id, label{"quantile", q.quantile})...)
+			}
+			ew.sample(name+"_sum", seconds(wv.Total), id...)
+			ew.sample(name+"_count", strconv.Itoa(wv.Count), id...)
+		}
+		return nil
+	}
+	ew.family(name, "histogram", "Execution times measured by the stop watch.")
+	for _, wv := range wvs {
+		hist, err := h.monitor.StopWatch().HistogramWithLabels(wv.ID, wv.Labels)
+		if err != nil {
+			return err
+		}
+		id := h.metricLabels(wv.ID, wv.Labels)
+		for _, bound := range h.buckets {
+			ew.sample(name+"_bucket", strconv.Itoa(hist.CountBelow(bound)), append(id, label{"le", seconds(bound)})...)
+		}
+		ew.sample(name+"_bucket", strconv.Itoa(hist.Count()), append(id, label{"le", "+Inf"})...)
+		ew.sample(name+"_sum", seconds(hist.Sum()), id...)
+		ew.sample(name+"_count", strconv.Itoa(hist.Count()), id...)
+	}
+	return nil
+}
+
+// writeIndicators writes the stay-set indicators as gauges.
+func (h *handler) writeIndicators(ew *expositionWriter) error {
+	var ivs IndicatorValues
+	if err := h.monitor.StaySetIndicator().Do(func(iv IndicatorValue) error {
+		ivs = append(ivs, iv)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(ivs) == 0 {
+		return nil
+	}
+	sort.Sort(ivs)
+	for _, g := range []struct {
+		name  string
+		help  string
+		value func(iv IndicatorValue) int
+	}{
+		{"indicator", "Current value of the stay-set indicator.", func(iv IndicatorValue) int { return iv.Current }},
+		{"indicator_min", "Minimum value of the stay-set indicator.", func(iv IndicatorValue) int { return iv.Min }},
+		{"indicator_max", "Maximum value of the stay-set indicator.", func(iv IndicatorValue) int { return iv.Max }},
+	} {
+		name := h.name(g.name)
+		ew.family(name, "gauge", g.help)
+		for _, iv := range ivs {
+			ew.sample(name, strconv.Itoa(g.value(iv)), h.metricLabels(iv.ID, iv.Labels)...)
+		}
+	}
+	return nil
+}
+
+// writeCounters writes the counters grouped by name.
+func (h *handler) writeCounters(ew *expositionWriter) error {
+	var cvs []CounterValue
+	if err := h.monitor.DoCounters(func(cv CounterValue) error {
+		cvs = append(cvs, cv)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(cvs, func(i, j int) bool {
+		return familyLess(counterName(cvs[i].Name), cvs[i].Labels, counterName(cvs[j].Name), cvs[j].Labels)
+	})
+	last := ""
+	for _, cv := range cvs {
+		name := h.name(counterName(cv.Name))
+		if name != last {
+			ew.family(name, "counter", "")
+			last = name
+		}
+		ew.sample(name, strconv.FormatUint(cv.Value, 10), h.metricLabels("", cv.Labels)...)
+	}
+	return nil
+}
+
+// writeGauges writes the gauges grouped by name.
+func (h *handler) writeGauges(ew *expositionWriter) error {
+	var gvs []GaugeValue
+	if err := h.monitor.DoGauges(func(gv GaugeValue) error {
+		gvs = append(gvs, gv)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(gvs, func(i, j int) bool {
+		return familyLess(gvs[i].Name, gvs[i].Labels, gvs[j].Name, gvs[j].Labels)
+	})
+	last := ""
+	for _, gv := range gvs {
+		name := h.name(sanitizeName(gv.Name))
+		if name != last {
+			ew.family(name, "gauge", "")
+			last = name
+		}
+		ew.sample(name, strconv.FormatFloat(gv.Value, 'g', -1, 64), h.metricLabels("", gv.Labels)...)
+	}
+	return nil
+}
+
+// familyLess orders metrics by their sanitized family name first and
+// by their labels inside of a family. So all series of a family are
+// written together below one header.
+func familyLess(iname string, ilabels Labels, jname string, jlabels Labels) bool {
+	ifam, jfam := sanitizeName(iname), sanitizeName(jname)
+	if ifam != jfam {
+		return ifam < jfam
+	}
+	return ilabels.String() < jlabels.String()
+}
+
+// name returns the metric name with namespace.
+func (h *handler) name(name string) string {
+	if h.namespace == "" {
+		return name
+	}
+	return h.namespace + "_" + name
+}
+
+//--------------------
+// EXPOSITION WRITER
+//--------------------
+
+// expositionWriter writes metric families and samples.
+type expositionWriter struct {
+	w           io.Writer
+	openMetrics bool
+	labels      []label
+	err         error
+}
+
+// family writes the type and help lines of a metric family.
+func (ew *expositionWriter) family(name, typ, help string) {
+	if ew.openMetrics && typ == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	if help != "" {
+		ew.printf("# HELP %s %s\n", name, escapeHelp(help))
+	}
+	ew.printf("# TYPE %s %s\n", name, typ)
+}
+
+// sample writes one sample with the constant and the given labels.
+func (ew *expositionWriter) sample(name, value string, labels ...label) {
+	all := append(append([]label(nil), ew.labels...), labels...)
+	if len(all) == 0 {
+		ew.printf("%s %s\n", name, value)
+		return
+	}
+	parts := make([]string, len(all))
+	for i, l := range all {
+		parts[i] = l.name + `="` + escapeLabel(l.value) + `"`
+	}
+	ew.printf("%s{%s} %s\n", name, strings.Join(parts, ","), value)
+}
+
+// printf writes formatted output and keeps the first error.
+func (ew *expositionWriter) printf(format string, a ...any) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, a...)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// seconds formats a duration as seconds.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// metricLabels returns the id label, if not empty, and the sorted
+// labels of a metric. Labels clashing with the reserved or the
+// constant ones are prefixed with "exported_".
+func (h *handler) metricLabels(id string, labels Labels) []label {
+	ls := make([]label, 0, len(labels)+2)
+	if id != "" {
+		ls = append(ls, label{"id", id})
+	}
+	for _, name := range labels.names() {
+		lname := sanitizeName(name)
+		if reservedLabel(lname) || strings.HasPrefix(lname, "__") || h.constLabel(lname) {
+			lname = "exported_" + lname
+		}
+		ls = append(ls, label{lname, labels[name]})
+	}
+	return ls
+}
+
+// constLabel checks if the name is the one of a constant label.
+func (h *handler) constLabel(name string) bool {
+	for _, l := range h.labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedLabel checks if the name is used by the exposition itself.
+func reservedLabel(name string) bool {
+	return name == "id" || name == "quantile" || name == "le"
+}
+
+// counterName returns the sanitized name of a counter with the
+// suffix "_total", added only if it's missing.
+func counterName(name string) string {
+	name = sanitizeName(name)
+	if strings.HasSuffix(name, "_total") {
+		return name
+	}
+	return name + "_total"
+}
+
+// sanitizeName replaces all characters not allowed in metric and
+// label names by underscores.
+func sanitizeName(name string) string {
+	if validName(name) {
+		return name
+	}
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// validName checks if the name is a valid metric or label name.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeLabel escapes backslashes, quotes, and newlines of label values.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp escapes backslashes and newlines of help texts.
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// EOF
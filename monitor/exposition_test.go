@@ -0,0 +1,193 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestHandlerText verifies the exposition in the Prometheus text format.
+func TestHandlerText(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	for i := 0; i < 10; i++ {
+		m.StopWatch().Measure("db \"query\"", func() {})
+	}
+	m.StaySetIndicator().Increase("conns")
+	m.StaySetIndicator().Increase("conns")
+
+	h, err := monitor.NewHandler(m,
+		monitor.WithNamespace("app"),
+		monitor.WithConstLabels(map[string]string{"service": "shop"}))
+	Assert(t, NoError(err), "handler created")
+
+	body, contentType := get(t, h, "")
+	Assert(t, True(strings.HasPrefix(contentType, "text/plain; version=0.0.4")), "text content type")
+	for _, line := range []string{
+		"# TYPE app_stopwatch_seconds summary",
+		`app_stopwatch_seconds{service="shop",id="db \"query\"",quantile="0.99"} `,
+		`app_stopwatch_seconds_count{service="shop",id="db \"query\""} 10`,
+		"# TYPE app_indicator gauge",
+		`app_indicator{service="shop",id="conns"} 3`,
+		`app_indicator_max{service="shop",id="conns"} 3`,
+	} {
+		Assert(t, True(strings.Contains(body, line)), "body contains %q:\n%s", line, body)
+	}
+	Assert(t, False(strings.Contains(body, "# EOF")), "no EOF marker")
+}
+
+// TestHandlerOpenMetrics verifies the exposition in the OpenMetrics
+// format with histograms.
+func TestHandlerOpenMetrics(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	for i := 0; i < 10; i++ {
+		m.StopWatch().Measure("work", func() {
+			if i < 3 {
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+	}
+
+	h, err := monitor.NewHandler(m, monitor.WithBuckets(time.Second, 10*time.Millisecond))
+	Assert(t, NoError(err), "handler created")
+
+	body, contentType := get(t, h, "application/openmetrics-text; version=1.0.0")
+	Assert(t, True(strings.HasPrefix(contentType, "application/openmetrics-text")), "openmetrics content type")
+	for _, line := range []string{
+		"# TYPE stopwatch_seconds histogram",
+		`stopwatch_seconds_bucket{id="work",le="0.01"} 7`,
+		`stopwatch_seconds_bucket{id="work",le="1"} 10`,
+		`stopwatch_seconds_bucket{id="work",le="+Inf"} 10`,
+		`stopwatch_seconds_count{id="work"} 10`,
+	} {
+		Assert(t, True(strings.Contains(body, line)), "body contains %q:\n%s", line, body)
+	}
+	Assert(t, True(strings.HasSuffix(body, "# EOF\n")), "EOF marker")
+}
+
+// TestHandlerFamilies verifies that all series of a metric family are
+// written below exactly one header, even if other names share its prefix.
+func TestHandlerFamilies(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	m.Counter("req", nil).Inc()
+	m.Counter("req_x", nil).Inc()
+	m.Counter("req", monitor.Labels{"code": "200"}).Add(2)
+	m.Counter("req-x", monitor.Labels{"code": "500"}).Inc()
+	m.Gauge("temp", monitor.Labels{"room": "b"}).Set(2)
+	m.Gauge("temp_max", nil).Set(3)
+	m.Gauge("temp", nil).Set(1)
+
+	h, err := monitor.NewHandler(m)
+	Assert(t, NoError(err), "handler created")
+
+	body, _ := get(t, h, "")
+	for _, header := range []string{
+		"# TYPE req_total counter\n",
+		"# TYPE req_x_total counter\n",
+		"# TYPE temp gauge\n",
+		"# TYPE temp_max gauge\n",
+	} {
+		Assert(t, Equal(strings.Count(body, header), 1), "one header %q:\n%s", header, body)
+	}
+	Assert(t, True(strings.Contains(body, "# TYPE req_total counter\n"+
+		"req_total 1\n"+
+		`req_total{code="200"} 2`+"\n"+
+		"# TYPE req_x_total counter\n"+
+		"req_x_total 1\n"+
+		`req_x_total{code="500"} 1`+"\n")), "counter families:\n%s", body)
+	Assert(t, True(strings.Contains(body, "# TYPE temp gauge\n"+
+		"temp 1\n"+
+		`temp{room="b"} 2`+"\n"+
+		"# TYPE temp_max gauge\n"+
+		"temp_max 3\n")), "gauge families:\n%s", body)
+}
+
+// TestHandlerNames verifies the counter suffix and the renaming of
+// labels clashing with the reserved or constant ones.
+func TestHandlerNames(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	m.Counter("jobs_total", nil).Inc()
+	m.Counter("errors", nil).Inc()
+	m.Gauge("size", monitor.Labels{"le": "1", "service": "x", "room": "a"}).Set(1)
+	m.StaySetIndicator().IncreaseWithLabels("conns", monitor.Labels{"id": "a", "quantile": "b"})
+
+	h, err := monitor.NewHandler(m, monitor.WithConstLabels(map[string]string{"service": "shop"}))
+	Assert(t, NoError(err), "handler created")
+
+	body, _ := get(t, h, "")
+	for _, line := range []string{
+		"# TYPE jobs_total counter\n",
+		`jobs_total{service="shop"} 1`,
+		"# TYPE errors_total counter\n",
+		`size{service="shop",exported_le="1",room="a",exported_service="x"} 1`,
+		`indicator{service="shop",id="conns",exported_id="a",exported_quantile="b"} `,
+	} {
+		Assert(t, True(strings.Contains(body, line)), "body contains %q:\n%s", line, body)
+	}
+	Assert(t, False(strings.Contains(body, "_total_total")), "no double suffix")
+}
+
+// TestHandlerOptions verifies the validation of the handler options.
+func TestHandlerOptions(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	_, err := monitor.NewHandler(m, monitor.WithNamespace("1app"))
+	Assert(t, ErrorContains(err, "invalid namespace"), "invalid namespace")
+	_, err = monitor.NewHandler(m, monitor.WithConstLabels(map[string]string{"id": "x"}))
+	Assert(t, ErrorContains(err, "reserved label name"), "reserved label")
+	_, err = monitor.NewHandler(m, monitor.WithBuckets())
+	Assert(t, ErrorContains(err, "no buckets"), "no buckets")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// get requests the handler and returns body and content type.
+func get(t *testing.T, h http.Handler, accept string) (string, string) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	Assert(t, NoError(err), "request created")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	Assert(t, NoError(err), "request done")
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	Assert(t, NoError(err), "body read")
+	return string(body), resp.Header.Get("Content-Type")
+}
+
+// EOF
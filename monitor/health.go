@@ -0,0 +1,182 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//--------------------
+// HEALTH CHECKS
+//--------------------
+
+// HealthCheck checks one dependency, e.g. a database. It returns an
+// error if it is not healthy.
+type HealthCheck func(ctx context.Context) error
+
+// Health status values.
+const (
+	HealthOK      = "ok"
+	HealthFailing = "failing"
+)
+
+// defaultHealthTimeout is the default timeout for each check.
+const defaultHealthTimeout = 5 * time.Second
+
+// CheckResult is the result of one health check.
+type CheckResult struct {
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthReport combines the results of all health checks.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Health runs registered health checks in parallel and serves their
+// combined report via HTTP, e.g. as /healthz. The latencies of the
+// checks are measured by the stop watch health_check with the name
+// as label check.
+type Health struct {
+	mu      sync.RWMutex
+	monitor *Monitor
+	timeout time.Duration
+	checks  map[string]HealthCheck
+}
+
+// NewHealth creates a health for the monitor. Each check is canceled
+// after the timeout, zero means 5 seconds.
+func NewHealth(m *Monitor, timeout time.Duration) *Health {
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	return &Health{
+		monitor: m,
+		timeout: timeout,
+		checks:  make(map[string]HealthCheck),
+	}
+}
+
+// Register adds a named health check. An existing one with the same
+// name is replaced.
+func (h *Health) Register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// Unregister removes a named health check.
+func (h *Health) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+// Check runs all health checks and returns the report. It's failing
+// if one check fails.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	checks := make([]HealthCheck, 0, len(h.checks))
+	for name, check := range h.checks {
+		names = append(names, name)
+		checks = append(checks, check)
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.run(ctx, names[i], checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := HealthReport{
+		Status: HealthOK,
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != HealthOK {
+			report.Status = HealthFailing
+		}
+	}
+	return report
+}
+
+// ServeHTTP implements http.Handler. It writes the report as JSON with
+// the status 200 or 503 if it's failing.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// run runs one check with timeout and panic protection.
+func (h *Health) run(ctx context.Context, name string, check HealthCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	errC := make(chan error, 1)
+	begin := time.Now()
+	go func() {
+		defer func() {
+			if reason := recover(); reason != nil {
+				errC <- fmt.Errorf("check panic: %v", reason)
+			}
+		}()
+		errC <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		err = fmt.Errorf("check canceled: %v", ctx.Err())
+	}
+	latency := time.Since(begin)
+	if h.monitor != nil {
+		h.monitor.sw.watches.get("health_check", Labels{"check": name}).record(begin.Add(latency), latency)
+	}
+	if err != nil {
+		return CheckResult{
+			Status:  HealthFailing,
+			Latency: latency,
+			Error:   err.Error(),
+		}
+	}
+	return CheckResult{
+		Status:  HealthOK,
+		Latency: latency,
+	}
+}
+
+// EOF
@@ -0,0 +1,250 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+//--------------------
+// HISTOGRAM
+//--------------------
+
+// histogramAccuracy is the relative accuracy of the quantiles.
+const histogramAccuracy = 0.01
+
+var (
+	// histogramGamma is the growth factor of the bucket bounds.
+	histogramGamma = (1 + histogramAccuracy) / (1 - histogramAccuracy)
+
+	// histogramLogGamma is used for the bucket index calculation.
+	histogramLogGamma = math.Log(histogramGamma)
+)
+
+// Histogram collects durations in logarithmic buckets, so that quantiles
+// can be returned with a relative error of one percent independent of the
+// number of recorded durations. Histograms can be merged, e.g. those of
+// different time windows or processes.
+type Histogram struct {
+	buckets map[int]uint64
+	zeros   uint64
+	count   uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+// NewHistogram creates an empty histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{
+		buckets: make(map[int]uint64),
+	}
+}
+
+// Record adds a duration to the histogram. Negative durations
+// are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if h.count == 0 || d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+	if d == 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[bucketIndex(d)]++
+}
+
+// Merge adds all durations recorded by the other histogram.
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if h.count == 0 || o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.zeros += o.zeros
+	for index, n := range o.buckets {
+		h.buckets[index] += n
+	}
+}
+
+// Clone returns a copy of the histogram.
+func (h *Histogram) Clone() *Histogram {
+	c := NewHistogram()
+	c.Merge(h)
+	return c
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() int {
+	return int(h.count)
+}
+
+// Sum returns the total of all recorded durations.
+func (h *Histogram) Sum() time.Duration {
+	return h.sum
+}
+
+// Min returns the smallest recorded duration.
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded duration.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the duration below which the fraction q of all
+// recorded durations are, e.g. 0.99 for the 99th percentile.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	switch {
+	case q <= 0:
+		return h.min
+	case q >= 1:
+		return h.max
+	}
+	rank := uint64(q * float64(h.count-1))
+	if rank < h.zeros {
+		return 0
+	}
+	seen := h.zeros
+	for _, index := range h.indexes() {
+		seen += h.buckets[index]
+		if seen > rank {
+			return h.clamp(bucketValue(index))
+		}
+	}
+	return h.max
+}
+
+// CountBelow returns the number of recorded durations less than or
+// equal to the bound, e.g. for the cumulative buckets of an exported
+// histogram. It has the same relative accuracy as the quantiles.
+func (h *Histogram) CountBelow(bound time.Duration) int {
+	if h.count == 0 || bound < 0 {
+		return 0
+	}
+	if bound >= h.max {
+		return int(h.count)
+	}
+	n := h.zeros
+	for index, c := range h.buckets {
+		if h.clamp(bucketValue(index)) <= bound {
+			n += c
+		}
+	}
+	return int(n)
+}
+
+// indexes returns the sorted indexes of the used buckets.
+func (h *Histogram) indexes() []int {
+	indexes := make([]int, 0, len(h.buckets))
+	for index := range h.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// clamp keeps the duration between min and max.
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// bucketIndex returns the index of the bucket for the positive duration.
+func bucketIndex(d time.Duration) int {
+	return int(math.Ceil(math.Log(float64(d)) / histogramLogGamma))
+}
+
+// bucketValue returns the representative duration of the bucket.
+func bucketValue(index int) time.Duration {
+	return time.Duration(2 * math.Pow(histogramGamma, float64(index)) / (histogramGamma + 1))
+}
+
+//--------------------
+// TIME WINDOW
+//--------------------
+
+// Number and duration of the slots of a time window.
+const (
+	windowSlots    = 15
+	windowSlotSize = time.Minute
+)
+
+// windowSlot contains the histogram of one slot.
+type windowSlot struct {
+	index int64
+	hist  *Histogram
+}
+
+// timeWindow keeps the histograms of the last 15 minutes per minute.
+type timeWindow struct {
+	slots [windowSlots]windowSlot
+}
+
+// record adds the duration measured at the given time.
+func (tw *timeWindow) record(at time.Time, d time.Duration) {
+	index := at.UnixNano() / int64(windowSlotSize)
+	slot := &tw.slots[index%windowSlots]
+	if slot.hist == nil || slot.index != index {
+		slot.index = index
+		slot.hist = NewHistogram()
+	}
+	slot.hist.Record(d)
+}
+
+// histogram merges the histograms of the slots inside the span
+// before now. The span is rounded up to full minutes.
+func (tw *timeWindow) histogram(now time.Time, span time.Duration) *Histogram {
+	slots := int64((span + windowSlotSize - 1) / windowSlotSize)
+	if slots < 1 {
+		slots = 1
+	}
+	if slots > windowSlots {
+		slots = windowSlots
+	}
+	current := now.UnixNano() / int64(windowSlotSize)
+	h := NewHistogram()
+	for _, slot := range tw.slots {
+		if slot.hist != nil && slot.index <= current && current-slot.index < slots {
+			h.Merge(slot.hist)
+		}
+	}
+	return h
+}
+
+// EOF
@@ -0,0 +1,112 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestHistogramQuantiles verifies the accuracy of the quantiles.
+func TestHistogramQuantiles(t *testing.T) {
+	h := monitor.NewHistogram()
+	Assert(t, Equal(h.Quantile(0.5), 0), "empty histogram")
+
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	Assert(t, Equal(h.Count(), 10000), "all durations recorded")
+	Assert(t, Equal(h.Min(), time.Microsecond), "minimum")
+	Assert(t, Equal(h.Max(), 10*time.Millisecond), "maximum")
+	Assert(t, Equal(h.Quantile(0), time.Microsecond), "quantile 0 is minimum")
+	Assert(t, Equal(h.Quantile(1), 10*time.Millisecond), "quantile 1 is maximum")
+
+	tests := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0.5, 5 * time.Millisecond},
+		{0.9, 9 * time.Millisecond},
+		{0.99, 9900 * time.Microsecond},
+		{0.999, 9990 * time.Microsecond},
+	}
+	for _, test := range tests {
+		delta := test.expected / 50
+		Assert(t, About(h.Quantile(test.q), test.expected, delta), "quantile %v", test.q)
+	}
+}
+
+// TestHistogramMerge verifies the merging of histograms.
+func TestHistogramMerge(t *testing.T) {
+	ha := monitor.NewHistogram()
+	hb := monitor.NewHistogram()
+	for i := 0; i < 100; i++ {
+		ha.Record(time.Millisecond)
+		hb.Record(time.Second)
+	}
+	hb.Record(0)
+	hb.Record(-time.Second)
+
+	hc := ha.Clone()
+	hc.Merge(hb)
+	Assert(t, Equal(ha.Count(), 100), "original not changed")
+	Assert(t, Equal(hc.Count(), 202), "merged count")
+	Assert(t, Equal(hc.Min(), 0), "merged minimum")
+	Assert(t, Equal(hc.Max(), time.Second), "merged maximum")
+	Assert(t, Equal(hc.Sum(), 100*time.Millisecond+100*time.Second), "merged sum")
+	Assert(t, About(hc.Quantile(0.25), time.Millisecond, 20*time.Microsecond), "lower quantile")
+	Assert(t, About(hc.Quantile(0.75), time.Second, 20*time.Millisecond), "upper quantile")
+}
+
+// TestStopWatchPercentiles verifies percentiles and time windows
+// of the stop watch.
+func TestStopWatchPercentiles(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	for i := 0; i < 100; i++ {
+		m.StopWatch().Measure("watch", func() {
+			if i%10 == 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+	wv, err := m.StopWatch().Read("watch")
+	Assert(t, NoError(err), "no error for existing watch")
+	Assert(t, Equal(wv.Count, 100), "watch count is correct")
+	Assert(t, True(wv.P50 < time.Millisecond), "median is fast")
+	Assert(t, True(wv.P99 >= 5*time.Millisecond), "tail is slow")
+	Assert(t, Range(wv.P90, wv.P50, wv.P99), "p90 between p50 and p99")
+	Assert(t, Range(wv.P999, wv.P99, wv.Max), "p999 between p99 and max")
+
+	wwv, err := m.StopWatch().ReadWindow("watch", 5*time.Minute)
+	Assert(t, NoError(err), "no error for existing watch")
+	Assert(t, Equal(wwv.Count, 100), "window contains current measurings")
+	Assert(t, Equal(wwv.P99, wv.P99), "same percentiles")
+
+	_, err = m.StopWatch().ReadWindow("doesnotexist", time.Minute)
+	Assert(t, ErrorMatches(err, `watch value 'doesnotexist' does not exist`), "error for non-existing watch")
+
+	h, err := m.StopWatch().Histogram("watch")
+	Assert(t, NoError(err), "no error for existing watch")
+	Assert(t, Equal(h.Count(), 100), "histogram copy returned")
+}
+
+// EOF
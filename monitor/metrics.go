@@ -0,0 +1,246 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+//--------------------
+// LABELS
+//--------------------
+
+// Labels contains the label names and values of a metric, e.g.
+// the route and the status code of HTTP requests.
+type Labels map[string]string
+
+// String returns the labels sorted by name in the notation
+// {name="value",...}.
+func (ls Labels) String() string {
+	if len(ls) == 0 {
+		return ""
+	}
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '{')
+	for i, name := range ls.names() {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, name...)
+		buf = append(buf, '=')
+		buf = strconv.AppendQuote(buf, ls[name])
+	}
+	buf = append(buf, '}')
+	return string(buf)
+}
+
+// names returns the sorted label names.
+func (ls Labels) names() []string {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	if len(names) > 1 {
+		sort.Strings(names)
+	}
+	return names
+}
+
+// clone returns a copy of the labels.
+func (ls Labels) clone() Labels {
+	if len(ls) == 0 {
+		return nil
+	}
+	c := make(Labels, len(ls))
+	for name, value := range ls {
+		c[name] = value
+	}
+	return c
+}
+
+// metricKey returns the unique key of a metric name and its labels.
+func metricKey(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+//--------------------
+// REGISTRY
+//--------------------
+
+// registry stores metrics by their key. Lookups of existing metrics
+// don't need a lock.
+type registry[T any] struct {
+	metrics sync.Map
+	create  func(name string, labels Labels) *T
+}
+
+// newRegistry creates a registry using the create function for
+// new metrics.
+func newRegistry[T any](create func(name string, labels Labels) *T) *registry[T] {
+	return &registry[T]{
+		create: create,
+	}
+}
+
+// get returns the metric for name and labels, it is created if needed.
+func (r *registry[T]) get(name string, labels Labels) *T {
+	key := metricKey(name, labels)
+	if m, ok := r.metrics.Load(key); ok {
+		return m.(*T)
+	}
+	m, _ := r.metrics.LoadOrStore(key, r.create(name, labels.clone()))
+	return m.(*T)
+}
+
+// lookup returns the metric for name and labels if it exists.
+func (r *registry[T]) lookup(name string, labels Labels) (*T, bool) {
+	m, ok := r.metrics.Load(metricKey(name, labels))
+	if !ok {
+		return nil, false
+	}
+	return m.(*T), true
+}
+
+// do performs f for all metrics.
+func (r *registry[T]) do(f func(m *T) error) error {
+	var err error
+	r.metrics.Range(func(_, m any) bool {
+		err = f(m.(*T))
+		return err == nil
+	})
+	return err
+}
+
+// reset removes all metrics.
+func (r *registry[T]) reset() {
+	r.metrics.Range(func(key, _ any) bool {
+		r.metrics.Delete(key)
+		return true
+	})
+}
+
+//--------------------
+// COUNTER
+//--------------------
+
+// Counter is a monotonic increasing metric, e.g. the number of
+// handled requests.
+type Counter struct {
+	name   string
+	labels Labels
+	value  atomic.Uint64
+}
+
+// newCounter creates a counter.
+func newCounter(name string, labels Labels) *Counter {
+	return &Counter{
+		name:   name,
+		labels: labels,
+	}
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// CounterValue contains the value of one counter.
+type CounterValue struct {
+	Name   string
+	Labels Labels
+	Value  uint64
+}
+
+// String implements fmt.Stringer.
+func (cv *CounterValue) String() string {
+	return fmt.Sprintf("%s%s: %d", cv.Name, cv.Labels, cv.Value)
+}
+
+//--------------------
+// GAUGE
+//--------------------
+
+// Gauge is a metric which can be set to any value, e.g. the current
+// temperature or the size of a queue.
+type Gauge struct {
+	name   string
+	labels Labels
+	bits   atomic.Uint64
+}
+
+// newGauge creates a gauge.
+func newGauge(name string, labels Labels) *Gauge {
+	return &Gauge{
+		name:   name,
+		labels: labels,
+	}
+}
+
+// Set sets the gauge to the value.
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+// Add adds the delta to the gauge, it may be negative.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		value := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, value) {
+			return
+		}
+	}
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// GaugeValue contains the value of one gauge.
+type GaugeValue struct {
+	Name   string
+	Labels Labels
+	Value  float64
+}
+
+// String implements fmt.Stringer.
+func (gv *GaugeValue) String() string {
+	return fmt.Sprintf("%s%s: %g", gv.Name, gv.Labels, gv.Value)
+}
+
+// EOF
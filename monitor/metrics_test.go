@@ -0,0 +1,147 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCounters verifies counters with labels.
+func TestCounters(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	ok := monitor.Labels{"route": "/x", "code": "200"}
+	failed := monitor.Labels{"code": "500", "route": "/x"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Counter("requests", ok).Inc()
+			}
+			m.Counter("requests", failed).Add(2)
+		}()
+	}
+	wg.Wait()
+	Assert(t, Equal(m.Counter("requests", monitor.Labels{"code": "200", "route": "/x"}).Value(), 1000), "label order doesn't matter")
+	Assert(t, Equal(m.Counter("requests", failed).Value(), 20), "other labels counted separately")
+
+	var cvs []string
+	err := m.DoCounters(func(cv monitor.CounterValue) error {
+		cvs = append(cvs, cv.String())
+		return nil
+	})
+	Assert(t, NoError(err), "no error for iteration")
+	sort.Strings(cvs)
+	Assert(t, DeepEqual(cvs, []string{
+		`requests{code="200",route="/x"}: 1000`,
+		`requests{code="500",route="/x"}: 20`,
+	}), "counter values")
+
+	m.Reset()
+	Assert(t, Equal(m.Counter("requests", ok).Value(), 0), "counter reset")
+}
+
+// TestGauges verifies settable gauges.
+func TestGauges(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	g := m.Gauge("temperature", monitor.Labels{"room": "kitchen"})
+	g.Set(21.5)
+	Assert(t, Equal(g.Value(), 21.5), "gauge set")
+
+	q := m.Gauge("queue", nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.Inc()
+			}
+			q.Add(-50)
+			q.Dec()
+		}()
+	}
+	wg.Wait()
+	Assert(t, Equal(q.Value(), 490.0), "gauge changed concurrently")
+
+	count := 0
+	err := m.DoGauges(func(gv monitor.GaugeValue) error {
+		count++
+		return nil
+	})
+	Assert(t, NoError(err), "no error for iteration")
+	Assert(t, Equal(count, 2), "two gauges")
+}
+
+// TestLabeledStopWatchAndIndicator verifies labels of the stop
+// watch and the stay-set indicator.
+func TestLabeledStopWatchAndIndicator(t *testing.T) {
+	m := monitor.New()
+	defer m.Stop()
+
+	getLabels := monitor.Labels{"method": "GET"}
+	postLabels := monitor.Labels{"method": "POST"}
+	m.StopWatch().MeasureWithLabels("handler", getLabels, func() {})
+	m.StopWatch().MeasureWithLabels("handler", getLabels, func() {})
+	m.StopWatch().MeasureWithLabels("handler", postLabels, func() {})
+
+	wv, err := m.StopWatch().ReadWithLabels("handler", getLabels)
+	Assert(t, NoError(err), "no error for existing watch")
+	Assert(t, Equal(wv.Count, 2), "labeled watch count")
+	Assert(t, Equal(wv.Labels["method"], "GET"), "labels returned")
+	_, err = m.StopWatch().Read("handler")
+	Assert(t, ErrorMatches(err, `watch value 'handler' does not exist`), "no unlabeled watch")
+
+	m.StaySetIndicator().IncreaseWithLabels("conns", postLabels)
+	m.StaySetIndicator().DecreaseWithLabels("conns", postLabels)
+	iv, err := m.StaySetIndicator().ReadWithLabels("conns", postLabels)
+	Assert(t, NoError(err), "no error for existing indicator")
+	Assert(t, Equal(iv.Current, 1), "labeled indicator current")
+	_, err = m.StaySetIndicator().ReadWithLabels("conns", getLabels)
+	Assert(t, ErrorMatches(err, `indicator value 'conns\{method="GET"\}' does not exist`), "other labels")
+
+	h, err := monitor.NewHandler(m)
+	Assert(t, NoError(err), "handler created")
+	m.Counter("requests", monitor.Labels{"route": "/x", "code": "200"}).Inc()
+	m.Gauge("queue-size", nil).Set(3)
+	body, _ := get(t, h, "")
+	for _, line := range []string{
+		`stopwatch_seconds_count{id="handler",method="GET"} 2`,
+		`indicator{id="conns",method="POST"} 1`,
+		"# TYPE requests_total counter",
+		`requests_total{code="200",route="/x"} 1`,
+		"# TYPE queue_size gauge",
+		"queue_size 3",
+	} {
+		Assert(t, True(strings.Contains(body, line)), "body contains %q:\n%s", line, body)
+	}
+	body, _ = get(t, h, "application/openmetrics-text")
+	Assert(t, True(strings.Contains(body, "# TYPE requests counter")), "openmetrics counter family")
+}
+
+// EOF
@@ -7,22 +7,76 @@
 
 package monitor // import "tideland.dev/go/stew/monitor"
 
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------
+// OPTIONS
+//--------------------
+
+// defaultAggregation is the default interval for the aggregation of
+// the accumulated stop watch and indicator values.
+const defaultAggregation = time.Second
+
+// Option defines the signature of a monitor option setting function.
+type Option func(m *Monitor)
+
+// WithAggregation sets the interval for the aggregation of the stop
+// watch and stay-set indicator values. Reading them always aggregates
+// the current values.
+func WithAggregation(interval time.Duration) Option {
+	return func(m *Monitor) {
+		if interval > 0 {
+			m.interval = interval
+		}
+	}
+}
+
+// WithExporter sets the exporter for the ended spans.
+func WithExporter(exporter Exporter) Option {
+	return func(m *Monitor) {
+		m.exporter = exporter
+	}
+}
+
 //--------------------
 // MONITOR
 //--------------------
 
-// Monitor combines StopWatch and StaySetIndicator.
+// Monitor combines StopWatch and StaySetIndicator as well as
+// counters and gauges.
 type Monitor struct {
-	sw  *StopWatch
-	ssi *StaySetIndicator
+	sw       *StopWatch
+	ssi      *StaySetIndicator
+	counters *registry[Counter]
+	gauges   *registry[Gauge]
+	interval time.Duration
+	exporter Exporter
+	stopOnce *sync.Once
+	doneC    chan struct{}
 }
 
 // New creates a new monitor.
-func New() *Monitor {
+func New(options ...Option) *Monitor {
 	m := &Monitor{
-		sw:  newStopWatch(),
-		ssi: newStaySetIndicator(),
+		sw:       newStopWatch(),
+		ssi:      newStaySetIndicator(),
+		counters: newRegistry(newCounter),
+		gauges:   newRegistry(newGauge),
+		interval: defaultAggregation,
+		stopOnce: &sync.Once{},
+		doneC:    make(chan struct{}),
+	}
+	for _, option := range options {
+		option(m)
 	}
+	go m.backend()
 	return m
 }
 
@@ -36,16 +90,75 @@ func (m *Monitor) StaySetIndicator() *StaySetIndicator {
 	return m.ssi
 }
 
-// Reset clears all collected values so far.
+// Counter returns the counter with the name and labels. It is
+// created if needed.
+func (m *Monitor) Counter(name string, labels Labels) *Counter {
+	return m.counters.get(name, labels)
+}
+
+// Gauge returns the gauge with the name and labels. It is
+// created if needed.
+func (m *Monitor) Gauge(name string, labels Labels) *Gauge {
+	return m.gauges.get(name, labels)
+}
+
+// DoCounters performs the function f for all counters.
+func (m *Monitor) DoCounters(f func(CounterValue) error) error {
+	return m.counters.do(func(c *Counter) error {
+		return f(CounterValue{
+			Name:   c.name,
+			Labels: c.labels.clone(),
+			Value:  c.Value(),
+		})
+	})
+}
+
+// DoGauges performs the function f for all gauges.
+func (m *Monitor) DoGauges(f func(GaugeValue) error) error {
+	return m.gauges.do(func(g *Gauge) error {
+		return f(GaugeValue{
+			Name:   g.name,
+			Labels: g.labels.clone(),
+			Value:  g.Value(),
+		})
+	})
+}
+
+// Reset clears all collected values so far. Counters and gauges
+// are set to zero.
 func (m *Monitor) Reset() {
 	m.sw.reset()
 	m.ssi.reset()
+	m.counters.do(func(c *Counter) error {
+		c.value.Store(0)
+		return nil
+	})
+	m.gauges.do(func(g *Gauge) error {
+		g.Set(0)
+		return nil
+	})
 }
 
-// Stop terminates the monitor.
+// Stop terminates the background aggregation of the monitor.
 func (m *Monitor) Stop() {
-	m.sw.stop()
-	m.ssi.stop()
+	m.stopOnce.Do(func() {
+		close(m.doneC)
+	})
+}
+
+// backend periodically aggregates the accumulated values.
+func (m *Monitor) backend() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.doneC:
+			return
+		case <-ticker.C:
+			m.sw.aggregate()
+			m.ssi.aggregate()
+		}
+	}
 }
 
 // EOF
@@ -129,6 +129,34 @@ func TestStaySetIndicators(t *testing.T) {
 	Assert(t, Empty(ivs), "no indicator values")
 }
 
+// TestAggregation verifies the periodic aggregation of the
+// accumulated values.
+func TestAggregation(t *testing.T) {
+	m := monitor.New(monitor.WithAggregation(5 * time.Millisecond))
+	defer m.Stop()
+
+	for i := 0; i < 5; i++ {
+		m.StaySetIndicator().Increase("sampled")
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		m.StaySetIndicator().Decrease("sampled")
+	}
+	iv, err := m.StaySetIndicator().Read("sampled")
+	Assert(t, NoError(err), "no error for existing indicator")
+	Assert(t, Equal(iv.Count, 16), "indicator count is correct")
+	Assert(t, Equal(iv.Current, -4), "indicator current is correct")
+	Assert(t, Equal(iv.Min, -4), "minimum seen when reading")
+	Assert(t, Equal(iv.Max, 6), "maximum seen during aggregation")
+
+	for i := 0; i < 1000; i++ {
+		m.StopWatch().Measure("many", func() {})
+	}
+	wv, err := m.StopWatch().Read("many")
+	Assert(t, NoError(err), "no error for existing watch")
+	Assert(t, Equal(wv.Count, 1000), "all buffered measurings aggregated")
+}
+
 //--------------------
 // BENCHMARKS
 //--------------------
@@ -146,4 +174,44 @@ func BenchmarkMonitor(b *testing.B) {
 	}
 }
 
+// BenchmarkStopWatchParallel checks the performance of parallel
+// measurings of the same ID.
+func BenchmarkStopWatchParallel(b *testing.B) {
+	m := monitor.New()
+	defer m.Stop()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.StopWatch().Measure("bench", func() {})
+		}
+	})
+}
+
+// BenchmarkStaySetIndicatorParallel checks the performance of parallel
+// changes of the same indicator.
+func BenchmarkStaySetIndicatorParallel(b *testing.B) {
+	m := monitor.New()
+	defer m.Stop()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.StaySetIndicator().Increase("bench")
+		}
+	})
+}
+
+// BenchmarkCounterParallel checks the performance of parallel
+// increments of a labeled counter.
+func BenchmarkCounterParallel(b *testing.B) {
+	m := monitor.New()
+	defer m.Stop()
+	labels := monitor.Labels{"route": "/bench"}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Counter("requests", labels).Inc()
+		}
+	})
+}
+
 // EOF
@@ -0,0 +1,49 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math/rand"
+	"runtime"
+)
+
+//--------------------
+// SHARDS
+//--------------------
+
+const (
+	// maxShards limits the number of shards per metric.
+	maxShards = 64
+
+	// cacheLineSize is used to pad shards, so that they don't
+	// share a cache line.
+	cacheLineSize = 64
+)
+
+// shardCount returns the number of shards for the current number of
+// processors as power of two.
+func shardCount() int {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) && n < maxShards {
+		n <<= 1
+	}
+	return n
+}
+
+// shardIndex returns a random shard index for a number of shards
+// being a power of two. The random numbers of the top-level functions
+// of math/rand are lock-free.
+func shardIndex(n int) int {
+	return int(rand.Uint32()) & (n - 1)
+}
+
+// EOF
@@ -14,7 +14,7 @@ package monitor // import "tideland.dev/go/stew/monitor"
 import (
 	"fmt"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 //--------------------
@@ -24,6 +24,7 @@ import (
 // IndicatorValue manages the value range for one indicator.
 type IndicatorValue struct {
 	ID      string
+	Labels  Labels
 	Count   int
 	Current int
 	Min     int
@@ -32,177 +33,180 @@ type IndicatorValue struct {
 
 // String implements fmt.Stringer.
 func (iv *IndicatorValue) String() string {
-	return fmt.Sprintf("%s: %d / act %d / min %d / max %d", iv.ID, iv.Count, iv.Current, iv.Min, iv.Max)
+	return fmt.Sprintf("%s%s: %d / act %d / min %d / max %d", iv.ID, iv.Labels, iv.Count, iv.Current, iv.Min, iv.Max)
 }
 
-// update the indicator value.
-func (iv *IndicatorValue) update(shallIncr bool) {
-	// Check for initial values.
-	if iv.Count == 0 {
-		iv.Count = 1
-		iv.Current = 1
-		iv.Min = 1
-		iv.Max = 1
-	}
-	// Regular update.
-	iv.Count++
-	if shallIncr {
-		iv.Current++
-	} else {
-		iv.Current--
-	}
-	if iv.Current < iv.Min {
-		iv.Min = iv.Current
+// IndicatorValues is a set of stay-set values.
+type IndicatorValues []IndicatorValue
+
+// Implement the sort interface.
+
+func (ivs IndicatorValues) Len() int      { return len(ivs) }
+func (ivs IndicatorValues) Swap(i, j int) { ivs[i], ivs[j] = ivs[j], ivs[i] }
+func (ivs IndicatorValues) Less(i, j int) bool {
+	if ivs[i].ID != ivs[j].ID {
+		return ivs[i].ID < ivs[j].ID
 	}
-	if iv.Current > iv.Max {
-		iv.Max = iv.Current
+	return ivs[i].Labels.String() < ivs[j].Labels.String()
+}
+
+//--------------------
+// INDICATOR
+//--------------------
+
+// indicatorShard accumulates the changes of one indicator.
+type indicatorShard struct {
+	delta atomic.Int64
+	count atomic.Int64
+	_     [cacheLineSize]byte
+}
+
+// indicator contains the values of one ID and label set. Changes
+// are accumulated in shards and aggregated periodically or when the
+// indicator is read. So minimum and maximum are those seen during
+// the aggregations. Like before it starts with a count and value
+// of one.
+type indicator struct {
+	mu      sync.Mutex
+	id      string
+	labels  Labels
+	shards  []indicatorShard
+	count   int64
+	current int64
+	min     int64
+	max     int64
+}
+
+// newIndicator creates an indicator with the number of shards.
+func newIndicator(id string, labels Labels, shards int) *indicator {
+	return &indicator{
+		id:      id,
+		labels:  labels,
+		shards:  make([]indicatorShard, shards),
+		count:   1,
+		current: 1,
+		min:     1,
+		max:     1,
 	}
 }
 
-// IndicatorValues is a set of stay-set values.
-type IndicatorValues []IndicatorValue
+// change increases or decreases the indicator in one of the shards.
+func (i *indicator) change(delta int64) {
+	shard := &i.shards[shardIndex(len(i.shards))]
+	shard.delta.Add(delta)
+	shard.count.Add(1)
+}
 
-// Implement the sort interface.
+// aggregate moves the accumulated changes of the shards into
+// the values.
+func (i *indicator) aggregate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.aggregateLocked()
+}
+
+// aggregateLocked aggregates the shards. The caller must
+// hold the lock.
+func (i *indicator) aggregateLocked() {
+	for s := range i.shards {
+		shard := &i.shards[s]
+		i.count += shard.count.Swap(0)
+		i.current += shard.delta.Swap(0)
+	}
+	if i.current < i.min {
+		i.min = i.current
+	}
+	if i.current > i.max {
+		i.max = i.current
+	}
+}
 
-func (ivs IndicatorValues) Len() int           { return len(ivs) }
-func (ivs IndicatorValues) Swap(i, j int)      { ivs[i], ivs[j] = ivs[j], ivs[i] }
-func (ivs IndicatorValues) Less(i, j int) bool { return ivs[i].ID < ivs[j].ID }
+// value returns the indicator value.
+func (i *indicator) value() IndicatorValue {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.aggregateLocked()
+	return IndicatorValue{
+		ID:      i.id,
+		Labels:  i.labels.clone(),
+		Count:   int(i.count),
+		Current: int(i.current),
+		Min:     int(i.min),
+		Max:     int(i.max),
+	}
+}
 
 //--------------------
 // STAY-SET INDICATOR
 //--------------------
 
-// Describing increment or decrement of stay-set values.
-const (
-	up   = true
-	down = false
-)
-
 // StaySetIndicator allows to increase and decrease stay-set values.
 type StaySetIndicator struct {
-	actionC chan func()
-	doneC   chan struct{}
-	changes map[string][]bool
-	values  map[string]*IndicatorValue
+	indicators *registry[indicator]
 }
 
 // newStaySetIndicator creates a new StaySetIndicator.
 func newStaySetIndicator() *StaySetIndicator {
-	i := &StaySetIndicator{
-		actionC: make(chan func(), 128),
-		doneC:   make(chan struct{}),
-		changes: make(map[string][]bool),
-		values:  make(map[string]*IndicatorValue),
+	shards := shardCount()
+	return &StaySetIndicator{
+		indicators: newRegistry(func(id string, labels Labels) *indicator {
+			return newIndicator(id, labels, shards)
+		}),
 	}
-	go i.backend()
-	return i
 }
 
 // Increase increases a stay-set staySetIndicator.
 func (i *StaySetIndicator) Increase(id string) {
-	i.actionC <- func() {
-		i.changes[id] = append(i.changes[id], up)
-	}
+	i.IncreaseWithLabels(id, nil)
+}
+
+// IncreaseWithLabels increases a stay-set staySetIndicator with labels.
+func (i *StaySetIndicator) IncreaseWithLabels(id string, labels Labels) {
+	i.indicators.get(id, labels).change(1)
 }
 
 // Decrease decreases a stay-set staySetIndicator.
 func (i *StaySetIndicator) Decrease(id string) {
-	i.actionC <- func() {
-		i.changes[id] = append(i.changes[id], down)
-	}
+	i.DecreaseWithLabels(id, nil)
 }
 
-// Read returns a stay-set staySetIndicator.
-func (i *StaySetIndicator) Read(id string) (IndicatorValue, error) {
-	var iv *IndicatorValue
-	var err error
-	var wg sync.WaitGroup
-	wg.Add(1)
-	i.actionC <- func() {
-		defer wg.Done()
-		i.accumulateOne(id)
-		iv = i.values[id]
-		if iv == nil {
-			err = fmt.Errorf("indicator value '%s' does not exist", id)
-		}
-	}
-	wg.Wait()
-	if iv == nil {
-		return IndicatorValue{}, err
-	}
-	return *iv, nil
+// DecreaseWithLabels decreases a stay-set staySetIndicator with labels.
+func (i *StaySetIndicator) DecreaseWithLabels(id string, labels Labels) {
+	i.indicators.get(id, labels).change(-1)
 }
 
-// Do performs the function f for all values.
-func (i *StaySetIndicator) Do(f func(IndicatorValue) error) error {
-	var err error
-	var wg sync.WaitGroup
-	wg.Add(1)
-	i.actionC <- func() {
-		defer wg.Done()
-		i.accumulateAll()
-		for _, ssi := range i.values {
-			if err = f(*ssi); err != nil {
-				return
-			}
-		}
-	}
-	wg.Wait()
-	return err
+// Read returns a stay-set staySetIndicator.
+func (i *StaySetIndicator) Read(id string) (IndicatorValue, error) {
+	return i.ReadWithLabels(id, nil)
 }
 
-// reset clears all values.
-func (i *StaySetIndicator) reset() {
-	i.actionC <- func() {
-		i.changes = make(map[string][]bool)
-		i.values = make(map[string]*IndicatorValue)
+// ReadWithLabels returns a stay-set staySetIndicator with labels.
+func (i *StaySetIndicator) ReadWithLabels(id string, labels Labels) (IndicatorValue, error) {
+	ind, ok := i.indicators.lookup(id, labels)
+	if !ok {
+		return IndicatorValue{}, fmt.Errorf("indicator value '%s%s' does not exist", id, labels)
 	}
+	return ind.value(), nil
 }
 
-// stop terminates the indicator.
-func (i *StaySetIndicator) stop() {
-	close(i.doneC)
-}
-
-// backend rund the indicator backend goroutine.
-func (i *StaySetIndicator) backend() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-i.doneC:
-			return
-		case action := <-i.actionC:
-			action()
-		case <-ticker.C:
-			i.accumulateAll()
-		}
-	}
+// Do performs the function f for all values.
+func (i *StaySetIndicator) Do(f func(IndicatorValue) error) error {
+	return i.indicators.do(func(ind *indicator) error {
+		return f(ind.value())
+	})
 }
 
-// accumulateOne updates the indicator value for one ID.
-func (i *StaySetIndicator) accumulateOne(id string) {
-	changes, ok := i.changes[id]
-	if ok {
-		iv := i.values[id]
-		if iv == nil {
-			iv = &IndicatorValue{
-				ID: id,
-			}
-			i.values[id] = iv
-		}
-		for _, increment := range changes {
-			iv.update(increment)
-		}
-		i.changes[id] = []bool{}
-	}
+// aggregate aggregates the changes of all indicators.
+func (i *StaySetIndicator) aggregate() {
+	i.indicators.do(func(ind *indicator) error {
+		ind.aggregate()
+		return nil
+	})
 }
 
-// accumulateAll updates all indicator values.
-func (i *StaySetIndicator) accumulateAll() {
-	for id := range i.changes {
-		i.accumulateOne(id)
-	}
+// reset clears all values.
+func (i *StaySetIndicator) reset() {
+	i.indicators.reset()
 }
 
 // EOF
@@ -25,26 +25,35 @@ import (
 // the starting time of the measuring and able to pass this data after
 // the end of the measuring to the measurer.
 type Measuring struct {
-	owner *StopWatch
-	id    string
-	begin time.Time
+	owner  *StopWatch
+	id     string
+	labels Labels
+	begin  time.Time
 }
 
 // End ends the measuring and passes it to the measurer.
 func (m *Measuring) End() time.Duration {
-	duration := time.Since(m.begin)
-	m.owner.end(m.id, duration)
+	now := time.Now()
+	duration := now.Sub(m.begin)
+	m.owner.watches.get(m.id, m.labels).record(now, duration)
 	return duration
 }
 
-// WatchValue manages the value range for one watch.
+// WatchValue manages the value range for one watch. Beside count, total,
+// minimum, maximum, and average it contains the percentiles of the
+// durations.
 type WatchValue struct {
-	ID    string
-	Count int
-	Total time.Duration
-	Min   time.Duration
-	Max   time.Duration
-	Avg   time.Duration
+	ID     string
+	Labels Labels
+	Count  int
+	Total  time.Duration
+	Min    time.Duration
+	Max    time.Duration
+	Avg    time.Duration
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	P999   time.Duration
 }
 
 // String implements fmt.Stringer.
@@ -54,30 +63,30 @@ func (wv *WatchValue) String() string {
 	min := float64(wv.Min) / factor
 	max := float64(wv.Max) / factor
 	avg := float64(wv.Avg) / factor
-	return fmt.Sprintf("%s: %d / total %.4f ms / min %.4f ms / max %.4f ms / avg %.4f ms", wv.ID, wv.Count, total, min, max, avg)
-}
-
-// update the value with a new measured duration.
-func (wv *WatchValue) update(duration time.Duration) {
-	// Check for initial values.
-	if wv.Count == 0 {
-		wv.Count = 1
-		wv.Total = duration
-		wv.Min = duration
-		wv.Max = duration
-		wv.Avg = duration
-		return
-	}
-	// Regular update.
-	wv.Count++
-	wv.Total += duration
-	if wv.Min > duration {
-		wv.Min = duration
+	p50 := float64(wv.P50) / factor
+	p99 := float64(wv.P99) / factor
+	return fmt.Sprintf("%s%s: %d / total %.4f ms / min %.4f ms / max %.4f ms / avg %.4f ms / p50 %.4f ms / p99 %.4f ms",
+		wv.ID, wv.Labels, wv.Count, total, min, max, avg, p50, p99)
+}
+
+// newWatchValue creates the watch value for the histogram.
+func newWatchValue(id string, labels Labels, h *Histogram) WatchValue {
+	wv := WatchValue{
+		ID:     id,
+		Labels: labels.clone(),
+		Count:  h.Count(),
+		Total:  h.Sum(),
+		Min:    h.Min(),
+		Max:    h.Max(),
+		P50:    h.Quantile(0.5),
+		P90:    h.Quantile(0.9),
+		P99:    h.Quantile(0.99),
+		P999:   h.Quantile(0.999),
 	}
-	if wv.Max < duration {
-		wv.Max = duration
+	if wv.Count > 0 {
+		wv.Avg = time.Duration(int64(wv.Total) / int64(wv.Count))
 	}
-	wv.Avg = time.Duration(int64(wv.Total) / int64(wv.Count))
+	return wv
 }
 
 // WatchValues is a set of values.
@@ -85,9 +94,137 @@ type WatchValues []WatchValue
 
 // Implement the sort interface.
 
-func (wvs WatchValues) Len() int           { return len(wvs) }
-func (wvs WatchValues) Swap(i, j int)      { wvs[i], wvs[j] = wvs[j], wvs[i] }
-func (wvs WatchValues) Less(i, j int) bool { return wvs[i].ID < wvs[j].ID }
+func (wvs WatchValues) Len() int      { return len(wvs) }
+func (wvs WatchValues) Swap(i, j int) { wvs[i], wvs[j] = wvs[j], wvs[i] }
+func (wvs WatchValues) Less(i, j int) bool {
+	if wvs[i].ID != wvs[j].ID {
+		return wvs[i].ID < wvs[j].ID
+	}
+	return wvs[i].Labels.String() < wvs[j].Labels.String()
+}
+
+//--------------------
+// WATCH
+//--------------------
+
+// maxShardMeasurings is the number of measurings a shard buffers
+// before they are aggregated.
+const maxShardMeasurings = 256
+
+// watchShard buffers measurings of one watch.
+type watchShard struct {
+	mu         sync.Mutex
+	measurings []measuring
+	_          [cacheLineSize]byte
+}
+
+// measuring is one ended measuring.
+type measuring struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// watch collects the measurings of one ID and label set. They are
+// buffered in shards and aggregated periodically, when a buffer is
+// full, or when the watch is read.
+type watch struct {
+	mu     sync.Mutex
+	id     string
+	labels Labels
+	shards []watchShard
+	hist   *Histogram
+	window timeWindow
+	last   time.Time
+}
+
+// newWatch creates a watch with the number of shards.
+func newWatch(id string, labels Labels, shards int) *watch {
+	return &watch{
+		id:     id,
+		labels: labels,
+		shards: make([]watchShard, shards),
+		hist:   NewHistogram(),
+	}
+}
+
+// record adds a measured duration to one of the shards.
+func (w *watch) record(at time.Time, duration time.Duration) {
+	shard := &w.shards[shardIndex(len(w.shards))]
+	shard.mu.Lock()
+	shard.measurings = append(shard.measurings, measuring{at, duration})
+	if len(shard.measurings) < maxShardMeasurings {
+		shard.mu.Unlock()
+		return
+	}
+	measurings := shard.measurings
+	shard.measurings = make([]measuring, 0, maxShardMeasurings)
+	shard.mu.Unlock()
+	w.mu.Lock()
+	w.apply(measurings)
+	w.mu.Unlock()
+}
+
+// aggregate moves the buffered measurings of all shards into the
+// histogram and the time window.
+func (w *watch) aggregate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aggregateLocked()
+}
+
+// aggregateLocked aggregates the shards. The caller must
+// hold the lock.
+func (w *watch) aggregateLocked() {
+	for i := range w.shards {
+		shard := &w.shards[i]
+		shard.mu.Lock()
+		w.apply(shard.measurings)
+		shard.measurings = shard.measurings[:0]
+		shard.mu.Unlock()
+	}
+}
+
+// apply records the measurings. The caller must hold the lock.
+func (w *watch) apply(measurings []measuring) {
+	for _, m := range measurings {
+		w.hist.Record(m.duration)
+		w.window.record(m.at, m.duration)
+		if m.at.After(w.last) {
+			w.last = m.at
+		}
+	}
+}
+
+// value returns the watch value of all or the windowed measurings.
+func (w *watch) value(window time.Duration) WatchValue {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aggregateLocked()
+	if window > 0 {
+		return newWatchValue(w.id, w.labels, w.window.histogram(time.Now(), window))
+	}
+	return newWatchValue(w.id, w.labels, w.hist)
+}
+
+// histogram returns a copy of the histogram of all or the
+// measurings in the window ending now.
+func (w *watch) histogram(now time.Time, window time.Duration) *Histogram {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aggregateLocked()
+	if window > 0 {
+		return w.window.histogram(now, window)
+	}
+	return w.hist.Clone()
+}
+
+// lastMeasuring returns the time of the latest measuring.
+func (w *watch) lastMeasuring() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aggregateLocked()
+	return w.last
+}
 
 //--------------------
 // STOP WATCH
@@ -96,138 +233,118 @@ func (wvs WatchValues) Less(i, j int) bool { return wvs[i].ID < wvs[j].ID }
 // StopWatch allows to measure the execution time of
 // code fragments.
 type StopWatch struct {
-	actionC    chan func()
-	doneC      chan struct{}
-	measurings map[string][]time.Duration
-	values     map[string]*WatchValue
+	watches *registry[watch]
 }
 
 // newStopWatch creates a new stop watch.
 func newStopWatch() *StopWatch {
-	s := &StopWatch{
-		actionC:    make(chan func(), 128),
-		doneC:      make(chan struct{}),
-		measurings: make(map[string][]time.Duration),
-		values:     make(map[string]*WatchValue),
+	shards := shardCount()
+	return &StopWatch{
+		watches: newRegistry(func(id string, labels Labels) *watch {
+			return newWatch(id, labels, shards)
+		}),
 	}
-	go s.backend()
-	return s
 }
 
 // Begin starts a new measuring with a given id.
 func (s *StopWatch) Begin(id string) *Measuring {
-	return &Measuring{
-		owner: s,
-		id:    id,
-		begin: time.Now(),
-	}
+	return s.BeginWithLabels(id, nil)
 }
 
-// end returns a measuring to the collected ones.
-func (s *StopWatch) end(id string, duration time.Duration) {
-	s.actionC <- func() {
-		s.measurings[id] = append(s.measurings[id], duration)
+// BeginWithLabels starts a new measuring with a given id and labels.
+func (s *StopWatch) BeginWithLabels(id string, labels Labels) *Measuring {
+	return &Measuring{
+		owner:  s,
+		id:     id,
+		labels: labels,
+		begin:  time.Now(),
 	}
 }
 
 // Measure measures the execution time of one function.
 func (s *StopWatch) Measure(id string, f func()) time.Duration {
-	m := s.Begin(id)
+	return s.MeasureWithLabels(id, nil, f)
+}
+
+// MeasureWithLabels measures the execution time of one function
+// with labels.
+func (s *StopWatch) MeasureWithLabels(id string, labels Labels, f func()) time.Duration {
+	m := s.BeginWithLabels(id, labels)
 	f()
 	return m.End()
 }
 
 // Read returns the measuring point for an id.
 func (s *StopWatch) Read(id string) (WatchValue, error) {
-	var wv *WatchValue
-	var err error
-	var wg sync.WaitGroup
-	wg.Add(1)
-	s.actionC <- func() {
-		defer wg.Done()
-		s.accumulateOne(id)
-		wv = s.values[id]
-		if wv == nil {
-			err = fmt.Errorf("watch value '%s' does not exist", id)
-		}
-	}
-	wg.Wait()
-	if wv == nil {
+	return s.ReadWithLabels(id, nil)
+}
+
+// ReadWithLabels returns the measuring point for an id and labels.
+func (s *StopWatch) ReadWithLabels(id string, labels Labels) (WatchValue, error) {
+	w, err := s.lookup(id, labels)
+	if err != nil {
 		return WatchValue{}, err
 	}
-	return *wv, nil
+	return w.value(0), nil
 }
 
-// Do performs the function f for all measuring points.
-func (s *StopWatch) Do(f func(WatchValue) error) error {
-	var err error
-	var wg sync.WaitGroup
-	wg.Add(1)
-	s.actionC <- func() {
-		defer wg.Done()
-		s.accumulateAll()
-		for _, wv := range s.values {
-			if err = f(*wv); err != nil {
-				return
-			}
-		}
+// ReadWindow returns the measuring point for an id only containing
+// the measurings of the last window, e.g. 1, 5, or 15 minutes. The
+// window is rounded up to full minutes, the maximum is 15 minutes.
+func (s *StopWatch) ReadWindow(id string, window time.Duration) (WatchValue, error) {
+	w, err := s.lookup(id, nil)
+	if err != nil {
+		return WatchValue{}, err
 	}
-	wg.Wait()
-	return err
+	if window <= 0 {
+		window = windowSlotSize
+	}
+	return w.value(window), nil
 }
 
-// reset clears all values.
-func (s *StopWatch) reset() {
-	s.actionC <- func() {
-		s.measurings = make(map[string][]time.Duration)
-		s.values = make(map[string]*WatchValue)
+// Histogram returns a copy of the histogram of an id, e.g. for merging
+// it with the ones of other stop watches.
+func (s *StopWatch) Histogram(id string) (*Histogram, error) {
+	return s.HistogramWithLabels(id, nil)
+}
+
+// HistogramWithLabels returns a copy of the histogram of an id
+// and labels.
+func (s *StopWatch) HistogramWithLabels(id string, labels Labels) (*Histogram, error) {
+	w, err := s.lookup(id, labels)
+	if err != nil {
+		return nil, err
 	}
+	return w.histogram(time.Now(), 0), nil
 }
 
-// stop terminates the indicator.
-func (s *StopWatch) stop() {
-	close(s.doneC)
+// Do performs the function f for all measuring points.
+func (s *StopWatch) Do(f func(WatchValue) error) error {
+	return s.watches.do(func(w *watch) error {
+		return f(w.value(0))
+	})
 }
 
-// backend rund the indicator backend goroutine.
-func (s *StopWatch) backend() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-s.doneC:
-			return
-		case action := <-s.actionC:
-			action()
-		case <-ticker.C:
-			s.accumulateAll()
-		}
+// lookup returns the watch for id and labels.
+func (s *StopWatch) lookup(id string, labels Labels) (*watch, error) {
+	w, ok := s.watches.lookup(id, labels)
+	if !ok {
+		return nil, fmt.Errorf("watch value '%s%s' does not exist", id, labels)
 	}
+	return w, nil
 }
 
-// accumulateOne updates one watch value.
-func (s *StopWatch) accumulateOne(id string) {
-	measurings, ok := s.measurings[id]
-	if ok {
-		wv := s.values[id]
-		if wv == nil {
-			wv = &WatchValue{
-				ID: id,
-			}
-			s.values[id] = wv
-		}
-		for _, duration := range measurings {
-			wv.update(duration)
-		}
-		s.measurings[id] = []time.Duration{}
-	}
+// aggregate aggregates the buffered measurings of all watches.
+func (s *StopWatch) aggregate() {
+	s.watches.do(func(w *watch) error {
+		w.aggregate()
+		return nil
+	})
 }
 
-// accumulateAll accumulates all watch values.
-func (s *StopWatch) accumulateAll() {
-	for id := range s.measurings {
-		s.accumulateOne(id)
-	}
+// reset clears all values.
+func (s *StopWatch) reset() {
+	s.watches.reset()
 }
 
 // EOF
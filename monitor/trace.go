@@ -0,0 +1,258 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+//--------------------
+// IDS
+//--------------------
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the ID as hex string.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks if the ID is not zero.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span inside a trace.
+type SpanID [8]byte
+
+// String returns the ID as hex string.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks if the ID is not zero.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// newTraceID creates a random trace ID.
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID creates a random span ID.
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// SpanContext contains the IDs of a span and if it is sampled. It is
+// propagated to child spans, also across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	Remote  bool
+}
+
+// IsValid checks if trace and span ID are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+//--------------------
+// SPAN
+//--------------------
+
+// SpanEvent is an event happened during a span.
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]any
+}
+
+// SpanData contains the data of an ended span for exporting.
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Events     []SpanEvent
+	Err        error
+}
+
+// Duration returns the duration of the span.
+func (sd SpanData) Duration() time.Duration {
+	return sd.End.Sub(sd.Start)
+}
+
+// Span describes one operation of a trace. It is started with
+// StartSpan and has to be ended with End.
+type Span struct {
+	mu      sync.Mutex
+	monitor *Monitor
+	data    SpanData
+	ended   bool
+}
+
+// Context returns the span context.
+func (s *Span) Context() SpanContext {
+	return s.data.Context
+}
+
+// SetAttribute sets an attribute of the span, e.g. the
+// URL of a request.
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// AddEvent adds a named event with optional attributes.
+func (s *Span) AddEvent(name string, attributes map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Events = append(s.data.Events, SpanEvent{
+		Name:       name,
+		Time:       time.Now(),
+		Attributes: attributes,
+	})
+}
+
+// RecordError marks the span as failed with the error.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.Err = err
+}
+
+// End ends the span. Its duration is measured by the stop watch of
+// the monitor with the span name as ID and sampled spans are passed
+// to the exporter of the monitor. Export errors are counted by the
+// counter trace_export_errors. Further calls are ignored.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if s.monitor == nil {
+		return
+	}
+	s.monitor.sw.watches.get(data.Name, nil).record(data.End, data.Duration())
+	if data.Context.Sampled && s.monitor.exporter != nil {
+		if err := s.monitor.exporter.Export(data); err != nil {
+			s.monitor.Counter("trace_export_errors", nil).Inc()
+		}
+	}
+}
+
+//--------------------
+// SPAN CONTEXT
+//--------------------
+
+// spanContextKey is the context key for spans.
+const spanContextKey contextKey = 2
+
+// remoteContextKey is the context key for remote span contexts.
+const remoteContextKey contextKey = 3
+
+// StartSpan starts a new span with the name. If the context contains a
+// span or a remote span context, e.g. extracted from a request, it becomes
+// the parent. Otherwise a new trace is started. The monitor of the context
+// is used for measuring and exporting. The returned context contains
+// the new span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		data: SpanData{
+			Name:  name,
+			Start: time.Now(),
+		},
+	}
+	if m, ok := FromContext(ctx); ok {
+		span.monitor = &m
+	}
+	parent, ok := SpanContextFromContext(ctx)
+	if ok {
+		span.data.Context = SpanContext{
+			TraceID: parent.TraceID,
+			SpanID:  newSpanID(),
+			Sampled: parent.Sampled,
+		}
+		span.data.ParentID = parent.SpanID
+	} else {
+		span.data.Context = SpanContext{
+			TraceID: newTraceID(),
+			SpanID:  newSpanID(),
+			Sampled: true,
+		}
+	}
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// SpanFromContext returns the current span of the context.
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanContextKey).(*Span)
+	return span, ok
+}
+
+// SpanContextFromContext returns the span context of the current span or,
+// if there's none, the remote one of the context.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span, ok := SpanFromContext(ctx); ok {
+		return span.Context(), true
+	}
+	sc, ok := ctx.Value(remoteContextKey).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// ContextWithRemoteSpanContext returns a context containing the span
+// context of a remote parent.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteContextKey, sc)
+}
+
+// EOF
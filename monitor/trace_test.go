@@ -0,0 +1,228 @@
+// Tideland Go Stew - Monitor - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/monitor"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestSpans verifies starting and ending spans with parents.
+func TestSpans(t *testing.T) {
+	spans := &collector{}
+	m := monitor.New(monitor.WithExporter(spans))
+	defer m.Stop()
+	ctx := monitor.NewContext(context.Background(), *m)
+
+	ctx, root := monitor.StartSpan(ctx, "root")
+	root.SetAttribute("user", "alice")
+	_, child := monitor.StartSpan(ctx, "child")
+	child.AddEvent("cache miss", map[string]any{"key": "foo"})
+	child.RecordError(errors.New("ouch"))
+	child.End()
+	root.End()
+	root.End()
+
+	Assert(t, Length(spans.data, 2), "two spans exported")
+	cd, rd := spans.data[0], spans.data[1]
+	Assert(t, Equal(cd.Name, "child"), "child ended first")
+	Assert(t, Equal(cd.Context.TraceID, rd.Context.TraceID), "same trace")
+	Assert(t, Equal(cd.ParentID, rd.Context.SpanID), "root is parent")
+	Assert(t, False(rd.ParentID.IsValid()), "root has no parent")
+	Assert(t, Equal(rd.Attributes["user"], "alice"), "attribute set")
+	Assert(t, Length(cd.Events, 1), "event added")
+	Assert(t, ErrorContains(cd.Err, "ouch"), "error recorded")
+
+	wv, err := m.StopWatch().Read("child")
+	Assert(t, NoError(err), "span measured")
+	Assert(t, Equal(wv.Count, 1), "one measuring")
+
+	span, ok := monitor.SpanFromContext(ctx)
+	Assert(t, True(ok), "span in context")
+	Assert(t, Equal(span, root), "root span in context")
+}
+
+// TestTraceparent verifies parsing and formatting the W3C header.
+func TestTraceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := monitor.ParseTraceparent(value)
+	Assert(t, NoError(err), "valid header parsed")
+	Assert(t, Equal(sc.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736"), "trace ID parsed")
+	Assert(t, Equal(sc.SpanID.String(), "00f067aa0ba902b7"), "span ID parsed")
+	Assert(t, True(sc.Sampled), "sampled flag parsed")
+	Assert(t, True(sc.Remote), "marked as remote")
+	Assert(t, Equal(monitor.FormatTraceparent(sc), value), "formatted like parsed")
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := monitor.ParseTraceparent(invalid)
+		Assert(t, ErrorContains(err, "invalid"), "invalid header rejected")
+	}
+}
+
+// TestTracingHTTP verifies the propagation between client and server.
+func TestTracingHTTP(t *testing.T) {
+	spans := &collector{}
+	m := monitor.New(monitor.WithExporter(spans))
+	defer m.Stop()
+
+	var serverSC monitor.SpanContext
+	handler := monitor.NewTracingHandler(m, "server", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span, ok := monitor.SpanFromContext(r.Context())
+		if ok {
+			serverSC = span.Context()
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client := &http.Client{Transport: monitor.NewTracingTransport(nil)}
+	ctx := monitor.NewContext(context.Background(), *m)
+	ctx, root := monitor.StartSpan(ctx, "root")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/tea", nil)
+	Assert(t, NoError(err), "request created")
+	resp, err := client.Do(req)
+	Assert(t, NoError(err), "request done")
+	resp.Body.Close()
+	root.End()
+
+	Assert(t, Equal(serverSC.TraceID, root.Context().TraceID), "trace propagated")
+	data := spans.byName()
+	Assert(t, Equal(data["server"].ParentID, data["http.client"].Context.SpanID), "client span is parent of server span")
+	Assert(t, Equal(data["http.client"].ParentID, root.Context().SpanID), "root is parent of client span")
+	Assert(t, Equal(data["server"].Attributes["http.path"], "/tea"), "path recorded")
+	Assert(t, Equal(data["server"].Attributes["http.status_code"], http.StatusTeapot), "status recorded")
+}
+
+// TestJSONLinesExporter verifies the writing of spans as JSON lines.
+func TestJSONLinesExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := monitor.New(monitor.WithExporter(monitor.NewJSONLinesExporter(buf)))
+	defer m.Stop()
+	ctx := monitor.NewContext(context.Background(), *m)
+
+	ctx, root := monitor.StartSpan(ctx, "root")
+	_, child := monitor.StartSpan(ctx, "child")
+	child.RecordError(errors.New("ouch"))
+	child.End()
+	root.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	Assert(t, Length(lines, 2), "one line per span")
+	var span map[string]any
+	err := json.Unmarshal([]byte(lines[0]), &span)
+	Assert(t, NoError(err), "line is JSON")
+	Assert(t, Equal(span["name"], "child"), "name written")
+	Assert(t, Equal(span["trace_id"], any(root.Context().TraceID.String())), "trace ID written")
+	Assert(t, Equal(span["parent_id"], any(root.Context().SpanID.String())), "parent ID written")
+	Assert(t, Equal(span["error"], "ouch"), "error written")
+}
+
+// TestOTLPJSONExporter verifies the writing of spans as OTLP-JSON.
+func TestOTLPJSONExporter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := monitor.New(monitor.WithExporter(monitor.NewOTLPJSONExporter(buf, "tester")))
+	defer m.Stop()
+	ctx := monitor.NewContext(context.Background(), *m)
+
+	_, span := monitor.StartSpan(ctx, "operation")
+	span.SetAttribute("count", 42)
+	span.SetAttribute("ok", false)
+	span.RecordError(errors.New("ouch"))
+	span.End()
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string           `json:"traceId"`
+					Name              string           `json:"name"`
+					StartTimeUnixNano string           `json:"startTimeUnixNano"`
+					Attributes        []map[string]any `json:"attributes"`
+					Status            struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &req)
+	Assert(t, NoError(err), "request is JSON")
+	Assert(t, Length(req.ResourceSpans, 1), "one resource span")
+	rs := req.ResourceSpans[0]
+	Assert(t, Equal(rs.Resource.Attributes[0]["key"], "service.name"), "service name set")
+	os := rs.ScopeSpans[0].Spans[0]
+	Assert(t, Equal(os.TraceID, span.Context().TraceID.String()), "trace ID as hex")
+	Assert(t, Equal(os.Name, "operation"), "name set")
+	Assert(t, NotEmpty(os.StartTimeUnixNano), "start time set")
+	Assert(t, DeepEqual(os.Attributes[0]["value"], any(map[string]any{"intValue": "42"})), "int attribute")
+	Assert(t, DeepEqual(os.Attributes[1]["value"], any(map[string]any{"boolValue": false})), "bool attribute")
+	Assert(t, Equal(os.Status.Code, 2), "error status")
+	Assert(t, Equal(os.Status.Message, "ouch"), "error message")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// collector collects exported spans.
+type collector struct {
+	mu   sync.Mutex
+	data []monitor.SpanData
+}
+
+// Export implements monitor.Exporter.
+func (c *collector) Export(span monitor.SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = append(c.data, span)
+	return nil
+}
+
+// byName returns the collected spans by name.
+func (c *collector) byName() map[string]monitor.SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make(map[string]monitor.SpanData)
+	for _, span := range c.data {
+		data[span.Name] = span
+	}
+	return data
+}
+
+// EOF
@@ -0,0 +1,283 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//--------------------
+// EXPORTER
+//--------------------
+
+// Exporter receives the ended and sampled spans.
+type Exporter interface {
+	// Export exports the data of one span.
+	Export(span SpanData) error
+}
+
+// ExporterFunc is a function implementing Exporter.
+type ExporterFunc func(span SpanData) error
+
+// Export implements Exporter.
+func (f ExporterFunc) Export(span SpanData) error {
+	return f(span)
+}
+
+//--------------------
+// JSON LINES EXPORTER
+//--------------------
+
+// jsonSpan is the JSON lines representation of a span.
+type jsonSpan struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Name       string         `json:"name"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Duration   int64          `json:"duration_ns"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Events     []jsonEvent    `json:"events,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// jsonEvent is the JSON lines representation of a span event.
+type jsonEvent struct {
+	Name       string         `json:"name"`
+	Time       time.Time      `json:"time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// jsonLinesExporter writes spans as JSON lines.
+type jsonLinesExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesExporter creates an exporter writing each span as one
+// line of JSON to the writer.
+func NewJSONLinesExporter(w io.Writer) Exporter {
+	return &jsonLinesExporter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Export implements Exporter.
+func (e *jsonLinesExporter) Export(span SpanData) error {
+	js := jsonSpan{
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Name:       span.Name,
+		Start:      span.Start,
+		End:        span.End,
+		Duration:   int64(span.Duration()),
+		Attributes: span.Attributes,
+	}
+	if span.ParentID.IsValid() {
+		js.ParentID = span.ParentID.String()
+	}
+	for _, event := range span.Events {
+		js.Events = append(js.Events, jsonEvent(event))
+	}
+	if span.Err != nil {
+		js.Error = span.Err.Error()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(js); err != nil {
+		return fmt.Errorf("cannot export span: %w", err)
+	}
+	return nil
+}
+
+//--------------------
+// OTLP JSON EXPORTER
+//--------------------
+
+// OTLP status codes and span kind.
+const (
+	otlpStatusUnset    = 0
+	otlpStatusError    = 2
+	otlpSpanKindInside = 1
+	otlpScopeName      = "tideland.dev/go/stew/monitor"
+)
+
+// otlpRequest is an OTLP trace export request.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpJSONExporter writes spans as OTLP-JSON export requests.
+type otlpJSONExporter struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	resource otlpResource
+}
+
+// NewOTLPJSONExporter creates an exporter writing each span as one line
+// containing an OTLP-JSON trace export request. The service name is set
+// as resource attribute.
+func NewOTLPJSONExporter(w io.Writer, serviceName string) Exporter {
+	return &otlpJSONExporter{
+		enc: json.NewEncoder(w),
+		resource: otlpResource{
+			Attributes: otlpAttributes(map[string]any{"service.name": serviceName}),
+		},
+	}
+}
+
+// Export implements Exporter.
+func (e *otlpJSONExporter) Export(span SpanData) error {
+	os := otlpSpan{
+		TraceID:           span.Context.TraceID.String(),
+		SpanID:            span.Context.SpanID.String(),
+		Name:              span.Name,
+		Kind:              otlpSpanKindInside,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+	if span.ParentID.IsValid() {
+		os.ParentSpanID = span.ParentID.String()
+	}
+	for _, event := range span.Events {
+		os.Events = append(os.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+	if span.Err != nil {
+		os.Status = otlpStatus{
+			Code:    otlpStatusError,
+			Message: span.Err.Error(),
+		}
+	}
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: e.resource,
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: otlpScopeName},
+				Spans: []otlpSpan{os},
+			}},
+		}},
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(req); err != nil {
+		return fmt.Errorf("cannot export span: %w", err)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes into sorted OTLP key/values.
+func otlpAttributes(attributes map[string]any) []otlpKeyValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+	for key, value := range attributes {
+		kvs = append(kvs, otlpKeyValue{
+			Key:   key,
+			Value: newOTLPValue(value),
+		})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
+
+// newOTLPValue converts a value into an OTLP value.
+func newOTLPValue(value any) otlpValue {
+	var ov otlpValue
+	switch v := value.(type) {
+	case string:
+		ov.StringValue = &v
+	case bool:
+		ov.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		ov.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		ov.IntValue = &s
+	case float64:
+		ov.DoubleValue = &v
+	default:
+		s := fmt.Sprintf("%v", v)
+		ov.StringValue = &s
+	}
+	return ov
+}
+
+// EOF
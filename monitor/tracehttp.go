@@ -0,0 +1,211 @@
+// Tideland Go Stew - Monitor
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package monitor // import "tideland.dev/go/stew/monitor"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//--------------------
+// TRACE CONTEXT
+//--------------------
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "Traceparent"
+
+// traceparentVersion is the supported version of the header.
+const traceparentVersion = "00"
+
+// sampledFlag is the trace flag for sampled spans.
+const sampledFlag = 0x01
+
+// FormatTraceparent returns the span context as value of the
+// traceparent header.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses the value of a traceparent header. The
+// returned span context is marked as remote.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	if parts[0] == traceparentVersion && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s'", value)
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace ID in traceparent '%s': %v", value, err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span ID in traceparent '%s': %v", value, err)
+	}
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid flags in traceparent '%s': %v", value, err)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent '%s': zero ID", value)
+	}
+	sc.Sampled = flags[0]&sampledFlag != 0
+	sc.Remote = true
+	return sc, nil
+}
+
+// decodeHex decodes the lower case hex string into the destination
+// which has to match exactly.
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("need %d lower case hex digits", 2*len(dst))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// InjectHTTP sets the traceparent header for the span context of
+// the context, if there's one.
+func InjectHTTP(ctx context.Context, header http.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	header.Set(TraceparentHeader, FormatTraceparent(sc))
+}
+
+// ExtractHTTP returns a context containing the remote span context of
+// the traceparent header. Missing or invalid headers are ignored.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+//--------------------
+// HTTP SERVER
+//--------------------
+
+// statusRecorder keeps the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// NewTracingHandler wraps the handler so that each request runs inside a
+// span with the name. Its parent is taken from the traceparent header.
+// The context passed to the handler contains the monitor and the span.
+// Method, path, and status code are set as attributes, server errors are
+// recorded as error.
+func NewTracingHandler(m *Monitor, name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(r.Context(), *m)
+		ctx = ExtractHTTP(ctx, r.Header)
+		ctx, span := StartSpan(ctx, name)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.path", r.URL.Path)
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", sr.status)
+		if sr.status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("status %d", sr.status))
+		}
+	})
+}
+
+//--------------------
+// HTTP CLIENT
+//--------------------
+
+// tracingTransport injects the span context into requests.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// NewTracingTransport wraps the round tripper so that requests carry the
+// traceparent header of the span in their context. If the context contains
+// a monitor a client span is started for each request. A nil base uses
+// http.DefaultTransport.
+func NewTracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{
+		base: base,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (tt *tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	if _, ok := FromContext(ctx); !ok {
+		if _, ok := SpanContextFromContext(ctx); !ok {
+			return tt.base.RoundTrip(r)
+		}
+		r = r.Clone(ctx)
+		InjectHTTP(ctx, r.Header)
+		return tt.base.RoundTrip(r)
+	}
+	ctx, span := StartSpan(ctx, "http.client")
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.String())
+	r = r.Clone(ctx)
+	InjectHTTP(ctx, r.Header)
+	resp, err := tt.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.RecordError(fmt.Errorf("status %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// EOF
@@ -0,0 +1,156 @@
+// Tideland Go Stew - Time Extensions
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex // import "tideland.dev/go/stew/timex"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// defaultHistoryCap is the default number of runs recorded per job.
+const defaultHistoryCap = 10
+
+// OverlapPolicy defines what happens if a job is triggered
+// while a previous run is still working.
+type OverlapPolicy int
+
+const (
+	// OverlapAllow runs the job concurrently. This is the default.
+	OverlapAllow OverlapPolicy = iota
+
+	// OverlapSkip skips the new run.
+	OverlapSkip
+
+	// OverlapQueue runs the job again after the current run.
+	OverlapQueue
+
+	// OverlapReplace cancels the context of the current run
+	// and starts a new one.
+	OverlapReplace
+)
+
+//--------------------
+// RUN
+//--------------------
+
+// Run describes one execution of a job.
+type Run struct {
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+	Skipped  bool
+}
+
+//--------------------
+// CRONJOB
+//--------------------
+
+// cronjob is the internal type for a job of the cron server.
+type cronjob struct {
+	id           string
+	schedule     Schedule
+	last         time.Time
+	next         time.Time
+	job          ContextJob
+	overlap      OverlapPolicy
+	timeout      time.Duration
+	jitter       time.Duration
+	catchUp      int
+	recovering   bool
+	recoverLimit int
+	historyCap   int
+	running      int
+	pending      int
+	cancel       func()
+}
+
+// jitterDelay returns a random delay up to the configured jitter.
+func (cj *cronjob) jitterDelay() time.Duration {
+	if cj.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(cj.jitter)))
+}
+
+//--------------------
+// JOB OPTIONS
+//--------------------
+
+// JobOption defines the signature of a job option setting function.
+type JobOption func(cj *cronjob) error
+
+// WithOverlap sets the policy for runs triggered while the
+// previous run is still working.
+func WithOverlap(policy OverlapPolicy) JobOption {
+	return func(cj *cronjob) error {
+		if policy < OverlapAllow || policy > OverlapReplace {
+			return fmt.Errorf("unknown overlap policy %d", policy)
+		}
+		cj.overlap = policy
+		return nil
+	}
+}
+
+// WithRunTimeout sets the timeout of the context passed to
+// each run of the job.
+func WithRunTimeout(timeout time.Duration) JobOption {
+	return func(cj *cronjob) error {
+		if timeout <= 0 {
+			return fmt.Errorf("run timeout must be positive")
+		}
+		cj.timeout = timeout
+		return nil
+	}
+}
+
+// WithJitter delays the start of each run randomly up to
+// the given duration.
+func WithJitter(jitter time.Duration) JobOption {
+	return func(cj *cronjob) error {
+		if jitter < 0 {
+			return fmt.Errorf("jitter must not be negative")
+		}
+		cj.jitter = jitter
+		return nil
+	}
+}
+
+// WithCatchUp lets the crontab run up to max missed runs, e.g.
+// after the process has been suspended. The missed runs are
+// executed one after another. By default they are dropped.
+func WithCatchUp(max int) JobOption {
+	return func(cj *cronjob) error {
+		if max < 0 {
+			return fmt.Errorf("catch-up must not be negative")
+		}
+		cj.catchUp = max
+		return nil
+	}
+}
+
+// WithHistory sets the number of runs recorded for the job.
+func WithHistory(size int) JobOption {
+	return func(cj *cronjob) error {
+		if size < 1 {
+			return fmt.Errorf("history size must be positive")
+		}
+		cj.historyCap = size
+		return nil
+	}
+}
+
+// EOF
@@ -0,0 +1,249 @@
+// Tideland Go Stew - Time Extensions - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/timex"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestJobOverlap verifies the different overlap policies.
+func TestJobOverlap(t *testing.T) {
+	interval := 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		policy   timex.OverlapPolicy
+		min, max int
+		skipped  bool
+	}{
+		{"allow", timex.OverlapAllow, 8, 10, false},
+		{"skip", timex.OverlapSkip, 2, 3, true},
+		{"queue", timex.OverlapQueue, 2, 3, false},
+		{"replace", timex.OverlapReplace, 8, 10, false},
+	}
+	for _, test := range tests {
+		t.Logf("test: %s", test.name)
+		var mu sync.Mutex
+		started := 0
+		job := func(ctx context.Context) (bool, error) {
+			mu.Lock()
+			started++
+			mu.Unlock()
+			select {
+			case <-time.After(3 * interval):
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+			return true, nil
+		}
+		ct, err := timex.NewCrontab(context.Background(), interval)
+		Assert(t, NoError(err), "no error creating crontab")
+
+		err = ct.AddJob("job", timex.Every(interval), job, timex.WithOverlap(test.policy), timex.WithHistory(100))
+		Assert(t, NoError(err), "job added")
+		time.Sleep(10*interval + interval/2)
+
+		mu.Lock()
+		Assert(t, Range(started, test.min, test.max), "started runs")
+		mu.Unlock()
+		active, err := ct.JobStatus("job")
+		Assert(t, True(active), "job is still active")
+		Assert(t, NoError(err), "no job error")
+
+		skipped := false
+		for _, run := range ct.History("job") {
+			skipped = skipped || run.Skipped
+		}
+		Assert(t, Equal(skipped, test.skipped), "skipped runs recorded")
+
+		ct.Stop()
+	}
+}
+
+// TestJobRunTimeout verifies the timeout of a job run.
+func TestJobRunTimeout(t *testing.T) {
+	interval := 20 * time.Millisecond
+	job := func(ctx context.Context) (bool, error) {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	ct, err := timex.NewCrontab(context.Background(), interval)
+	Assert(t, NoError(err), "no error creating crontab")
+	defer ct.Stop()
+
+	err = ct.AddJob("job", timex.Every(interval), job, timex.WithRunTimeout(interval))
+	Assert(t, NoError(err), "job added")
+	time.Sleep(5 * interval)
+
+	active, err := ct.JobStatus("job")
+	Assert(t, False(active), "job terminated")
+	Assert(t, True(errors.Is(err, context.DeadlineExceeded)), "job timed out")
+
+	run, ok := ct.LastRun("job")
+	Assert(t, True(ok), "run recorded")
+	Assert(t, About(run.Duration, interval, interval/2), "run duration")
+	Assert(t, True(errors.Is(run.Err, context.DeadlineExceeded)), "run error recorded")
+}
+
+// TestJobJitter verifies the jittering start of job runs.
+func TestJobJitter(t *testing.T) {
+	interval := 10 * time.Millisecond
+	jitter := 100 * time.Millisecond
+	ct, err := timex.NewCrontab(context.Background(), interval)
+	Assert(t, NoError(err), "no error creating crontab")
+	defer ct.Stop()
+
+	at := time.Now().Add(2 * interval)
+	ids := []string{"a", "b", "c", "d", "e"}
+	for _, id := range ids {
+		err = ct.AddJob(id, onceSchedule{at}, func(ctx context.Context) (bool, error) {
+			return true, nil
+		}, timex.WithJitter(jitter))
+		Assert(t, NoError(err), "job added")
+	}
+	err = ct.AddJob("invalid", onceSchedule{at}, nil, timex.WithJitter(-jitter))
+	Assert(t, ErrorContains(err, "jitter must not be negative"), "invalid option")
+
+	time.Sleep(3*interval + jitter)
+	maxDelay := time.Duration(0)
+	for _, id := range ids {
+		run, ok := ct.LastRun(id)
+		Assert(t, True(ok), "job %s executed", id)
+		delay := run.Start.Sub(at)
+		Assert(t, Range(delay, 0, jitter+2*interval), "delay of job %s", id)
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+	}
+	Assert(t, True(maxDelay > 2*interval), "starts are jittering")
+}
+
+// TestJobCatchUp verifies catching up missed runs.
+func TestJobCatchUp(t *testing.T) {
+	interval := 50 * time.Millisecond
+	for _, catchUp := range []int{0, 3} {
+		var mu sync.Mutex
+		runs := 0
+		job := func(ctx context.Context) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs++
+			return true, nil
+		}
+		ct, err := timex.NewCrontab(context.Background(), interval)
+		Assert(t, NoError(err), "no error creating crontab")
+
+		// The schedule is much faster than the crontab interval,
+		// so each tick misses runs.
+		err = ct.AddJob("job", timex.Every(interval/10), job, timex.WithCatchUp(catchUp), timex.WithHistory(100))
+		Assert(t, NoError(err), "job added")
+		time.Sleep(4*interval + interval/2)
+		ct.Stop()
+
+		mu.Lock()
+		Assert(t, Range(runs, 3*(catchUp+1), 4*(catchUp+1)), "runs with catch-up %d", catchUp)
+		mu.Unlock()
+		Assert(t, Length(ct.History("job"), runs), "all runs recorded")
+	}
+}
+
+// TestJobCatchUpSequential verifies that missed runs don't overlap.
+func TestJobCatchUpSequential(t *testing.T) {
+	interval := 50 * time.Millisecond
+	var mu sync.Mutex
+	runs := 0
+	active := 0
+	maxActive := 0
+	job := func(ctx context.Context) (bool, error) {
+		mu.Lock()
+		runs++
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return true, nil
+	}
+	ct, err := timex.NewCrontab(context.Background(), interval)
+	Assert(t, NoError(err), "no error creating crontab")
+
+	err = ct.AddJob("job", timex.Every(interval/10), job, timex.WithCatchUp(5))
+	Assert(t, NoError(err), "job added")
+	time.Sleep(2*interval + interval/2)
+	ct.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	Assert(t, Range(runs, 6, 12), "missed runs caught up")
+	Assert(t, Equal(maxActive, 1), "missed runs executed one after another")
+}
+
+// TestJobHistory verifies the bounded history of job runs.
+func TestJobHistory(t *testing.T) {
+	interval := 20 * time.Millisecond
+	counter := 0
+	ct, err := timex.NewCrontab(context.Background(), interval)
+	Assert(t, NoError(err), "no error creating crontab")
+	defer ct.Stop()
+
+	err = ct.AddJob("job", timex.Every(interval), func(ctx context.Context) (bool, error) {
+		counter++
+		if counter == 5 {
+			return false, errors.New("five is enough")
+		}
+		return true, nil
+	}, timex.WithHistory(3), timex.WithOverlap(timex.OverlapQueue))
+	Assert(t, NoError(err), "job added")
+	time.Sleep(10 * interval)
+
+	history := ct.History("job")
+	Assert(t, Length(history, 3), "history is bounded")
+	Assert(t, NoError(history[0].Err), "third run ok")
+	Assert(t, NoError(history[1].Err), "fourth run ok")
+	Assert(t, ErrorContains(history[2].Err, "five is enough"), "fifth run failed")
+	Assert(t, True(history[1].Start.After(history[0].Start)), "runs in order")
+	Assert(t, Empty(ct.History("unknown")), "no history of unknown job")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// onceSchedule schedules a job exactly once.
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next implements timex.Schedule.
+func (os onceSchedule) Next(after time.Time) time.Time {
+	if after.Before(os.at) {
+		return os.at
+	}
+	return time.Time{}
+}
+
+// EOF
@@ -13,10 +13,13 @@ package timex // import "tideland.dev/go/stew/timex"
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"tideland.dev/go/stew/loop"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -34,8 +37,53 @@ const (
 	StatusStopping          = loop.StatusStopping
 	StatusFinalizing        = loop.StatusFinalizing
 	StatusError             = loop.StatusError
+	StatusRestarting        = loop.StatusRestarting
 )
 
+//--------------------
+// OPTIONS
+//--------------------
+
+// Option defines the signature of a crontab option setting function.
+type Option func(c *Crontab) error
+
+// WithStateStore sets a store for the states of the jobs. Jobs added
+// with an ID found in the store continue with their stored plan.
+func WithStateStore(store StateStore) Option {
+	return func(c *Crontab) error {
+		if store == nil {
+			return fmt.Errorf("invalid crontab option: state store is nil")
+		}
+		c.store = store
+		return nil
+	}
+}
+
+// WithMissedRuns sets the policy for runs missed while the process
+// wasn't running. It needs a state store, otherwise NewCrontab
+// returns an error.
+func WithMissedRuns(policy MissedPolicy) Option {
+	return func(c *Crontab) error {
+		if policy < MissedSkip || policy > MissedRunAll {
+			return fmt.Errorf("invalid crontab option: unknown missed runs policy %d", policy)
+		}
+		c.missed = policy
+		return nil
+	}
+}
+
+// WithClock sets the clock of the crontab. By default it's the
+// real clock, a fake one allows deterministic tests.
+func WithClock(clock wait.Clock) Option {
+	return func(c *Crontab) error {
+		if clock == nil {
+			return fmt.Errorf("invalid crontab option: clock is nil")
+		}
+		c.clock = clock
+		return nil
+	}
+}
+
 //--------------------
 // CRONTAB
 //--------------------
@@ -43,46 +91,63 @@ const (
 // Job is executed by the crontab.
 type Job func() (bool, error)
 
-// crontab is the internal type for the cron server.
-type cronjob struct {
-	id        string
-	frequency time.Duration
-	last      time.Time
-	job       Job
-}
+// ContextJob is executed by the crontab with a context. It is canceled
+// when the crontab stops, the run times out, or the run is replaced.
+type ContextJob func(ctx context.Context) (bool, error)
 
 // Crontab is one cron server. A system can run multiple ones
 // in parallel.
 type Crontab struct {
+	mu         sync.RWMutex
 	ctx        context.Context
+	cancel     func()
 	interval   time.Duration
+	clock      wait.Clock
 	jobs       map[string]*cronjob
 	terminated map[string]error
+	histories  map[string][]Run
+	store      StateStore
+	missed     MissedPolicy
+	stateErr   error
 	addCh      chan *cronjob
 	removeCh   chan string
 	loop       *loop.Loop
 }
 
 // NewCrontab creates a cron server.
-func NewCrontab(ctx context.Context, interval time.Duration) (*Crontab, error) {
+func NewCrontab(ctx context.Context, interval time.Duration, options ...Option) (*Crontab, error) {
 	c := &Crontab{
-		ctx:        ctx,
 		interval:   interval,
+		clock:      wait.RealClock(),
 		jobs:       make(map[string]*cronjob),
 		terminated: make(map[string]error),
+		histories:  make(map[string][]Run),
 		addCh:      make(chan *cronjob, 1),
 		removeCh:   make(chan string, 1),
 	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.missed != MissedSkip && c.store == nil {
+		return nil, fmt.Errorf("invalid crontab option: missed runs policy needs a state store")
+	}
+	// Own context for the runs of the jobs, canceled when stopping.
+	c.ctx, c.cancel = context.WithCancel(ctx)
 	l, err := loop.Go(c.worker, loop.WithContext(ctx))
 	if err != nil {
+		c.cancel()
 		return nil, fmt.Errorf("error starting backend worker: %v", err)
 	}
 	c.loop = l
 	return c, nil
 }
 
-// Stop terminates the cron server.
+// Stop terminates the cron server and cancels the contexts
+// of the running jobs.
 func (c *Crontab) Stop() error {
+	c.cancel()
 	c.loop.Stop()
 	return c.loop.Err()
 }
@@ -92,28 +157,74 @@ func (c *Crontab) Status() loop.Status {
 	return c.loop.Status()
 }
 
-// Add adds a new job to the server.
-func (c *Crontab) Add(id string, frequency time.Duration, job Job) {
+// Add adds a new job to the server. It is executed with the given
+// frequency measured from now on. An error is returned if the state
+// of the job cannot be loaded or saved.
+func (c *Crontab) Add(id string, frequency time.Duration, job Job) error {
 	if frequency < c.interval {
 		frequency = c.interval
 	}
+	return c.AddSchedule(id, Every(frequency), job)
+}
+
+// AddCron adds a new job to the server executed based on the cron
+// expression. See ParseCronInLocation for the syntax.
+func (c *Crontab) AddCron(id string, expr string, job Job, options ...JobOption) error {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	return c.AddJob(id, schedule, func(ctx context.Context) (bool, error) {
+		return job()
+	}, options...)
+}
+
+// AddSchedule adds a new job to the server executed based on
+// the schedule. An error is returned if the state of the job
+// cannot be loaded or saved.
+func (c *Crontab) AddSchedule(id string, schedule Schedule, job Job) error {
+	return c.AddJob(id, schedule, func(ctx context.Context) (bool, error) {
+		return job()
+	})
+}
+
+// AddJob adds a new context job to the server executed based on
+// the schedule. The options control its execution.
+func (c *Crontab) AddJob(id string, schedule Schedule, job ContextJob, options ...JobOption) error {
+	now := c.clock.Now()
 	cj := &cronjob{
-		id:        id,
-		frequency: frequency,
-		last:      time.Now(),
-		job:       job,
+		id:         id,
+		schedule:   schedule,
+		last:       now,
+		next:       schedule.Next(now),
+		job:        job,
+		historyCap: defaultHistoryCap,
+	}
+	for _, option := range options {
+		if err := option(cj); err != nil {
+			return fmt.Errorf("invalid option for job %q: %v", id, err)
+		}
+	}
+	if err := c.recoverJob(cj, now); err != nil {
+		return err
 	}
 	c.addCh <- cj
+	return nil
 }
 
 // Remove removes a job from the server.
 func (c *Crontab) Remove(id string) {
-	c.removeCh <- id
+	select {
+	case c.removeCh <- id:
+	case <-c.ctx.Done():
+	}
 }
 
 // JobStatus returns if a job is still active or if it possibly
 // terminated with an error.
 func (c *Crontab) JobStatus(id string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	_, ok := c.jobs[id]
 	if ok {
 		// Still active.
@@ -128,42 +239,259 @@ func (c *Crontab) JobStatus(id string) (bool, error) {
 	return false, nil
 }
 
+// NextRun returns the next planned execution time of a job. The
+// bool is false if the job is unknown or has no further execution.
+func (c *Crontab) NextRun(id string) (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cj, ok := c.jobs[id]
+	if !ok || cj.next.IsZero() {
+		return time.Time{}, false
+	}
+	return cj.next, true
+}
+
+// History returns the recorded runs of a job, the oldest first. It
+// is also available after the job terminated.
+func (c *Crontab) History(id string) []Run {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	history := make([]Run, len(c.histories[id]))
+	copy(history, c.histories[id])
+	return history
+}
+
+// LastRun returns the last recorded run of a job.
+func (c *Crontab) LastRun(id string) (Run, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	history := c.histories[id]
+	if len(history) == 0 {
+		return Run{}, false
+	}
+	return history[len(history)-1], true
+}
+
+// StateErr returns the last error of the state store while
+// saving or deleting job states.
+func (c *Crontab) StateErr() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stateErr
+}
+
+// recoverJob reads the state of the job from the store and plans
+// the next run following the policy for missed runs.
+func (c *Crontab) recoverJob(cj *cronjob, now time.Time) error {
+	if c.store == nil {
+		return nil
+	}
+	state, ok, err := c.store.Load(cj.id)
+	if err != nil {
+		return fmt.Errorf("cannot load state of job %q: %v", cj.id, err)
+	}
+	if ok {
+		planned := state.Next
+		if planned.IsZero() {
+			planned = cj.schedule.Next(state.Last)
+		}
+		cj.last = state.Last
+		switch {
+		case planned.IsZero():
+			cj.next = planned
+		case !planned.Before(now):
+			cj.next = planned
+		case c.missed == MissedRunOnce:
+			cj.next = planned
+			cj.recovering = true
+			cj.recoverLimit = 0
+		case c.missed == MissedRunAll:
+			cj.next = planned
+			cj.recovering = true
+			cj.recoverLimit = maxMissedRuns
+		default:
+			cj.next = cj.schedule.Next(now)
+		}
+	}
+	if err := c.store.Save(cj.id, JobState{Last: cj.last, Next: cj.next}); err != nil {
+		return fmt.Errorf("cannot save state of job %q: %v", cj.id, err)
+	}
+	return nil
+}
+
+// saveStates saves the states of the given jobs in the store.
+func (c *Crontab) saveStates(states map[string]JobState) {
+	for id, state := range states {
+		if err := c.store.Save(id, state); err != nil {
+			c.mu.Lock()
+			c.stateErr = fmt.Errorf("cannot save state of job %q: %v", id, err)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// deleteState deletes the state of the job in the store.
+func (c *Crontab) deleteState(id string) {
+	if err := c.store.Delete(id); err != nil {
+		c.mu.Lock()
+		c.stateErr = fmt.Errorf("cannot delete state of job %q: %v", id, err)
+		c.mu.Unlock()
+	}
+}
+
 // worker runs the server backend.
 func (c *Crontab) worker(ctx context.Context) error {
-	ticker := time.NewTicker(c.interval)
+	ticker := c.clock.NewTicker(c.interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case addJob := <-c.addCh:
+			c.mu.Lock()
 			c.jobs[addJob.id] = addJob
+			c.mu.Unlock()
 		case id := <-c.removeCh:
+			c.mu.Lock()
 			delete(c.jobs, id)
-		case now := <-ticker.C:
+			c.mu.Unlock()
+			if c.store != nil {
+				c.deleteState(id)
+			}
+		case now := <-ticker.C():
+			states := make(map[string]JobState)
+			c.mu.Lock()
 			for id, job := range c.jobs {
-				c.handleJob(id, job, now)
+				if c.handleJob(job, now) {
+					states[id] = JobState{Last: job.last, Next: job.next}
+				}
+			}
+			c.mu.Unlock()
+			if c.store != nil {
+				c.saveStates(states)
 			}
 		}
 	}
 }
 
-// handleJob checks if a job shall be executed and starts it as goroutine
-// if yes.
-func (c *Crontab) handleJob(id string, cj *cronjob, now time.Time) {
-	if cj.last.Add(cj.frequency).Before(now) {
-		cj.last = now
-		go func() {
-			cont, err := cj.job()
-			if err != nil {
-				c.terminated[id] = err
-				cont = false
-			}
-			if !cont {
-				c.Remove(id)
+// handleJob checks if a job shall be executed and triggers it
+// if yes. Missed runs are caught up sequentially if configured. The caller
+// must hold the lock. It returns true if the job has been triggered.
+func (c *Crontab) handleJob(cj *cronjob, now time.Time) bool {
+	if cj.next.IsZero() || now.Before(cj.next) {
+		return false
+	}
+	catchUp := cj.catchUp
+	if cj.recovering {
+		catchUp = cj.recoverLimit
+		cj.recovering = false
+	}
+	// Plan the next run based on the current planned one to
+	// avoid drifting. Count missed runs on the way.
+	due := 1
+	prev := cj.next
+	next := cj.schedule.Next(prev)
+	for !next.IsZero() && !now.Before(next) {
+		if !next.After(prev) {
+			next = cj.schedule.Next(now)
+			break
+		}
+		if due <= catchUp {
+			due++
+		}
+		prev = next
+		next = cj.schedule.Next(next)
+	}
+	cj.last = now
+	cj.next = next
+	c.trigger(cj)
+	// Missed runs follow one after another, independent of
+	// the overlap policy.
+	cj.pending += due - 1
+	return true
+}
+
+// trigger starts a run of the job depending on its overlap policy.
+// The caller must hold the lock.
+func (c *Crontab) trigger(cj *cronjob) {
+	if cj.running > 0 {
+		switch cj.overlap {
+		case OverlapSkip:
+			c.record(cj, Run{Start: c.clock.Now(), Skipped: true})
+			return
+		case OverlapQueue:
+			cj.pending++
+			return
+		case OverlapReplace:
+			cj.cancel()
+		}
+	}
+	c.start(cj)
+}
+
+// start runs the job in a goroutine. The caller must hold the lock.
+func (c *Crontab) start(cj *cronjob) {
+	var ctx context.Context
+	var cancel func()
+	if cj.timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.ctx, cj.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.ctx)
+	}
+	replaced := false
+	cj.running++
+	cj.cancel = func() {
+		replaced = true
+		cancel()
+	}
+	jitter := cj.jitterDelay()
+	go func() {
+		defer cancel()
+		// Wait for the jitter.
+		if jitter > 0 {
+			select {
+			case <-c.clock.After(jitter):
+			case <-ctx.Done():
 			}
-		}()
+		}
+		start := c.clock.Now()
+		cont, err := cj.job(ctx)
+		run := Run{
+			Start:    start,
+			Duration: c.clock.Since(start),
+			Err:      err,
+		}
+		c.mu.Lock()
+		c.record(cj, run)
+		cj.running--
+		if replaced && errors.Is(err, context.Canceled) {
+			// A replaced run is no failure.
+			err = nil
+			cont = true
+		}
+		if err != nil {
+			c.terminated[cj.id] = err
+			cont = false
+		}
+		if cont && cj.pending > 0 && cj.running == 0 {
+			cj.pending--
+			c.start(cj)
+		}
+		c.mu.Unlock()
+		if !cont {
+			c.Remove(cj.id)
+		}
+	}()
+}
+
+// record adds a run to the history of the job. The caller
+// must hold the lock.
+func (c *Crontab) record(cj *cronjob, run Run) {
+	history := append(c.histories[cj.id], run)
+	if len(history) > cj.historyCap {
+		history = history[len(history)-cj.historyCap:]
 	}
+	c.histories[cj.id] = history
 }
 
 // EOF
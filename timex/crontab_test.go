@@ -20,6 +20,7 @@ import (
 	. "tideland.dev/go/stew/qaone"
 
 	"tideland.dev/go/stew/timex"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -46,6 +47,74 @@ func TestCrontabEndlessJob(t *testing.T) {
 	Assert(t, Range(counter, 3, 6), "job executed at three to six times")
 }
 
+// TestCrontabFakeClock verifies the execution of a crontab job
+// driven by a fake clock.
+func TestCrontabFakeClock(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := wait.NewFakeClock(start)
+	runs := make(chan time.Time)
+	job := func() (bool, error) {
+		runs <- clock.Now()
+		return true, nil
+	}
+	ctx := context.Background()
+	ct, err := timex.NewCrontab(ctx, time.Minute, timex.WithClock(clock))
+	Assert(t, NoError(err), "no error creating crontab")
+	defer ct.Stop()
+
+	ct.Add("job", time.Minute, job)
+	for {
+		if _, ok := ct.NextRun("job"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Minute)
+		Assert(t, Equal(<-runs, start.Add(time.Duration(i)*time.Minute)), "job executed in fake time")
+	}
+	next, ok := ct.NextRun("job")
+	Assert(t, True(ok), "job has a next run")
+	Assert(t, Equal(next, start.Add(4*time.Minute)), "next run in fake time")
+}
+
+// TestCrontabStopCancelsJob verifies that stopping the crontab
+// cancels the context of a running job.
+func TestCrontabStopCancelsJob(t *testing.T) {
+	clock := wait.NewFakeClock(time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC))
+	started := make(chan struct{})
+	canceled := make(chan error, 1)
+	job := func(ctx context.Context) (bool, error) {
+		close(started)
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return false, ctx.Err()
+	}
+	ct, err := timex.NewCrontab(context.Background(), time.Minute, timex.WithClock(clock))
+	Assert(t, NoError(err), "no error creating crontab")
+
+	err = ct.AddJob("blocking", timex.Every(time.Minute), job)
+	Assert(t, NoError(err), "job added")
+	for {
+		if _, ok := ct.NextRun("blocking"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-started
+
+	ct.Stop()
+	select {
+	case err := <-canceled:
+		Assert(t, ErrorContains(err, "context canceled"), "job context canceled")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job context not canceled after stop")
+	}
+}
+
 // TestCrontabStoppingJob verifies the stopping of a crontab job internally.
 func TestCrontabStoppingJob(t *testing.T) {
 	interval := 100 * time.Millisecond
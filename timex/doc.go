@@ -9,6 +9,29 @@
 // tests it contains a crontab for chronological jobs and a retry
 // function to let code blocks be retried under well defined conditions
 // regarding time and count.
+//
+// Jobs of the crontab are executed based on a Schedule. Beside fixed
+// frequencies it supports cron expressions with five or six fields,
+// descriptors like @daily or @hourly, and time zones. NextRun returns
+// the next planned execution time of a job.
+//
+// Jobs added with AddJob get a context and can be configured with options
+// for the handling of overlapping runs, run timeouts, start jitter, and
+// the catch-up of missed runs. A bounded history of the runs of each job
+// is returned by History.
+//
+// With a StateStore, e.g. the FileStateStore, the crontab persists the
+// last and next run of each job. When a job is added again after a restart
+// its stored plan is continued, runs missed in between are handled based
+// on the MissedPolicy.
+//
+// RetryContext and RetryValue retry functions with a context based on a
+// RetryPolicy. It defines the Backoff, e.g. exponential or with decorrelated
+// jitter, a Classifier for retryable errors, and a hook called before each
+// retry.
+//
+// Crontab and retries accept a wait.Clock, e.g. a wait.FakeClock for
+// deterministic tests.
 package timex // import "tideland.dev/go/stew/timex"
 
 // EOF
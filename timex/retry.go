@@ -12,8 +12,14 @@ package timex // import "tideland.dev/go/stew/timex"
 //--------------------
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"time"
+
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -22,12 +28,14 @@ import (
 
 // RetryStrategy describes how often the function in Retry is executed, the
 // initial break between those retries, how much this time is incremented
-// for each retry, and the maximum timeout.
+// for each retry, and the maximum timeout. An optional Clock replaces the
+// real one, e.g. for tests.
 type RetryStrategy struct {
 	Count          int
 	Break          time.Duration
 	BreakIncrement time.Duration
 	Timeout        time.Duration
+	Clock          wait.Clock
 }
 
 // ShortAttempt returns a predefined short retry strategy.
@@ -63,7 +71,11 @@ func LongAttempt() RetryStrategy {
 // Retry executes the passed function until it returns true or an error.
 // These retries are restricted by the retry strategy.
 func Retry(f func() (bool, error), rs RetryStrategy) error {
-	timeout := time.Now().Add(rs.Timeout)
+	clock := rs.Clock
+	if clock == nil {
+		clock = wait.RealClock()
+	}
+	timeout := clock.Now().Add(rs.Timeout)
 	sleep := rs.Break
 	for i := 0; i < rs.Count; i++ {
 		done, err := f()
@@ -73,13 +85,228 @@ func Retry(f func() (bool, error), rs RetryStrategy) error {
 		if done {
 			return nil
 		}
-		if time.Now().After(timeout) {
+		if clock.Now().After(timeout) {
 			return fmt.Errorf("retried longer than %v", rs.Timeout)
 		}
-		time.Sleep(sleep)
+		clock.Sleep(sleep)
 		sleep += rs.BreakIncrement
 	}
-	return fmt.Errorf("retried more than %d times", rs.Count)
+	return fmt.Errorf("retry failed after %d attempts", rs.Count)
+}
+
+//--------------------
+// BACKOFF
+//--------------------
+
+// Backoff returns the delay before the given retry attempt, starting
+// with 1. The previous delay is zero for the first attempt.
+type Backoff func(attempt int, previous time.Duration) time.Duration
+
+// ConstantBackoff always returns the same delay.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(_ int, _ time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// LinearBackoff starts with the initial delay and increments it for
+// each attempt like the RetryStrategy.
+func LinearBackoff(initial, increment time.Duration) Backoff {
+	return func(attempt int, _ time.Duration) time.Duration {
+		return initial + time.Duration(attempt-1)*increment
+	}
+}
+
+// ExponentialBackoff starts with the initial delay and multiplies it
+// with the factor for each attempt. The delay is limited to max.
+func ExponentialBackoff(initial, max time.Duration, factor float64) Backoff {
+	if factor < 1.0 {
+		factor = 2.0
+	}
+	return func(attempt int, _ time.Duration) time.Duration {
+		delay := float64(initial) * math.Pow(factor, float64(attempt-1))
+		if delay > float64(max) {
+			return max
+		}
+		return time.Duration(delay)
+	}
+}
+
+// FullJitterBackoff returns a random delay between zero and the
+// exponentially growing delay, limited to max.
+func FullJitterBackoff(initial, max time.Duration) Backoff {
+	exp := ExponentialBackoff(initial, max, 2.0)
+	return func(attempt int, previous time.Duration) time.Duration {
+		limit := exp(attempt, previous)
+		if limit <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(limit) + 1))
+	}
+}
+
+// DecorrelatedJitterBackoff returns a random delay between base and
+// three times the previous delay, limited to max.
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return func(_ int, previous time.Duration) time.Duration {
+		if previous < base {
+			previous = base
+		}
+		upper := 3 * previous
+		delay := base
+		if upper > base {
+			delay += time.Duration(rand.Int63n(int64(upper - base)))
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+//--------------------
+// ERROR CLASSIFICATION
+//--------------------
+
+// permanentError marks an error as not retryable.
+type permanentError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (pe *permanentError) Error() string {
+	return pe.err.Error()
+}
+
+// Unwrap returns the marked error.
+func (pe *permanentError) Unwrap() error {
+	return pe.err
+}
+
+// Permanent marks an error as not retryable for the default classifier.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent checks if an error has been marked as not retryable.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// Classifier decides if an error returned by a retried function
+// is retryable.
+type Classifier func(err error) bool
+
+// DefaultClassifier treats all errors as retryable except those marked
+// as permanent and context cancellations or timeouts.
+func DefaultClassifier(err error) bool {
+	switch {
+	case IsPermanent(err):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	default:
+		return true
+	}
+}
+
+//--------------------
+// RETRY POLICY
+//--------------------
+
+// RetryPolicy describes how often a function in RetryContext or RetryValue
+// is executed, the backoff between the attempts, the maximum timeout, which
+// errors are retryable, and a hook called before each retry, e.g. for logging
+// or metrics. A count or timeout of zero means no limit. An optional Clock
+// replaces the real one, e.g. for tests. In this case the timeout is checked
+// between the attempts instead of canceling the context.
+type RetryPolicy struct {
+	Count    int
+	Timeout  time.Duration
+	Backoff  Backoff
+	Classify Classifier
+	OnRetry  func(attempt int, err error, delay time.Duration)
+	Clock    wait.Clock
+}
+
+// Policy converts the retry strategy into a retry policy.
+func (rs RetryStrategy) Policy() RetryPolicy {
+	return RetryPolicy{
+		Count:   rs.Count,
+		Timeout: rs.Timeout,
+		Backoff: LinearBackoff(rs.Break, rs.BreakIncrement),
+		Clock:   rs.Clock,
+	}
+}
+
+// RetryContext executes the passed function until it returns no error, a
+// not retryable error, or the context is done. These retries are restricted
+// by the retry policy.
+func RetryContext(ctx context.Context, f func(ctx context.Context) error, rp RetryPolicy) error {
+	_, err := RetryValue(ctx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	}, rp)
+	return err
+}
+
+// RetryValue executes the passed function until it returns a value without
+// an error, a not retryable error, or the context is done. These retries are
+// restricted by the retry policy.
+func RetryValue[T any](ctx context.Context, f func(ctx context.Context) (T, error), rp RetryPolicy) (T, error) {
+	var zero T
+	if rp.Backoff == nil {
+		rp.Backoff = ConstantBackoff(0)
+	}
+	if rp.Classify == nil {
+		rp.Classify = DefaultClassifier
+	}
+	parent := ctx
+	clock := rp.Clock
+	if clock == nil {
+		clock = wait.RealClock()
+		if rp.Timeout > 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(ctx, rp.Timeout)
+			defer cancel()
+		}
+	}
+	deadline := clock.Now().Add(rp.Timeout)
+	delay := time.Duration(0)
+	for attempt := 1; ; attempt++ {
+		value, err := f(ctx)
+		if err == nil {
+			return value, nil
+		}
+		if !rp.Classify(err) {
+			return zero, err
+		}
+		if rp.Count > 0 && attempt >= rp.Count {
+			return zero, fmt.Errorf("retry failed after %d attempts: %w", rp.Count, err)
+		}
+		if rp.Timeout > 0 && !clock.Now().Before(deadline) {
+			return zero, fmt.Errorf("retried longer than %v: %w", rp.Timeout, err)
+		}
+		delay = rp.Backoff(attempt, delay)
+		if rp.OnRetry != nil {
+			rp.OnRetry(attempt, err, delay)
+		}
+		timer := clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			// The parent context is done too if its deadline is
+			// earlier than the timeout of the policy.
+			if perr := parent.Err(); perr != nil {
+				return zero, fmt.Errorf("retry stopped: %w (last error: %v)", perr, err)
+			}
+			return zero, fmt.Errorf("retried longer than %v: %w", rp.Timeout, err)
+		}
+	}
 }
 
 // EOF
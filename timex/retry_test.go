@@ -12,6 +12,7 @@ package timex_test
 //--------------------
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -19,6 +20,7 @@ import (
 	. "tideland.dev/go/stew/qaone"
 
 	"tideland.dev/go/stew/timex"
+	"tideland.dev/go/stew/wait"
 )
 
 //--------------------
@@ -69,7 +71,189 @@ func TestRetryTooOften(t *testing.T) {
 	err := timex.Retry(func() (bool, error) {
 		return false, nil
 	}, rs)
-	Assert(t, ErrorContains(err, "retried more than"), "error matches")
+	Assert(t, ErrorContains(err, "retry failed after 5 attempts"), "error matches")
+}
+
+// TestRetryFakeClock verifies retrying with a fake clock.
+func TestRetryFakeClock(t *testing.T) {
+	clock := wait.NewFakeClock(time.Now())
+	rs := timex.RetryStrategy{
+		Count:   100,
+		Break:   10 * time.Minute,
+		Timeout: time.Hour,
+		Clock:   clock,
+	}
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- timex.Retry(func() (bool, error) {
+			calls++
+			return false, nil
+		}, rs)
+	}()
+	err := advanceUntilDone(clock, 10*time.Minute, done)
+	Assert(t, ErrorContains(err, "retried longer than"), "error matches")
+	Assert(t, Equal(calls, 8), "retried until timeout of fake clock")
+
+	// Same with a policy.
+	rp := timex.RetryPolicy{
+		Timeout: time.Hour,
+		Backoff: timex.ConstantBackoff(10 * time.Minute),
+		Clock:   clock,
+	}
+	calls = 0
+	go func() {
+		done <- timex.RetryContext(context.Background(), func(ctx context.Context) error {
+			calls++
+			return errors.New("ouch")
+		}, rp)
+	}()
+	err = advanceUntilDone(clock, 10*time.Minute, done)
+	Assert(t, ErrorContains(err, "retried longer than"), "error matches")
+	Assert(t, Equal(calls, 7), "retried until timeout of fake clock")
+}
+
+// TestRetryValue verifies retrying a function returning a value.
+func TestRetryValue(t *testing.T) {
+	count := 0
+	retries := []int{}
+	rp := timex.RetryPolicy{
+		Count:   10,
+		Backoff: timex.ConstantBackoff(time.Millisecond),
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			retries = append(retries, attempt)
+		},
+	}
+	value, err := timex.RetryValue(context.Background(), func(ctx context.Context) (string, error) {
+		count++
+		if count < 3 {
+			return "", errors.New("not yet")
+		}
+		return "done", nil
+	}, rp)
+	Assert(t, NoError(err), "no error")
+	Assert(t, Equal(value, "done"), "value returned")
+	Assert(t, DeepEqual(retries, []int{1, 2}), "retry hook called")
+}
+
+// TestRetryClassifier verifies stopping at not retryable errors.
+func TestRetryClassifier(t *testing.T) {
+	count := 0
+	err := timex.RetryContext(context.Background(), func(ctx context.Context) error {
+		count++
+		if count == 3 {
+			return timex.Permanent(errors.New("broken"))
+		}
+		return errors.New("temporary")
+	}, timex.RetryPolicy{Count: 10})
+	Assert(t, ErrorContains(err, "broken"), "permanent error returned")
+	Assert(t, True(timex.IsPermanent(err)), "error is permanent")
+	Assert(t, Equal(count, 3), "stopped at permanent error")
+
+	count = 0
+	errNotFound := errors.New("not found")
+	err = timex.RetryContext(context.Background(), func(ctx context.Context) error {
+		count++
+		if count == 2 {
+			return errNotFound
+		}
+		return errors.New("temporary")
+	}, timex.RetryPolicy{
+		Count: 10,
+		Classify: func(err error) bool {
+			return !errors.Is(err, errNotFound)
+		},
+	})
+	Assert(t, True(errors.Is(err, errNotFound)), "classified error returned")
+	Assert(t, Equal(count, 2), "stopped at classified error")
+}
+
+// TestRetryLimits verifies the count, timeout, and context limits.
+func TestRetryLimits(t *testing.T) {
+	failing := func(ctx context.Context) error {
+		return errors.New("ouch")
+	}
+	err := timex.RetryContext(context.Background(), failing, timex.RetryPolicy{Count: 5})
+	Assert(t, ErrorContains(err, "retry failed after 5 attempts: ouch"), "count limit")
+
+	err = timex.RetryContext(context.Background(), failing, timex.RetryPolicy{
+		Timeout: 50 * time.Millisecond,
+		Backoff: timex.ConstantBackoff(10 * time.Millisecond),
+	})
+	Assert(t, ErrorContains(err, "retried longer than 50ms: ouch"), "timeout limit")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err = timex.RetryContext(ctx, failing, timex.RetryPolicy{
+		Backoff: timex.ConstantBackoff(5 * time.Millisecond),
+	})
+	Assert(t, True(errors.Is(err, context.Canceled)), "context canceled")
+	Assert(t, ErrorContains(err, "last error: ouch"), "last error included")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = timex.RetryContext(ctx, failing, timex.RetryPolicy{
+		Timeout: time.Second,
+		Backoff: timex.ConstantBackoff(5 * time.Millisecond),
+	})
+	Assert(t, True(errors.Is(err, context.DeadlineExceeded)), "parent deadline exceeded")
+	Assert(t, ErrorContains(err, "retry stopped"), "parent deadline is no timeout of the policy")
+
+	err = timex.RetryContext(context.Background(), failing, timex.ShortAttempt().Policy())
+	Assert(t, ErrorContains(err, "retry failed after 10 attempts"), "converted strategy")
+}
+
+// TestBackoffs verifies the different backoff strategies.
+func TestBackoffs(t *testing.T) {
+	ms := time.Millisecond
+
+	linear := timex.LinearBackoff(10*ms, 5*ms)
+	Assert(t, Equal(linear(1, 0), 10*ms), "linear first")
+	Assert(t, Equal(linear(3, 15*ms), 20*ms), "linear third")
+
+	exp := timex.ExponentialBackoff(10*ms, 100*ms, 2.0)
+	Assert(t, Equal(exp(1, 0), 10*ms), "exponential first")
+	Assert(t, Equal(exp(3, 0), 40*ms), "exponential third")
+	Assert(t, Equal(exp(10, 0), 100*ms), "exponential limited")
+
+	full := timex.FullJitterBackoff(10*ms, 100*ms)
+	decorrelated := timex.DecorrelatedJitterBackoff(10*ms, 100*ms)
+	previous := time.Duration(0)
+	for attempt := 1; attempt <= 20; attempt++ {
+		Assert(t, Range(full(attempt, 0), 0, exp(attempt, 0)), "full jitter in range")
+		delay := decorrelated(attempt, previous)
+		upper := 3 * previous
+		if upper < 30*ms {
+			upper = 30 * ms
+		}
+		if upper > 100*ms {
+			upper = 100 * ms
+		}
+		Assert(t, Range(delay, 10*ms, upper), "decorrelated jitter in range")
+		previous = delay
+	}
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// advanceUntilDone advances the fake clock by the step each time
+// somebody waits for it until done receives the result.
+func advanceUntilDone(clock *wait.FakeClock, step time.Duration, done <-chan error) error {
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Millisecond):
+			if clock.Waiters() > 0 {
+				clock.Advance(step)
+			}
+		}
+	}
 }
 
 // EOF
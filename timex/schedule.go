@@ -0,0 +1,303 @@
+// Tideland Go Stew - Time Extensions
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex // import "tideland.dev/go/stew/timex"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------
+// SCHEDULE
+//--------------------
+
+// Schedule describes when a job of the Crontab has to be executed.
+type Schedule interface {
+	// Next returns the next time after the given one. A zero
+	// time means that there's no further execution.
+	Next(after time.Time) time.Time
+}
+
+// every is a schedule with a fixed frequency.
+type every struct {
+	frequency time.Duration
+}
+
+// Every returns a Schedule with a fixed frequency.
+func Every(frequency time.Duration) Schedule {
+	return every{
+		frequency: frequency,
+	}
+}
+
+// Next implements Schedule.
+func (e every) Next(after time.Time) time.Time {
+	return after.Add(e.frequency)
+}
+
+//--------------------
+// CRON SCHEDULE
+//--------------------
+
+// cronDescriptors maps the supported descriptors to their expressions.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// monthNames maps the names of months to their values.
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// weekdayNames maps the names of weekdays to their values.
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// cronSchedule is a schedule based on a cron expression.
+type cronSchedule struct {
+	loc      *time.Location
+	seconds  []int
+	minutes  []int
+	hours    []int
+	days     []int
+	months   []time.Month
+	weekdays []time.Weekday
+	dayStar  bool
+	wdayStar bool
+}
+
+// ParseCron parses a cron expression in the local time zone. See
+// ParseCronInLocation for details.
+func ParseCron(expr string) (Schedule, error) {
+	return ParseCronInLocation(expr, time.Local)
+}
+
+// ParseCronInLocation parses a cron expression for the given time zone.
+// Expressions have five fields (minute, hour, day of month, month, day of
+// week) or six fields with an additional leading second. Fields may contain
+// "*", "?", values, ranges "a-b", lists "a,b", and steps "*/n" or "a-b/n".
+// Months and weekdays may be named like "jan" or "mon", Sunday is 0 or 7.
+// Additionally the descriptors @yearly, @annually, @monthly, @weekly, @daily,
+// @midnight, @hourly, and "@every <duration>" are supported. A prefix like
+// "CRON_TZ=Europe/Berlin" overrides the time zone.
+//
+// Wall clock times skipped by a daylight saving time change are not
+// scheduled, repeated wall clock times are scheduled only once.
+func ParseCronInLocation(expr string, loc *time.Location) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	// Check for time zone.
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		tz, rest, _ := strings.Cut(expr, " ")
+		_, name, _ := strings.Cut(tz, "=")
+		zloc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron time zone %q: %v", name, err)
+		}
+		loc = zloc
+		expr = strings.TrimSpace(rest)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	// Check for descriptors.
+	if strings.HasPrefix(expr, "@every ") {
+		frequency, err := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron frequency: %v", err)
+		}
+		if frequency < time.Second {
+			return nil, fmt.Errorf("invalid cron frequency: %v is less than a second", frequency)
+		}
+		return Every(frequency), nil
+	}
+	if strings.HasPrefix(expr, "@") {
+		dexpr, ok := cronDescriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("invalid cron descriptor %q", expr)
+		}
+		expr = dexpr
+	}
+	// Parse fields.
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: need 5 or 6 fields", expr)
+	}
+	cs := &cronSchedule{
+		loc:      loc,
+		dayStar:  isStar(fields[3]),
+		wdayStar: isStar(fields[5]),
+	}
+	var err error
+	if cs.seconds, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron seconds: %v", err)
+	}
+	if cs.minutes, err = parseCronField(fields[1], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron minutes: %v", err)
+	}
+	if cs.hours, err = parseCronField(fields[2], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron hours: %v", err)
+	}
+	if cs.days, err = parseCronField(fields[3], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron days of month: %v", err)
+	}
+	months, err := parseCronField(fields[4], 1, 12, monthNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron months: %v", err)
+	}
+	for _, month := range months {
+		cs.months = append(cs.months, time.Month(month))
+	}
+	weekdays, err := parseCronField(fields[5], 0, 7, weekdayNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron days of week: %v", err)
+	}
+	for _, weekday := range weekdays {
+		cs.weekdays = append(cs.weekdays, time.Weekday(weekday%7))
+	}
+	return cs, nil
+}
+
+// Next implements Schedule.
+func (cs *cronSchedule) Next(after time.Time) time.Time {
+	after = after.In(cs.loc)
+	afterWall := wallClock(after)
+	// Start with the next full second.
+	t := after.Add(time.Second - time.Duration(after.Nanosecond()))
+	limit := t.Year() + 5
+	for t.Year() <= limit {
+		switch {
+		case !MonthInList(t, cs.months):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, cs.loc)
+		case !cs.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, cs.loc)
+		case !HourInList(t, cs.hours):
+			t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+		case !MinuteInList(t, cs.minutes):
+			t = t.Add(time.Minute - time.Duration(t.Second())*time.Second)
+		case !SecondInList(t, cs.seconds):
+			t = t.Add(time.Second)
+		case wallClock(t) <= afterWall:
+			// Repeated wall clock time after a daylight
+			// saving time change.
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks day of month and day of week. Like in the
+// classic cron either one has to match if both are restricted.
+func (cs *cronSchedule) dayMatches(t time.Time) bool {
+	dayOK := DayInList(t, cs.days)
+	wdayOK := WeekdayInList(t, cs.weekdays)
+	if cs.dayStar || cs.wdayStar {
+		return dayOK && wdayOK
+	}
+	return dayOK || wdayOK
+}
+
+// wallClock returns the wall clock of a time as comparable number.
+func wallClock(t time.Time) int64 {
+	return ((((int64(t.Year())*100+int64(t.Month()))*100+
+		int64(t.Day()))*100+int64(t.Hour()))*100+
+		int64(t.Minute()))*100 + int64(t.Second())
+}
+
+// isStar checks if a field contains no restriction.
+func isStar(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseCronField parses one field of a cron expression into
+// a sorted list of values.
+func parseCronField(field string, min, max int, names map[string]int) ([]int, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepStr)
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = s
+		}
+		lo, hi := min, max
+		switch {
+		case isStar(rng):
+		case strings.Contains(rng, "-"):
+			loStr, hiStr, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseCronValue(loStr, min, max, names); err != nil {
+				return nil, err
+			}
+			if hi, err = parseCronValue(hiStr, min, max, names); err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				return nil, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := parseCronValue(rng, min, max, names)
+			if err != nil {
+				return nil, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	values := []int{}
+	for v := min; v <= max; v++ {
+		if set[v] {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// parseCronValue parses a single value or name of a cron field.
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// EOF
@@ -0,0 +1,160 @@
+// Tideland Go Stew - Time Extensions - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/timex"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCronNext verifies the calculation of the next execution
+// time of cron expressions.
+func TestCronNext(t *testing.T) {
+	after := time.Date(2023, time.August, 30, 14, 17, 30, 500, time.UTC)
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2023, time.August, 30, 14, 18, 0, 0, time.UTC)},
+		{"* * * * * *", time.Date(2023, time.August, 30, 14, 17, 31, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.August, 30, 14, 30, 0, 0, time.UTC)},
+		{"5,10 14-16 * * *", time.Date(2023, time.August, 30, 15, 5, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2023, time.August, 31, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * sat", time.Date(2023, time.September, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2023, time.September, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2023, time.August, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 sep-nov *", time.Date(2023, time.October, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * fri", time.Date(2023, time.September, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 0 12 * * ?", time.Date(2023, time.August, 31, 12, 0, 30, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.August, 30, 15, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, time.August, 31, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2023, time.September, 3, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2023, time.September, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", after.Add(90 * time.Minute)},
+		{"CRON_TZ=Europe/Berlin 0 18 * * *", time.Date(2023, time.August, 30, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := timex.ParseCronInLocation(test.expr, time.UTC)
+		Assert(t, NoError(err), "expression %q parsed", test.expr)
+		next := schedule.Next(after)
+		Assert(t, True(next.Equal(test.next)), "next of %q is %v, got %v", test.expr, test.next, next)
+	}
+}
+
+// TestCronErrors verifies the parsing of invalid cron expressions.
+func TestCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", "need 5 or 6 fields"},
+		{"60 * * * *", "invalid cron minutes: value 60 out of range"},
+		{"* 24 * * *", "invalid cron hours"},
+		{"* * 0 * *", "invalid cron days of month"},
+		{"* * * foo *", "invalid cron months: invalid value"},
+		{"* * * * 1-x", "invalid cron days of week"},
+		{"*/0 * * * *", "invalid step"},
+		{"5-1 * * * *", "invalid range"},
+		{"@sometimes", "invalid cron descriptor"},
+		{"@every 10ms", "less than a second"},
+		{"CRON_TZ=Nowhere/City * * * * *", "invalid cron time zone"},
+	}
+	for _, test := range tests {
+		_, err := timex.ParseCron(test.expr)
+		Assert(t, ErrorContains(err, test.err), "expression %q", test.expr)
+	}
+}
+
+// TestCronDaylightSaving verifies the calculation of the next execution
+// time during daylight saving time changes.
+func TestCronDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	Assert(t, NoError(err), "location loaded")
+
+	// Spring forward: 02:30 doesn't exist on March 26, 2023.
+	schedule, err := timex.ParseCronInLocation("30 2 * * *", loc)
+	Assert(t, NoError(err), "expression parsed")
+	after := time.Date(2023, time.March, 25, 12, 0, 0, 0, loc)
+	next := schedule.Next(after)
+	Assert(t, Equal(next, time.Date(2023, time.March, 25, 2, 30, 0, 0, loc).AddDate(0, 0, 2)), "skipped time not scheduled")
+
+	// Hourly jobs run every real hour.
+	schedule, err = timex.ParseCronInLocation("0 * * * *", loc)
+	Assert(t, NoError(err), "expression parsed")
+	after = time.Date(2023, time.March, 26, 1, 30, 0, 0, loc)
+	next = schedule.Next(after)
+	Assert(t, Equal(next.Sub(after), 30*time.Minute), "next hour after spring forward")
+
+	// Fall back: 02:30 exists twice on October 29, 2023.
+	schedule, err = timex.ParseCronInLocation("30 2 * * *", loc)
+	Assert(t, NoError(err), "expression parsed")
+	after = time.Date(2023, time.October, 29, 1, 0, 0, 0, loc)
+	first := schedule.Next(after)
+	Assert(t, Equal(first.Sub(after), 90*time.Minute), "first 02:30 scheduled")
+	second := schedule.Next(first)
+	Assert(t, Equal(second.Day(), 30), "repeated 02:30 not scheduled")
+	Assert(t, Equal(second.Hour(), 2), "next day at 02:30")
+}
+
+// TestCrontabCron verifies the execution of crontab jobs based on
+// cron expressions and the retrieval of the next run.
+func TestCrontabCron(t *testing.T) {
+	start := time.Date(2023, time.August, 30, 14, 17, 30, 500, time.UTC)
+	clock := wait.NewFakeClock(start)
+	runs := make(chan time.Time)
+	job := func() (bool, error) {
+		runs <- clock.Now()
+		return true, nil
+	}
+	ct, err := timex.NewCrontab(context.Background(), time.Second, timex.WithClock(clock))
+	Assert(t, NoError(err), "no error creating crontab")
+	defer ct.Stop()
+
+	err = ct.AddCron("invalid", "* * *", job)
+	Assert(t, ErrorContains(err, "need 5 or 6 fields"), "invalid expression")
+
+	err = ct.AddCron("job", "* * * * * *", job)
+	Assert(t, NoError(err), "job added")
+	var next time.Time
+	var ok bool
+	for !ok {
+		next, ok = ct.NextRun("job")
+		time.Sleep(time.Millisecond)
+	}
+	Assert(t, True(next.Equal(start.Truncate(time.Second).Add(time.Second))), "next run at full second")
+
+	clock.BlockUntil(1)
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		Assert(t, Equal(<-runs, start.Add(time.Duration(i)*time.Second)), "job executed every second")
+	}
+	next, ok = ct.NextRun("job")
+	Assert(t, True(ok), "next run planned")
+	Assert(t, True(next.Equal(start.Truncate(time.Second).Add(4*time.Second))), "next run in fake time")
+
+	_, ok = ct.NextRun("unknown")
+	Assert(t, False(ok), "no next run of unknown job")
+}
+
+// EOF
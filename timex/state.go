@@ -0,0 +1,155 @@
+// Tideland Go Stew - Time Extensions
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex // import "tideland.dev/go/stew/timex"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//--------------------
+// MISSED RUNS
+//--------------------
+
+// MissedPolicy defines how a crontab handles runs of a job missed
+// while the process wasn't running.
+type MissedPolicy int
+
+const (
+	// MissedSkip drops the missed runs and plans the next
+	// one from now on. This is the default.
+	MissedSkip MissedPolicy = iota
+
+	// MissedRunOnce runs the job once for all missed runs.
+	MissedRunOnce
+
+	// MissedRunAll runs the job for each missed run, one
+	// after another and limited to maxMissedRuns.
+	MissedRunAll
+)
+
+// maxMissedRuns limits the number of runs for MissedRunAll.
+const maxMissedRuns = 1000
+
+//--------------------
+// STATE STORE
+//--------------------
+
+// JobState contains the persistent state of a crontab job.
+type JobState struct {
+	Last time.Time `json:"last"`
+	Next time.Time `json:"next"`
+}
+
+// StateStore persists the states of crontab jobs so that missed
+// runs can be recovered after a restart.
+type StateStore interface {
+	// Load returns the state of the job. The bool is false
+	// if there's no state stored.
+	Load(id string) (JobState, bool, error)
+
+	// Save stores the state of the job.
+	Save(id string, state JobState) error
+
+	// Delete removes the state of the job.
+	Delete(id string) error
+}
+
+// FileStateStore stores the states of all jobs in one JSON file.
+type FileStateStore struct {
+	mu     sync.Mutex
+	path   string
+	states map[string]JobState
+}
+
+// NewFileStateStore creates a store using the file with the given
+// path. An existing file is read, otherwise it is created with the
+// first save.
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	fs := &FileStateStore{
+		path:   path,
+		states: make(map[string]JobState),
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fs, nil
+	case err != nil:
+		return nil, fmt.Errorf("cannot read crontab state file: %v", err)
+	}
+	if len(data) == 0 {
+		return fs, nil
+	}
+	if err := json.Unmarshal(data, &fs.states); err != nil {
+		return nil, fmt.Errorf("cannot parse crontab state file: %v", err)
+	}
+	return fs, nil
+}
+
+// Load implements StateStore.
+func (fs *FileStateStore) Load(id string) (JobState, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	state, ok := fs.states[id]
+	return state, ok, nil
+}
+
+// Save implements StateStore.
+func (fs *FileStateStore) Save(id string, state JobState) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.states[id] = state
+	return fs.write()
+}
+
+// Delete implements StateStore.
+func (fs *FileStateStore) Delete(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.states[id]; !ok {
+		return nil
+	}
+	delete(fs.states, id)
+	return fs.write()
+}
+
+// write writes all states into a temporary file and renames it
+// afterwards, so that the file is never written partially.
+func (fs *FileStateStore) write() error {
+	data, err := json.MarshalIndent(fs.states, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal crontab states: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create crontab state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write crontab state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write crontab state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), fs.path); err != nil {
+		return fmt.Errorf("cannot write crontab state file: %v", err)
+	}
+	return nil
+}
+
+// EOF
@@ -0,0 +1,214 @@
+// Tideland Go Stew - Time Extensions - Unit Tests
+//
+// Copyright (C) 2009-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package timex_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/qaenv"
+	"tideland.dev/go/stew/timex"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestFileStateStore verifies storing job states in a file.
+func TestFileStateStore(t *testing.T) {
+	td, err := qaenv.MkdirTemp("crontab")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	path := filepath.Join(td.String(), "state.json")
+
+	fs, err := timex.NewFileStateStore(path)
+	Assert(t, NoError(err), "store created")
+	_, ok, err := fs.Load("job")
+	Assert(t, NoError(err), "state loaded")
+	Assert(t, False(ok), "no state yet")
+
+	last := time.Date(2023, time.August, 30, 12, 0, 0, 0, time.UTC)
+	next := last.Add(time.Hour)
+	err = fs.Save("job", timex.JobState{Last: last, Next: next})
+	Assert(t, NoError(err), "state saved")
+	Assert(t, PathExists(path), "file written")
+
+	fs, err = timex.NewFileStateStore(path)
+	Assert(t, NoError(err), "store reopened")
+	state, ok, err := fs.Load("job")
+	Assert(t, NoError(err), "state loaded")
+	Assert(t, True(ok), "state found")
+	Assert(t, True(state.Last.Equal(last)), "last run")
+	Assert(t, True(state.Next.Equal(next)), "next run")
+
+	err = fs.Delete("job")
+	Assert(t, NoError(err), "state deleted")
+	fs, err = timex.NewFileStateStore(path)
+	Assert(t, NoError(err), "store reopened")
+	_, ok, err = fs.Load("job")
+	Assert(t, NoError(err), "state loaded")
+	Assert(t, False(ok), "state is gone")
+
+	_, err = td.WriteFile("broken.json", []byte("{broken"))
+	Assert(t, NoError(err), "broken file written")
+	_, err = timex.NewFileStateStore(filepath.Join(td.String(), "broken.json"))
+	Assert(t, ErrorContains(err, "cannot parse crontab state file"), "broken file")
+}
+
+// TestCrontabPersistentPlan verifies that a restarted crontab
+// continues with the stored plan of a job.
+func TestCrontabPersistentPlan(t *testing.T) {
+	td, err := qaenv.MkdirTemp("crontab")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	path := filepath.Join(td.String(), "state.json")
+	job := func() (bool, error) { return true, nil }
+
+	fs, err := timex.NewFileStateStore(path)
+	Assert(t, NoError(err), "store created")
+	ct, err := timex.NewCrontab(context.Background(), 10*time.Millisecond, timex.WithStateStore(fs))
+	Assert(t, NoError(err), "crontab created")
+	ct.AddSchedule("daily", timex.Every(24*time.Hour), job)
+	time.Sleep(20 * time.Millisecond)
+	planned, ok := ct.NextRun("daily")
+	Assert(t, True(ok), "next run planned")
+	ct.Stop()
+
+	// Restart with a new store instance on the same file.
+	time.Sleep(20 * time.Millisecond)
+	fs, err = timex.NewFileStateStore(path)
+	Assert(t, NoError(err), "store reopened")
+	ct, err = timex.NewCrontab(context.Background(), 10*time.Millisecond, timex.WithStateStore(fs))
+	Assert(t, NoError(err), "crontab restarted")
+	defer ct.Stop()
+	ct.AddSchedule("daily", timex.Every(24*time.Hour), job)
+	time.Sleep(20 * time.Millisecond)
+	next, ok := ct.NextRun("daily")
+	Assert(t, True(ok), "next run planned")
+	Assert(t, True(next.Equal(planned)), "plan continued")
+
+	ct.Remove("daily")
+	time.Sleep(20 * time.Millisecond)
+	_, ok, err = fs.Load("daily")
+	Assert(t, NoError(err), "state loaded")
+	Assert(t, False(ok), "state of removed job deleted")
+	Assert(t, NoError(ct.StateErr()), "no state error")
+}
+
+// TestCrontabMissedRuns verifies the different policies for runs
+// missed while the crontab wasn't running.
+func TestCrontabMissedRuns(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy timex.MissedPolicy
+		runs   int
+	}{
+		{"skip", timex.MissedSkip, 0},
+		{"run once", timex.MissedRunOnce, 1},
+		{"run all", timex.MissedRunAll, 3},
+	}
+	for _, test := range tests {
+		t.Logf("test: %s", test.name)
+		td, err := qaenv.MkdirTemp("crontab")
+		Assert(t, NoError(err), "temporary directory created")
+		fs, err := timex.NewFileStateStore(filepath.Join(td.String(), "state.json"))
+		Assert(t, NoError(err), "store created")
+
+		// Last run three hours ago, two runs missed, the third
+		// one is just due.
+		now := time.Now()
+		err = fs.Save("hourly", timex.JobState{
+			Last: now.Add(-3 * time.Hour),
+			Next: now.Add(-2 * time.Hour),
+		})
+		Assert(t, NoError(err), "state saved")
+
+		var mu sync.Mutex
+		runs := 0
+		ct, err := timex.NewCrontab(
+			context.Background(),
+			10*time.Millisecond,
+			timex.WithStateStore(fs),
+			timex.WithMissedRuns(test.policy),
+		)
+		Assert(t, NoError(err), "crontab created")
+		err = ct.AddJob("hourly", timex.Every(time.Hour), func(ctx context.Context) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs++
+			return true, nil
+		})
+		Assert(t, NoError(err), "job added")
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		Assert(t, Equal(runs, test.runs), "runs after restart")
+		mu.Unlock()
+		next, ok := ct.NextRun("hourly")
+		Assert(t, True(ok), "next run planned")
+		Assert(t, True(next.After(time.Now())), "next run in the future")
+		state, ok, err := fs.Load("hourly")
+		Assert(t, NoError(err), "state loaded")
+		Assert(t, True(ok), "state found")
+		Assert(t, True(state.Next.Equal(next)), "next run stored")
+
+		ct.Stop()
+		td.Restore()
+	}
+}
+
+// TestCrontabStateErrors verifies that errors of the state store
+// are returned when adding jobs and that the missed runs policy
+// needs a state store.
+func TestCrontabStateErrors(t *testing.T) {
+	_, err := timex.NewCrontab(context.Background(), 10*time.Millisecond, timex.WithMissedRuns(timex.MissedRunOnce))
+	Assert(t, ErrorContains(err, "missed runs policy needs a state store"), "missed runs without store")
+
+	ct, err := timex.NewCrontab(context.Background(), 10*time.Millisecond, timex.WithStateStore(failingStore{}))
+	Assert(t, NoError(err), "crontab created")
+	defer ct.Stop()
+	job := func() (bool, error) { return true, nil }
+
+	err = ct.Add("every", time.Minute, job)
+	Assert(t, ErrorContains(err, `cannot load state of job "every"`), "add failed")
+	err = ct.AddSchedule("daily", timex.Every(24*time.Hour), job)
+	Assert(t, ErrorContains(err, `cannot load state of job "daily"`), "add schedule failed")
+	_, ok := ct.NextRun("daily")
+	Assert(t, False(ok), "job not added")
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// failingStore is a state store always returning errors.
+type failingStore struct{}
+
+func (failingStore) Load(id string) (timex.JobState, bool, error) {
+	return timex.JobState{}, false, errors.New("store broken")
+}
+
+func (failingStore) Save(id string, state timex.JobState) error {
+	return errors.New("store broken")
+}
+
+func (failingStore) Delete(id string) error {
+	return errors.New("store broken")
+}
+
+// EOF
@@ -0,0 +1,302 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// windowBuckets is the number of buckets of the sliding window.
+const windowBuckets = 10
+
+// ErrCircuitOpen is returned by a CircuitBreaker not allowing
+// to process a task.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState defines the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all tasks pass.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen rejects all tasks.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a limited number of trial tasks pass.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("breaker-state(%d)", int(s))
+	}
+}
+
+//--------------------
+// CIRCUIT BREAKER
+//--------------------
+
+// BreakerConfig contains the configuration of a CircuitBreaker. The
+// circuit opens if the number of consecutive failures reaches its
+// threshold or if the failure rate inside the sliding window reaches
+// its threshold after a minimum number of tasks. A zero threshold
+// disables the according check. After the open duration the circuit
+// becomes half-open and lets trial tasks pass. If enough of them
+// succeed it closes again, a failing one opens it again.
+type BreakerConfig struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	MinRequests         int
+	Window              time.Duration
+	OpenDuration        time.Duration
+	HalfOpenRequests    int
+	OnStateChange       func(from, to BreakerState)
+}
+
+// bucket counts the results of tasks in a part of the window.
+type bucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// CircuitBreaker protects callers from repeatedly processing tasks
+// of a failing dependency.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	cfg         BreakerConfig
+	state       BreakerState
+	generation  int
+	openedAt    time.Time
+	consecutive int
+	buckets     [windowBuckets]bucket
+	trials      int
+	trialOKs    int
+}
+
+// NewCircuitBreaker creates a CircuitBreaker with the given configuration.
+// Missing values are set to defaults.
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.ConsecutiveFailures <= 0 && cfg.FailureRate <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{
+		cfg:   cfg,
+		state: BreakerClosed,
+	}
+}
+
+// Process processes the task if the circuit allows it, otherwise
+// ErrCircuitOpen is returned. The error of the task is returned
+// and counted as failure. A panic of the task is counted as
+// failure too before it's passed on.
+func (cb *CircuitBreaker) Process(ctx context.Context, task Task) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("circuit breaker context: %w", err)
+	}
+	generation, err := cb.acquire()
+	if err != nil {
+		return err
+	}
+	finished := false
+	defer func() {
+		if !finished {
+			// The task panicked, release its slot as failure.
+			cb.release(generation, false)
+		}
+	}()
+	err = task()
+	finished = true
+	cb.release(generation, err == nil)
+	return err
+}
+
+// State returns the current state of the CircuitBreaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	changes := cb.checkOpen(time.Now())
+	state := cb.state
+	cb.mu.Unlock()
+	cb.notify(changes)
+	return state
+}
+
+// Reset closes the circuit and clears all counters.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	changes := cb.setState(BreakerClosed, time.Now())
+	cb.mu.Unlock()
+	cb.notify(changes)
+}
+
+// acquire checks if a task may pass and returns the current generation.
+func (cb *CircuitBreaker) acquire() (int, error) {
+	cb.mu.Lock()
+	now := time.Now()
+	changes := cb.checkOpen(now)
+	var err error
+	switch cb.state {
+	case BreakerOpen:
+		err = ErrCircuitOpen
+	case BreakerHalfOpen:
+		if cb.trials >= cb.cfg.HalfOpenRequests {
+			err = ErrCircuitOpen
+		} else {
+			cb.trials++
+		}
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
+	cb.notify(changes)
+	return generation, err
+}
+
+// release records the result of a task. Results of tasks started
+// in an earlier generation are ignored.
+func (cb *CircuitBreaker) release(generation int, ok bool) {
+	cb.mu.Lock()
+	if generation != cb.generation {
+		cb.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	var changes []BreakerState
+	switch cb.state {
+	case BreakerClosed:
+		b := cb.bucket(now)
+		if ok {
+			b.successes++
+			cb.consecutive = 0
+		} else {
+			b.failures++
+			cb.consecutive++
+		}
+		if cb.tripped(now) {
+			changes = cb.setState(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		if !ok {
+			changes = cb.setState(BreakerOpen, now)
+			break
+		}
+		cb.trialOKs++
+		if cb.trialOKs >= cb.cfg.HalfOpenRequests {
+			changes = cb.setState(BreakerClosed, now)
+		}
+	}
+	cb.mu.Unlock()
+	cb.notify(changes)
+}
+
+// tripped checks the thresholds for opening the circuit.
+func (cb *CircuitBreaker) tripped(now time.Time) bool {
+	if cb.cfg.ConsecutiveFailures > 0 && cb.consecutive >= cb.cfg.ConsecutiveFailures {
+		return true
+	}
+	if cb.cfg.FailureRate > 0 {
+		successes, failures := 0, 0
+		for _, b := range cb.buckets {
+			if now.Sub(b.start) < cb.cfg.Window {
+				successes += b.successes
+				failures += b.failures
+			}
+		}
+		total := successes + failures
+		if total >= cb.cfg.MinRequests && float64(failures)/float64(total) >= cb.cfg.FailureRate {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket returns the bucket of the sliding window for the time,
+// outdated buckets are reset.
+func (cb *CircuitBreaker) bucket(now time.Time) *bucket {
+	size := cb.cfg.Window / windowBuckets
+	if size <= 0 {
+		size = 1
+	}
+	start := now.Truncate(size)
+	b := &cb.buckets[(start.UnixNano()/int64(size))%windowBuckets]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	return b
+}
+
+// checkOpen switches an open circuit to half-open after the
+// open duration.
+func (cb *CircuitBreaker) checkOpen(now time.Time) []BreakerState {
+	if cb.state == BreakerOpen && now.Sub(cb.openedAt) >= cb.cfg.OpenDuration {
+		return cb.setState(BreakerHalfOpen, now)
+	}
+	return nil
+}
+
+// setState changes the state, resets the counters, and returns
+// the change for notification.
+func (cb *CircuitBreaker) setState(state BreakerState, now time.Time) []BreakerState {
+	from := cb.state
+	cb.state = state
+	cb.generation++
+	cb.consecutive = 0
+	cb.trials = 0
+	cb.trialOKs = 0
+	switch state {
+	case BreakerOpen:
+		cb.openedAt = now
+	case BreakerClosed:
+		cb.buckets = [windowBuckets]bucket{}
+	}
+	if from == state {
+		return nil
+	}
+	return []BreakerState{from, state}
+}
+
+// notify calls the state change hook outside the lock.
+func (cb *CircuitBreaker) notify(changes []BreakerState) {
+	if len(changes) == 2 && cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(changes[0], changes[1])
+	}
+}
+
+// EOF
@@ -0,0 +1,177 @@
+// Tideland Go Stew - Wait - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBreakerConsecutive verifies opening, half-opening, and closing
+// based on consecutive failures.
+func TestBreakerConsecutive(t *testing.T) {
+	var mu sync.Mutex
+	var changes []string
+	cb := wait.NewCircuitBreaker(wait.BreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenDuration:        50 * time.Millisecond,
+		HalfOpenRequests:    2,
+		OnStateChange: func(from, to wait.BreakerState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, from.String()+">"+to.String())
+		},
+	})
+	ctx := context.Background()
+	failure := errors.New("failure")
+	fail := func() error { return failure }
+	succeed := func() error { return nil }
+
+	// Interrupted failures don't open.
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "task error returned")
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "task error returned")
+	Assert(t, NoError(cb.Process(ctx, succeed)), "task succeeded")
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "task error returned")
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "breaker still closed")
+
+	// Three failures in a row open.
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "task error returned")
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "task error returned")
+	Assert(t, Equal(cb.State(), wait.BreakerOpen), "breaker open")
+	err := cb.Process(ctx, succeed)
+	Assert(t, True(errors.Is(err, wait.ErrCircuitOpen)), "open breaker rejects")
+
+	// Failing trial opens again.
+	time.Sleep(60 * time.Millisecond)
+	Assert(t, Equal(cb.State(), wait.BreakerHalfOpen), "breaker half-open")
+	Assert(t, ErrorMatches(cb.Process(ctx, fail), "failure"), "trial failed")
+	Assert(t, Equal(cb.State(), wait.BreakerOpen), "breaker open again")
+
+	// Successful trials close.
+	time.Sleep(60 * time.Millisecond)
+	Assert(t, NoError(cb.Process(ctx, succeed)), "first trial succeeded")
+	Assert(t, Equal(cb.State(), wait.BreakerHalfOpen), "breaker still half-open")
+	Assert(t, NoError(cb.Process(ctx, succeed)), "second trial succeeded")
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "breaker closed")
+
+	mu.Lock()
+	defer mu.Unlock()
+	Assert(t, DeepEqual(changes, []string{
+		"closed>open",
+		"open>half-open",
+		"half-open>open",
+		"open>half-open",
+		"half-open>closed",
+	}), "state changes notified")
+}
+
+// TestBreakerFailureRate verifies opening based on the failure rate
+// inside the sliding window.
+func TestBreakerFailureRate(t *testing.T) {
+	cb := wait.NewCircuitBreaker(wait.BreakerConfig{
+		FailureRate:  0.5,
+		MinRequests:  10,
+		Window:       time.Second,
+		OpenDuration: time.Minute,
+	})
+	ctx := context.Background()
+	fail := func() error { return errors.New("failure") }
+	succeed := func() error { return nil }
+
+	// Below minimum requests nothing happens.
+	for i := 0; i < 4; i++ {
+		cb.Process(ctx, fail)
+		cb.Process(ctx, succeed)
+	}
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "too few requests")
+
+	// Rate reaches threshold.
+	cb.Process(ctx, succeed)
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "rate below threshold")
+	cb.Process(ctx, fail)
+	Assert(t, Equal(cb.State(), wait.BreakerOpen), "rate reached threshold")
+
+	// Reset closes.
+	cb.Reset()
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "breaker reset")
+	Assert(t, NoError(cb.Process(ctx, succeed)), "task processed")
+}
+
+// TestBreakerHalfOpenLimit verifies the limit of parallel trial tasks.
+func TestBreakerHalfOpenLimit(t *testing.T) {
+	cb := wait.NewCircuitBreaker(wait.BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        10 * time.Millisecond,
+	})
+	ctx := context.Background()
+	cb.Process(ctx, func() error { return errors.New("failure") })
+	time.Sleep(20 * time.Millisecond)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- cb.Process(ctx, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	err := cb.Process(ctx, func() error { return nil })
+	Assert(t, True(errors.Is(err, wait.ErrCircuitOpen)), "second trial rejected")
+	close(release)
+	Assert(t, NoError(<-done), "trial succeeded")
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "breaker closed")
+
+	// Cancelled context is not processed.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = cb.Process(cctx, func() error { return nil })
+	Assert(t, True(errors.Is(err, context.Canceled)), "cancelled context")
+}
+
+// TestBreakerHalfOpenPanic verifies that a panicking trial task
+// releases its slot and counts as failure.
+func TestBreakerHalfOpenPanic(t *testing.T) {
+	cb := wait.NewCircuitBreaker(wait.BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenDuration:        10 * time.Millisecond,
+	})
+	ctx := context.Background()
+	cb.Process(ctx, func() error { return errors.New("failure") })
+	time.Sleep(20 * time.Millisecond)
+	Assert(t, Equal(cb.State(), wait.BreakerHalfOpen), "breaker half-open")
+
+	Assert(t, Panics(func() {
+		cb.Process(ctx, func() error { panic("ouch") })
+	}), "panic passed")
+	Assert(t, Equal(cb.State(), wait.BreakerOpen), "panic counted as failure")
+
+	time.Sleep(20 * time.Millisecond)
+	err := cb.Process(ctx, func() error { return nil })
+	Assert(t, NoError(err), "trial slot released")
+	Assert(t, Equal(cb.State(), wait.BreakerClosed), "breaker closed")
+}
+
+// EOF
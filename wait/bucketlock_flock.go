@@ -0,0 +1,59 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+//--------------------
+// LOCK FILE
+//--------------------
+
+// lockFile acquires an exclusive advisory lock on the named file and
+// returns the function to release it. The file itself is kept, so
+// that all processes always lock the same file. Locks of crashed
+// processes are released by the operating system.
+func lockFile(ctx context.Context, name string) (func(), error) {
+	lf, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(lf.Fd())
+	for {
+		err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(fd, syscall.LOCK_UN)
+				lf.Close()
+			}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			lf.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			lf.Close()
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,63 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+//--------------------
+// LOCK FILE
+//--------------------
+
+// staleLock is the age after which a lock file is considered to be
+// left by a crashed process.
+const staleLock = 10 * time.Second
+
+// lockFile acquires the lock by exclusively creating the named file
+// and returns the function to release it. Stale lock files are taken
+// over by renaming them to a unique name, only the process whose
+// rename succeeds removes them.
+func lockFile(ctx context.Context, name string) (func(), error) {
+	for {
+		lf, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			lf.Close()
+			return func() {
+				os.Remove(name)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) > staleLock {
+			stale := fmt.Sprintf("%s.%d.%d", name, os.Getpid(), time.Now().UnixNano())
+			if err := os.Rename(name, stale); err == nil {
+				os.Remove(stale)
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// EOF
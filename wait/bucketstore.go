@@ -0,0 +1,223 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//--------------------
+// BUCKET STORE
+//--------------------
+
+// BucketStore stores the state of token buckets, so that it can be shared
+// between processes. Implementations only have to provide reading and an
+// atomic compare-and-swap of values with a time to live.
+type BucketStore interface {
+	// Get returns the value of the key. If it doesn't exist or is
+	// expired ok is false.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+
+	// CompareAndSwap sets the value of the key with the time to live if
+	// its current value equals old. A nil old value means the key must
+	// not exist or be expired. It returns if the value has been swapped.
+	CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error)
+}
+
+//--------------------
+// MEMORY BUCKET STORE
+//--------------------
+
+// memoryEntry is a value with its expiration time.
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryBucketStore is a BucketStore keeping the values in memory.
+type MemoryBucketStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemoryBucketStore creates a new in-memory BucketStore.
+func NewMemoryBucketStore() *MemoryBucketStore {
+	return &MemoryBucketStore{
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+// Get implements BucketStore.
+func (s *MemoryBucketStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.get(key, time.Now())
+	return value, ok, nil
+}
+
+// CompareAndSwap implements BucketStore.
+func (s *MemoryBucketStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	current, ok := s.get(key, now)
+	if !matches(current, ok, old) {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{
+		value:   append([]byte(nil), value...),
+		expires: now.Add(ttl),
+	}
+	return true, nil
+}
+
+// get returns the value of the key if it is not expired. Expired
+// entries are removed. The caller must hold the lock.
+func (s *MemoryBucketStore) get(key string, now time.Time) ([]byte, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+//--------------------
+// FILE BUCKET STORE
+//--------------------
+
+// fileEntry is the stored content of a key file.
+type fileEntry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileBucketStore is a BucketStore keeping each key in a file of a
+// directory, named by the SHA-256 hash of the key. Concurrent access
+// of local processes is serialized by advisory locks on lock files,
+// which the operating system releases when a process crashes.
+type FileBucketStore struct {
+	dir string
+}
+
+// NewFileBucketStore creates a new file based BucketStore in the
+// directory. It is created if needed.
+func NewFileBucketStore(dir string) (*FileBucketStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create bucket store directory: %w", err)
+	}
+	return &FileBucketStore{
+		dir: dir,
+	}, nil
+}
+
+// Get implements BucketStore.
+func (s *FileBucketStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	var ok bool
+	err := s.locked(ctx, key, func(path string) error {
+		var err error
+		value, ok, err = s.read(path)
+		return err
+	})
+	return value, ok, err
+}
+
+// CompareAndSwap implements BucketStore.
+func (s *FileBucketStore) CompareAndSwap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	var swapped bool
+	err := s.locked(ctx, key, func(path string) error {
+		current, ok, err := s.read(path)
+		if err != nil {
+			return err
+		}
+		if !matches(current, ok, old) {
+			return nil
+		}
+		data, err := json.Marshal(fileEntry{
+			Value:   value,
+			Expires: time.Now().Add(ttl),
+		})
+		if err != nil {
+			return fmt.Errorf("cannot marshal bucket state: %w", err)
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return fmt.Errorf("cannot write bucket state: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("cannot write bucket state: %w", err)
+		}
+		swapped = true
+		return nil
+	})
+	return swapped, err
+}
+
+// locked executes f with the path of the key file while holding
+// the lock of the key.
+func (s *FileBucketStore) locked(ctx context.Context, key string, f func(path string) error) error {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return fmt.Errorf("cannot lock bucket state: %w", err)
+	}
+	defer unlock()
+	return f(path)
+}
+
+// read reads the not expired value of the key file.
+func (s *FileBucketStore) read(path string) ([]byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("cannot read bucket state: %w", err)
+	}
+	var entry fileEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("cannot unmarshal bucket state: %w", err)
+	}
+	if !time.Now().Before(entry.Expires) {
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+//--------------------
+// HELPER
+//--------------------
+
+// matches checks if the current value matches the expected old one.
+func matches(current []byte, ok bool, old []byte) bool {
+	if old == nil {
+		return !ok
+	}
+	return ok && bytes.Equal(current, old)
+}
+
+// EOF
@@ -0,0 +1,239 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// ErrBulkheadFull is returned by a Bulkhead if its wait queue is full.
+var ErrBulkheadFull = errors.New("bulkhead queue is full")
+
+// ErrBulkheadTimeout is returned by a Bulkhead if a task waited too
+// long in the queue.
+var ErrBulkheadTimeout = errors.New("bulkhead queue timeout")
+
+//--------------------
+// BULKHEAD
+//--------------------
+
+// BulkheadConfig contains the configuration of a Bulkhead. MaxConcurrency
+// is the total weight of tasks processed at once, MaxQueue the number of
+// tasks waiting for processing, and QueueTimeout the maximum time they
+// wait. A zero QueueTimeout lets them wait as long as their context allows.
+//
+// With Adaptive the limit changes between MinConcurrency and MaxConcurrency
+// based on the observed latency. Each task faster than TargetLatency
+// additively increases it, each slower one multiplicatively decreases
+// it by the Decrease factor.
+type BulkheadConfig struct {
+	MaxConcurrency int
+	MaxQueue       int
+	QueueTimeout   time.Duration
+	Adaptive       bool
+	MinConcurrency int
+	TargetLatency  time.Duration
+	Decrease       float64
+}
+
+// waiter is a task waiting for processing.
+type waiter struct {
+	weight int
+	ready  chan struct{}
+}
+
+// Bulkhead limits the number of tasks processed concurrently.
+type Bulkhead struct {
+	mu      sync.Mutex
+	cfg     BulkheadConfig
+	limit   float64
+	used    int
+	waiters *list.List
+}
+
+// NewBulkhead creates a Bulkhead with the given configuration. Missing
+// values are set to defaults.
+func NewBulkhead(cfg BulkheadConfig) *Bulkhead {
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = 1
+	}
+	if cfg.MaxQueue < 0 {
+		cfg.MaxQueue = 0
+	}
+	if cfg.MinConcurrency <= 0 || cfg.MinConcurrency > cfg.MaxConcurrency {
+		cfg.MinConcurrency = 1
+	}
+	if cfg.TargetLatency <= 0 {
+		cfg.TargetLatency = time.Second
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = 0.9
+	}
+	return &Bulkhead{
+		cfg:     cfg,
+		limit:   float64(cfg.MaxConcurrency),
+		waiters: list.New(),
+	}
+}
+
+// Process processes the task with a weight of one.
+func (b *Bulkhead) Process(ctx context.Context, task Task) error {
+	return b.ProcessWeighted(ctx, 1, task)
+}
+
+// ProcessWeighted processes the task with the given weight as soon as
+// the Bulkhead has enough capacity. If the queue is full, the queue
+// timeout is reached, or the context is done an error is returned.
+func (b *Bulkhead) ProcessWeighted(ctx context.Context, weight int, task Task) error {
+	if weight <= 0 || weight > b.cfg.MaxConcurrency {
+		return fmt.Errorf("invalid bulkhead task weight %d", weight)
+	}
+	if err := b.acquire(ctx, weight); err != nil {
+		return err
+	}
+	start := time.Now()
+	defer func() {
+		b.release(weight, time.Since(start))
+	}()
+	return task()
+}
+
+// Limit returns the current concurrency limit.
+func (b *Bulkhead) Limit() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current()
+}
+
+// Running returns the total weight of the currently processed tasks.
+func (b *Bulkhead) Running() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Queued returns the number of waiting tasks.
+func (b *Bulkhead) Queued() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waiters.Len()
+}
+
+// acquire waits until the weight can be used.
+func (b *Bulkhead) acquire(ctx context.Context, weight int) error {
+	b.mu.Lock()
+	if b.waiters.Len() == 0 && b.fits(weight) {
+		b.used += weight
+		b.mu.Unlock()
+		return nil
+	}
+	if b.waiters.Len() >= b.cfg.MaxQueue {
+		b.mu.Unlock()
+		return ErrBulkheadFull
+	}
+	w := &waiter{
+		weight: weight,
+		ready:  make(chan struct{}),
+	}
+	elem := b.waiters.PushBack(w)
+	b.mu.Unlock()
+	// Wait for being ready.
+	var timeout <-chan time.Time
+	if b.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(b.cfg.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timeout:
+		err = ErrBulkheadTimeout
+	case <-ctx.Done():
+		err = fmt.Errorf("bulkhead context: %w", ctx.Err())
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Got ready in the meantime, so give it back.
+		b.used -= weight
+	default:
+		b.waiters.Remove(elem)
+	}
+	b.grant()
+	return err
+}
+
+// release gives the weight back and adapts the limit.
+func (b *Bulkhead) release(weight int, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= weight
+	if b.cfg.Adaptive {
+		if latency > b.cfg.TargetLatency {
+			b.limit *= b.cfg.Decrease
+			if b.limit < float64(b.cfg.MinConcurrency) {
+				b.limit = float64(b.cfg.MinConcurrency)
+			}
+		} else {
+			b.limit += 1 / b.limit
+			if b.limit > float64(b.cfg.MaxConcurrency) {
+				b.limit = float64(b.cfg.MaxConcurrency)
+			}
+		}
+	}
+	b.grant()
+}
+
+// grant lets the waiting tasks in order pass as long as the
+// limit allows it. The caller must hold the lock.
+func (b *Bulkhead) grant() {
+	for {
+		front := b.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*waiter)
+		if !b.fits(w.weight) {
+			return
+		}
+		b.used += w.weight
+		b.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// fits checks if the weight fits into the current limit. A task
+// heavier than an adaptively lowered limit can pass if nothing else
+// is processed. The caller must hold the lock.
+func (b *Bulkhead) fits(weight int) bool {
+	return b.used+weight <= b.current() || b.used == 0
+}
+
+// current returns the current limit as integer. The caller must
+// hold the lock.
+func (b *Bulkhead) current() int {
+	return int(b.limit)
+}
+
+// EOF
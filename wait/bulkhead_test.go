@@ -0,0 +1,167 @@
+// Tideland Go Stew - Wait - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBulkheadConcurrency verifies the limit of concurrently processed tasks.
+func TestBulkheadConcurrency(t *testing.T) {
+	bh := wait.NewBulkhead(wait.BulkheadConfig{
+		MaxConcurrency: 3,
+		MaxQueue:       100,
+	})
+	ctx := context.Background()
+	cc := &concurrencyCounter{}
+	task := func() error {
+		cc.incr()
+		defer cc.decr()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(30)
+	for i := 0; i < 30; i++ {
+		go func() {
+			defer wg.Done()
+			Assert(t, NoError(bh.Process(ctx, task)), "task processed")
+		}()
+	}
+	wg.Wait()
+	Assert(t, Equal(cc.max(), 3), "maximum concurrency")
+	Assert(t, Equal(bh.Running(), 0), "nothing running")
+	Assert(t, Equal(bh.Queued(), 0), "nothing queued")
+}
+
+// TestBulkheadQueue verifies the bounded queue and its timeout.
+func TestBulkheadQueue(t *testing.T) {
+	bh := wait.NewBulkhead(wait.BulkheadConfig{
+		MaxConcurrency: 1,
+		MaxQueue:       1,
+		QueueTimeout:   50 * time.Millisecond,
+	})
+	ctx := context.Background()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- bh.Process(ctx, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	// One task waits, the next one is rejected.
+	queued := make(chan error)
+	go func() {
+		queued <- bh.Process(ctx, func() error { return nil })
+	}()
+	for bh.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	err := bh.Process(ctx, func() error { return nil })
+	Assert(t, True(errors.Is(err, wait.ErrBulkheadFull)), "queue is full")
+	err = <-queued
+	Assert(t, True(errors.Is(err, wait.ErrBulkheadTimeout)), "queue timeout")
+
+	// Cancelled context while waiting.
+	cctx, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = bh.Process(cctx, func() error { return nil })
+	Assert(t, True(errors.Is(err, context.Canceled)), "context cancelled")
+
+	close(release)
+	Assert(t, NoError(<-done), "first task processed")
+	Assert(t, Equal(bh.Queued(), 0), "nothing queued")
+}
+
+// TestBulkheadWeighted verifies the processing of weighted tasks.
+func TestBulkheadWeighted(t *testing.T) {
+	bh := wait.NewBulkhead(wait.BulkheadConfig{
+		MaxConcurrency: 4,
+		MaxQueue:       10,
+	})
+	ctx := context.Background()
+	err := bh.ProcessWeighted(ctx, 5, func() error { return nil })
+	Assert(t, ErrorContains(err, "invalid bulkhead task weight"), "too heavy")
+
+	var mu sync.Mutex
+	maxUsed := 0
+	task := func() error {
+		mu.Lock()
+		if r := bh.Running(); r > maxUsed {
+			maxUsed = r
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, weight := range []int{3, 2, 2, 1, 4, 1} {
+		wg.Add(1)
+		go func(weight int) {
+			defer wg.Done()
+			Assert(t, NoError(bh.ProcessWeighted(ctx, weight, task)), "task processed")
+		}(weight)
+	}
+	wg.Wait()
+	Assert(t, Range(maxUsed, 1, 4), "weight limit kept")
+	Assert(t, Equal(bh.Running(), 0), "nothing running")
+}
+
+// TestBulkheadAdaptive verifies the adaption of the limit to the latency.
+func TestBulkheadAdaptive(t *testing.T) {
+	bh := wait.NewBulkhead(wait.BulkheadConfig{
+		MaxConcurrency: 10,
+		MinConcurrency: 2,
+		Adaptive:       true,
+		TargetLatency:  5 * time.Millisecond,
+		Decrease:       0.5,
+	})
+	ctx := context.Background()
+	slow := func() error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	fast := func() error { return nil }
+
+	Assert(t, Equal(bh.Limit(), 10), "initial limit")
+	bh.Process(ctx, slow)
+	Assert(t, Equal(bh.Limit(), 5), "limit decreased")
+	bh.Process(ctx, slow)
+	bh.Process(ctx, slow)
+	Assert(t, Equal(bh.Limit(), 2), "minimum limit")
+	for i := 0; i < 10; i++ {
+		bh.Process(ctx, fast)
+	}
+	Assert(t, Range(bh.Limit(), 3, 10), "limit increased")
+}
+
+// EOF
@@ -0,0 +1,307 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+)
+
+//--------------------
+// CLOCK
+//--------------------
+
+// Clock provides the current time, timers, and tickers. Beside the real
+// clock the FakeClock allows tests to control the time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+
+	// NewTimer creates a Timer firing after the duration.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a Ticker firing in intervals of the duration.
+	NewTicker(d time.Duration) Ticker
+
+	// After waits for the duration and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep pauses for the duration.
+	Sleep(d time.Duration)
+}
+
+// Timer is a single event timer of a Clock.
+type Timer interface {
+	// C returns the channel the time is sent on.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing.
+	Stop() bool
+
+	// Reset changes the Timer to fire after the duration.
+	Reset(d time.Duration) bool
+}
+
+// Ticker sends the time in intervals.
+type Ticker interface {
+	// C returns the channel the times are sent on.
+	C() <-chan time.Time
+
+	// Stop turns off the Ticker.
+	Stop()
+}
+
+// RealClock returns the Clock based on the time package.
+func RealClock() Clock {
+	return realClock{}
+}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTimer wraps a time.Timer.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time        { return rt.t.C }
+func (rt realTimer) Stop() bool                 { return rt.t.Stop() }
+func (rt realTimer) Reset(d time.Duration) bool { return rt.t.Reset(d) }
+
+// realTicker wraps a time.Ticker.
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (rt realTicker) C() <-chan time.Time { return rt.t.C }
+func (rt realTicker) Stop()               { rt.t.Stop() }
+
+//--------------------
+// FAKE CLOCK
+//--------------------
+
+// FakeClock is a Clock only changing its time when advanced manually.
+// Timers and tickers fire when their time is reached while advancing,
+// so tests can run deterministically without sleeping.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  map[*fakeTimer]struct{}
+	changed chan struct{}
+}
+
+// NewFakeClock creates a FakeClock starting at the given time.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		now:     start,
+		timers:  make(map[*fakeTimer]struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Now implements Clock.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+// Since implements Clock.
+func (fc *FakeClock) Since(t time.Time) time.Duration {
+	return fc.Now().Sub(t)
+}
+
+// NewTimer implements Clock.
+func (fc *FakeClock) NewTimer(d time.Duration) Timer {
+	ft := &fakeTimer{
+		clock: fc,
+		c:     make(chan time.Time, 1),
+	}
+	ft.Reset(d)
+	return ft
+}
+
+// NewTicker implements Clock.
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	ft := &fakeTimer{
+		clock:  fc,
+		c:      make(chan time.Time, 1),
+		period: d,
+	}
+	ft.Reset(d)
+	return fakeTicker{ft}
+}
+
+// After implements Clock.
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	return fc.NewTimer(d).C()
+}
+
+// Sleep implements Clock. It returns when the clock has been
+// advanced by the duration.
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.After(d)
+}
+
+// Advance moves the time forward by the duration. All timers and
+// tickers reaching their time are fired in order.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	fc.advanceTo(fc.now.Add(d))
+	fc.mu.Unlock()
+}
+
+// Set moves the time forward to t. Setting it back only changes
+// the time without firing.
+func (fc *FakeClock) Set(t time.Time) {
+	fc.mu.Lock()
+	if t.Before(fc.now) {
+		fc.now = t
+	} else {
+		fc.advanceTo(t)
+	}
+	fc.mu.Unlock()
+}
+
+// Waiters returns the number of active timers and tickers.
+func (fc *FakeClock) Waiters() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are active. It
+// helps tests to advance the time only after the tested goroutines
+// started waiting.
+func (fc *FakeClock) BlockUntil(n int) {
+	for {
+		fc.mu.Lock()
+		if len(fc.timers) >= n {
+			fc.mu.Unlock()
+			return
+		}
+		changed := fc.changed
+		fc.mu.Unlock()
+		<-changed
+	}
+}
+
+// advanceTo fires all timers until the time t. The caller
+// must hold the lock.
+func (fc *FakeClock) advanceTo(t time.Time) {
+	for {
+		var next *fakeTimer
+		for ft := range fc.timers {
+			if !ft.when.After(t) && (next == nil || ft.when.Before(next.when)) {
+				next = ft
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.when.After(fc.now) {
+			fc.now = next.when
+		}
+		next.fire(fc.now)
+	}
+	fc.now = t
+}
+
+// signal wakes up the goroutines waiting in BlockUntil. The caller
+// must hold the lock.
+func (fc *FakeClock) signal() {
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+// fakeTimer implements Timer and Ticker for the FakeClock.
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+// C implements Timer.
+func (ft *fakeTimer) C() <-chan time.Time {
+	return ft.c
+}
+
+// Stop implements Timer.
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.mu.Lock()
+	defer ft.clock.mu.Unlock()
+	_, active := ft.clock.timers[ft]
+	delete(ft.clock.timers, ft)
+	return active
+}
+
+// Reset implements Timer.
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	fc := ft.clock
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	_, active := fc.timers[ft]
+	ft.when = fc.now.Add(d)
+	fc.timers[ft] = struct{}{}
+	fc.signal()
+	if d <= 0 {
+		ft.fire(fc.now)
+	}
+	return active
+}
+
+// fakeTicker adapts the fakeTimer to the Ticker interface.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+// Stop implements Ticker.
+func (ft fakeTicker) Stop() {
+	ft.fakeTimer.Stop()
+}
+
+// fire sends the time without blocking and reschedules tickers. The
+// caller must hold the lock of the clock.
+func (ft *fakeTimer) fire(now time.Time) {
+	select {
+	case ft.c <- now:
+	default:
+	}
+	if ft.period > 0 {
+		ft.when = ft.when.Add(ft.period)
+		return
+	}
+	delete(ft.clock.timers, ft)
+}
+
+// EOF
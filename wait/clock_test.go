@@ -0,0 +1,122 @@
+// Tideland Go Stew - Wait - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestFakeClockTimer verifies timers of the fake clock.
+func TestFakeClockTimer(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := wait.NewFakeClock(start)
+	Assert(t, Equal(clock.Now(), start), "clock starts at given time")
+
+	timer := clock.NewTimer(time.Minute)
+	after := clock.After(2 * time.Minute)
+	Assert(t, Equal(clock.Waiters(), 2), "two waiters")
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatalf("timer fired too early")
+	default:
+	}
+
+	clock.Advance(45 * time.Second)
+	fired := <-timer.C()
+	Assert(t, Equal(fired, start.Add(time.Minute)), "timer fired at its time")
+	Assert(t, Equal(clock.Since(start), 75*time.Second), "time advanced")
+	Assert(t, Equal(clock.Waiters(), 1), "one waiter left")
+
+	Assert(t, False(timer.Reset(time.Minute)), "fired timer was not active")
+	Assert(t, True(timer.Stop()), "reset timer stopped")
+	clock.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+	Assert(t, Equal(<-after, start.Add(2*time.Minute)), "after fired at its time")
+}
+
+// TestFakeClockTicker verifies tickers of the fake clock.
+func TestFakeClockTicker(t *testing.T) {
+	start := time.Now()
+	clock := wait.NewFakeClock(start)
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		Assert(t, Equal(<-ticker.C(), start.Add(time.Duration(i)*time.Second)), "tick received")
+	}
+	// Missed ticks are dropped like with real tickers.
+	clock.Advance(10 * time.Second)
+	Assert(t, Equal(<-ticker.C(), start.Add(4*time.Second)), "first missed tick received")
+	select {
+	case <-ticker.C():
+		t.Fatalf("further ticks not dropped")
+	default:
+	}
+}
+
+// TestFakeClockSleep verifies sleeping with the fake clock.
+func TestFakeClockSleep(t *testing.T) {
+	clock := wait.NewFakeClock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Hour)
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	<-done
+}
+
+// TestPollFakeClock verifies polling with a ticker using the fake clock.
+func TestPollFakeClock(t *testing.T) {
+	clock := wait.NewFakeClock(time.Now())
+	ticker := wait.MakeExpiringIntervalTicker(time.Second, 10*time.Second, wait.WithClock(clock))
+	count := 0
+	done := make(chan error)
+	go func() {
+		done <- wait.Poll(context.Background(), ticker, func() (bool, error) {
+			count++
+			return false, nil
+		})
+	}()
+	// Advance step by step, ticks not received in time are dropped.
+	for {
+		select {
+		case err := <-done:
+			Assert(t, ErrorContains(err, "exceeded"), "ticker expired")
+			Assert(t, Range(count, 1, 11), "polled in fake intervals")
+			Assert(t, Equal(clock.Waiters(), 0), "ticker timer stopped")
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+}
+
+// EOF
@@ -8,8 +8,28 @@
 // Package wait provides a generic waiting for conditions by polling, some
 // standard tickers for the polling are already pre-defined.
 //
+// The tickers use a Clock. By default it's the real one, the FakeClock
+// only changes its time when advanced manually. This way tests of code
+// waiting for time can run without sleeping. Other packages like timex,
+// loop, and actor accept the Clock via options too.
+//
 // Additionally the package provide a throttle for the limited processing
-// of events per second.
+// of events per second. The KeyedThrottle does the same individually per
+// key, e.g. a client ID, and reports the remaining Quota of each key.
+// The StoreThrottle keeps its token bucket in a BucketStore, so that
+// multiple processes can share one quota. The package contains an
+// in-memory and a file based store.
+//
+// The CircuitBreaker protects against repeatedly processing tasks of a
+// failing dependency. It opens after a number of consecutive failures or
+// when the failure rate in a sliding window reaches a threshold. After a
+// configured duration it lets trial tasks pass and closes again if they
+// succeed. Each state change can be observed with a hook.
+//
+// The Bulkhead limits the number of concurrently processed tasks. Tasks
+// exceeding the limit wait in a bounded queue with a timeout, tasks can
+// have different weights. Optionally the limit adapts to the observed
+// latency by additive increase and multiplicative decrease.
 package wait // import "tideland.dev/go/stew/wait"
 
 // EOF
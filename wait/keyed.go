@@ -0,0 +1,205 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+//--------------------
+// KEYED THROTTLE
+//--------------------
+
+// Quota describes the state of the limiter of a key. Limit is the burst,
+// Remaining the number of tasks that can be processed immediately, and
+// Reset the duration until the full burst is available again. It can be
+// used for RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset
+// HTTP headers.
+type Quota struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// keyLimit contains limit and burst for a key.
+type keyLimit struct {
+	limit Limit
+	burst int
+}
+
+// keyedLimiter is the limiter of a key together with the time
+// of its last usage.
+type keyedLimiter struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+// KeyedThrottle limits the processing of tasks per second individually
+// for keys like client IDs or IP addresses. The limiters are created on
+// demand with the default limit and burst or the ones set for the key.
+// Limiters of keys idle for the idle duration and with full burst are
+// evicted.
+type KeyedThrottle struct {
+	mu       sync.Mutex
+	limit    Limit
+	burst    int
+	idle     time.Duration
+	swept    time.Time
+	limits   map[string]keyLimit
+	limiters map[string]*keyedLimiter
+}
+
+// NewKeyedThrottle creates a new KeyedThrottle with the specified default
+// limit and burst. Idle keys are evicted after the idle duration, a zero
+// duration keeps them.
+func NewKeyedThrottle(limit Limit, burst int, idle time.Duration) *KeyedThrottle {
+	return &KeyedThrottle{
+		limit:    limit,
+		burst:    burst,
+		idle:     idle,
+		swept:    time.Now(),
+		limits:   make(map[string]keyLimit),
+		limiters: make(map[string]*keyedLimiter),
+	}
+}
+
+// SetLimit sets an individual limit and burst for the key.
+func (kt *KeyedThrottle) SetLimit(key string, limit Limit, burst int) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.limits[key] = keyLimit{
+		limit: limit,
+		burst: burst,
+	}
+	if kl, ok := kt.limiters[key]; ok {
+		now := time.Now()
+		kl.limiter.SetLimitAt(now, limit)
+		kl.limiter.SetBurstAt(now, burst)
+	}
+}
+
+// ResetLimit removes the individual limit of the key, so the default
+// limit is used again.
+func (kt *KeyedThrottle) ResetLimit(key string) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	delete(kt.limits, key)
+	if kl, ok := kt.limiters[key]; ok {
+		now := time.Now()
+		kl.limiter.SetLimitAt(now, kt.limit)
+		kl.limiter.SetBurstAt(now, kt.burst)
+	}
+}
+
+// Process processes a task for the key under the context, waiting
+// if necessary.
+func (kt *KeyedThrottle) Process(ctx context.Context, key string, task Task) error {
+	// Wait for the limiter to allow us to proceed.
+	if err := kt.limiter(key).Wait(ctx); err != nil {
+		return fmt.Errorf("wait for throttle limiter of key %q: %w", key, err)
+	}
+	// Process the task and return its error.
+	return task()
+}
+
+// Allow checks if a task for the key may be processed now. In this
+// case the task is counted. Additionally the Quota of the key is returned.
+func (kt *KeyedThrottle) Allow(key string) (bool, Quota) {
+	limiter := kt.limiter(key)
+	now := time.Now()
+	ok := limiter.AllowN(now, 1)
+	return ok, quotaAt(limiter, now)
+}
+
+// Quota returns the quota of the key.
+func (kt *KeyedThrottle) Quota(key string) Quota {
+	return quotaAt(kt.limiter(key), time.Now())
+}
+
+// Len returns the number of keys with a limiter.
+func (kt *KeyedThrottle) Len() int {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	return len(kt.limiters)
+}
+
+// Evict removes the limiters of all idle keys.
+func (kt *KeyedThrottle) Evict() {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	kt.evict(time.Now())
+}
+
+// limiter returns the limiter for the key. It is created if needed.
+func (kt *KeyedThrottle) limiter(key string) *rate.Limiter {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+	now := time.Now()
+	if kt.idle > 0 && now.Sub(kt.swept) >= kt.idle {
+		kt.evict(now)
+	}
+	kl, ok := kt.limiters[key]
+	if !ok {
+		limit, burst := kt.limit, kt.burst
+		if l, ok := kt.limits[key]; ok {
+			limit, burst = l.limit, l.burst
+		}
+		kl = &keyedLimiter{
+			limiter: rate.NewLimiter(limit, burst),
+		}
+		kt.limiters[key] = kl
+	}
+	kl.used = now
+	return kl.limiter
+}
+
+// evict removes the limiters of the keys idle for the idle duration
+// and with full burst. The caller must hold the lock.
+func (kt *KeyedThrottle) evict(now time.Time) {
+	kt.swept = now
+	if kt.idle <= 0 {
+		return
+	}
+	for key, kl := range kt.limiters {
+		if now.Sub(kl.used) < kt.idle {
+			continue
+		}
+		if kl.limiter.TokensAt(now) < float64(kl.limiter.Burst()) {
+			continue
+		}
+		delete(kt.limiters, key)
+	}
+}
+
+// quotaAt calculates the quota of the limiter at the given time.
+func quotaAt(limiter *rate.Limiter, now time.Time) Quota {
+	burst := limiter.Burst()
+	tokens := limiter.TokensAt(now)
+	q := Quota{
+		Limit:     burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+	}
+	limit := limiter.Limit()
+	missing := float64(burst) - tokens
+	if missing > 0 && limit > 0 && limit != InfLimit {
+		q.Reset = time.Duration(missing / float64(limit) * float64(time.Second))
+	}
+	return q
+}
+
+// EOF
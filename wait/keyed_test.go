@@ -0,0 +1,99 @@
+// Tideland Go Stew - Wait - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestKeyedThrottleAllow verifies the independent limiting of keys
+// and the returned quota.
+func TestKeyedThrottleAllow(t *testing.T) {
+	kt := wait.NewKeyedThrottle(10, 3, 0)
+	kt.SetLimit("premium", 10, 5)
+
+	for i := 2; i >= 0; i-- {
+		ok, q := kt.Allow("alpha")
+		Assert(t, True(ok), "task allowed")
+		Assert(t, Equal(q.Limit, 3), "limit is default burst")
+		Assert(t, Equal(q.Remaining, i), "remaining quota")
+	}
+	ok, q := kt.Allow("alpha")
+	Assert(t, False(ok), "burst exhausted")
+	Assert(t, Range(q.Reset, 250*time.Millisecond, 300*time.Millisecond), "reset until full burst")
+
+	ok, q = kt.Allow("beta")
+	Assert(t, True(ok), "other key not affected")
+	Assert(t, Equal(q.Remaining, 2), "remaining quota of other key")
+
+	for i := 0; i < 5; i++ {
+		ok, _ = kt.Allow("premium")
+		Assert(t, True(ok), "individual burst")
+	}
+	ok, _ = kt.Allow("premium")
+	Assert(t, False(ok), "individual burst exhausted")
+
+	kt.ResetLimit("premium")
+	Assert(t, Equal(kt.Quota("premium").Limit, 3), "default burst again")
+	Assert(t, Equal(kt.Len(), 3), "three keys")
+}
+
+// TestKeyedThrottleProcess verifies the waiting processing per key.
+func TestKeyedThrottleProcess(t *testing.T) {
+	kt := wait.NewKeyedThrottle(20, 1, 0)
+	ctx := context.Background()
+	count := 0
+	task := func() error {
+		count++
+		return nil
+	}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		Assert(t, NoError(kt.Process(ctx, "alpha", task)), "task processed")
+	}
+	Assert(t, Range(time.Since(start), 150*time.Millisecond, 300*time.Millisecond), "tasks throttled")
+	Assert(t, Equal(count, 5), "all tasks processed")
+
+	kt = wait.NewKeyedThrottle(0, 0, 0)
+	err := kt.Process(ctx, "alpha", task)
+	Assert(t, ErrorContains(err, `key "alpha"`), "no tasks allowed")
+}
+
+// TestKeyedThrottleEvict verifies the eviction of idle keys.
+func TestKeyedThrottleEvict(t *testing.T) {
+	kt := wait.NewKeyedThrottle(100, 1, 20*time.Millisecond)
+	kt.Allow("alpha")
+	kt.Allow("beta")
+	Assert(t, Equal(kt.Len(), 2), "two keys")
+
+	time.Sleep(30 * time.Millisecond)
+	kt.Allow("gamma")
+	Assert(t, Equal(kt.Len(), 1), "idle keys evicted")
+
+	kt = wait.NewKeyedThrottle(1, 1, 10*time.Millisecond)
+	kt.Allow("alpha")
+	time.Sleep(20 * time.Millisecond)
+	kt.Evict()
+	Assert(t, Equal(kt.Len(), 1), "key without full burst kept")
+}
+
+// EOF
@@ -0,0 +1,147 @@
+// Tideland Go Stew - Wait
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait // import "tideland.dev/go/stew/wait"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//--------------------
+// STORE THROTTLE
+//--------------------
+
+// bucketState is the state of a token bucket in a BucketStore.
+type bucketState struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+// StoreThrottle limits the processing of tasks per second like the
+// Throttle. But the state of its token bucket is kept in a BucketStore
+// under a key, so that multiple throttles in different processes can
+// share one quota.
+type StoreThrottle struct {
+	store BucketStore
+	key   string
+	limit Limit
+	burst int
+	ttl   time.Duration
+}
+
+// NewStoreThrottle creates a new StoreThrottle with the specified limit
+// and burst using the store and the key.
+func NewStoreThrottle(store BucketStore, key string, limit Limit, burst int) *StoreThrottle {
+	// Keep the state at least as long as the bucket needs to refill.
+	ttl := time.Minute
+	if limit > 0 && limit != InfLimit {
+		refill := time.Duration(float64(burst) / float64(limit) * float64(time.Second))
+		if 2*refill > ttl {
+			ttl = 2 * refill
+		}
+	}
+	return &StoreThrottle{
+		store: store,
+		key:   key,
+		limit: limit,
+		burst: burst,
+		ttl:   ttl,
+	}
+}
+
+// Process processes a task under the context, waiting if necessary.
+func (t *StoreThrottle) Process(ctx context.Context, task Task) error {
+	for {
+		delay, err := t.take(ctx)
+		if err != nil {
+			return fmt.Errorf("wait for throttle store: %w", err)
+		}
+		if delay == 0 {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("wait for throttle store: %w", ctx.Err())
+		}
+	}
+	// Process the task and return its error.
+	return task()
+}
+
+// take tries to take a token from the bucket. If none is available
+// the delay until the next one is returned.
+func (t *StoreThrottle) take(ctx context.Context) (time.Duration, error) {
+	if t.limit == InfLimit {
+		return 0, nil
+	}
+	if t.burst <= 0 {
+		return 0, errors.New("throttle store bucket has no burst")
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		old, ok, err := t.store.Get(ctx, t.key)
+		if err != nil {
+			return 0, err
+		}
+		now := time.Now()
+		state := bucketState{
+			Tokens:  float64(t.burst),
+			Updated: now.UnixNano(),
+		}
+		if ok {
+			if err := json.Unmarshal(old, &state); err != nil {
+				return 0, fmt.Errorf("cannot unmarshal bucket state: %w", err)
+			}
+			elapsed := now.Sub(time.Unix(0, state.Updated)).Seconds()
+			if elapsed > 0 {
+				state.Tokens = math.Min(float64(t.burst), state.Tokens+elapsed*float64(t.limit))
+			}
+			state.Updated = now.UnixNano()
+		} else {
+			old = nil
+		}
+		if state.Tokens < 1 {
+			if t.limit <= 0 {
+				return 0, errors.New("throttle store bucket has no limit")
+			}
+			missing := 1 - state.Tokens
+			delay := time.Duration(missing / float64(t.limit) * float64(time.Second))
+			if delay <= 0 {
+				delay = time.Millisecond
+			}
+			return delay, nil
+		}
+		state.Tokens--
+		value, err := json.Marshal(state)
+		if err != nil {
+			return 0, fmt.Errorf("cannot marshal bucket state: %w", err)
+		}
+		swapped, err := t.store.CompareAndSwap(ctx, t.key, old, value, t.ttl)
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return 0, nil
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,185 @@
+// Tideland Go Stew - Wait - Unit Tests
+//
+// Copyright (C) 2019-2023 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package wait_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "tideland.dev/go/stew/qaone"
+
+	"tideland.dev/go/stew/qaenv"
+	"tideland.dev/go/stew/wait"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestBucketStores verifies the compare-and-swap semantics of the stores.
+func TestBucketStores(t *testing.T) {
+	td, err := qaenv.MkdirTemp("bucketstore")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	fs, err := wait.NewFileBucketStore(td.String())
+	Assert(t, NoError(err), "file store created")
+
+	stores := map[string]wait.BucketStore{
+		"memory": wait.NewMemoryBucketStore(),
+		"file":   fs,
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			_, ok, err := store.Get(ctx, "key")
+			Assert(t, NoError(err), "no error")
+			Assert(t, False(ok), "key does not exist")
+
+			swapped, err := store.CompareAndSwap(ctx, "key", nil, []byte("a"), time.Minute)
+			Assert(t, NoError(err), "no error")
+			Assert(t, True(swapped), "new key set")
+			swapped, err = store.CompareAndSwap(ctx, "key", nil, []byte("b"), time.Minute)
+			Assert(t, NoError(err), "no error")
+			Assert(t, False(swapped), "existing key not set")
+			swapped, err = store.CompareAndSwap(ctx, "key", []byte("x"), []byte("b"), time.Minute)
+			Assert(t, NoError(err), "no error")
+			Assert(t, False(swapped), "different old value")
+			swapped, err = store.CompareAndSwap(ctx, "key", []byte("a"), []byte("b"), 20*time.Millisecond)
+			Assert(t, NoError(err), "no error")
+			Assert(t, True(swapped), "matching old value")
+
+			value, ok, err := store.Get(ctx, "key")
+			Assert(t, NoError(err), "no error")
+			Assert(t, True(ok), "key exists")
+			Assert(t, Equal(string(value), "b"), "value swapped")
+
+			time.Sleep(30 * time.Millisecond)
+			_, ok, err = store.Get(ctx, "key")
+			Assert(t, NoError(err), "no error")
+			Assert(t, False(ok), "key expired")
+		})
+	}
+}
+
+// TestFileBucketStoreLocking verifies long keys and the serialized
+// access of multiple file stores sharing one directory.
+func TestFileBucketStoreLocking(t *testing.T) {
+	td, err := qaenv.MkdirTemp("bucketlock")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	ctx := context.Background()
+
+	fsa, err := wait.NewFileBucketStore(td.String())
+	Assert(t, NoError(err), "file store created")
+	long := strings.Repeat("long-key/", 200)
+	swapped, err := fsa.CompareAndSwap(ctx, long, nil, []byte("a"), time.Minute)
+	Assert(t, NoError(err), "long key stored")
+	Assert(t, True(swapped), "long key set")
+	value, ok, err := fsa.Get(ctx, long)
+	Assert(t, NoError(err), "long key read")
+	Assert(t, True(ok), "long key exists")
+	Assert(t, Equal(string(value), "a"), "long key value")
+
+	fsb, err := wait.NewFileBucketStore(td.String())
+	Assert(t, NoError(err), "second file store created")
+	increment := func(store wait.BucketStore) {
+		for {
+			old, ok, err := store.Get(ctx, "counter")
+			Assert(t, NoError(err), "counter read")
+			n := 0
+			if ok {
+				n, _ = strconv.Atoi(string(old))
+			} else {
+				old = nil
+			}
+			swapped, err := store.CompareAndSwap(ctx, "counter", old, []byte(strconv.Itoa(n+1)), time.Minute)
+			Assert(t, NoError(err), "counter swapped")
+			if swapped {
+				return
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for _, store := range []wait.BucketStore{fsa, fsb, fsa, fsb} {
+		wg.Add(1)
+		go func(store wait.BucketStore) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				increment(store)
+			}
+		}(store)
+	}
+	wg.Wait()
+	value, ok, err = fsb.Get(ctx, "counter")
+	Assert(t, NoError(err), "counter read")
+	Assert(t, True(ok), "counter exists")
+	Assert(t, Equal(string(value), "100"), "no lost updates")
+}
+
+// TestStoreThrottle verifies throttles sharing one quota in a store.
+func TestStoreThrottle(t *testing.T) {
+	td, err := qaenv.MkdirTemp("storethrottle")
+	Assert(t, NoError(err), "temporary directory created")
+	defer td.Restore()
+	fs, err := wait.NewFileBucketStore(td.String())
+	Assert(t, NoError(err), "file store created")
+
+	stores := map[string]wait.BucketStore{
+		"memory": wait.NewMemoryBucketStore(),
+		"file":   fs,
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			throttles := []*wait.StoreThrottle{
+				wait.NewStoreThrottle(store, "quota", 20, 1),
+				wait.NewStoreThrottle(store, "quota", 20, 1),
+			}
+			var mu sync.Mutex
+			count := 0
+			task := func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				count++
+				return nil
+			}
+			var wg sync.WaitGroup
+			start := time.Now()
+			for _, throttle := range throttles {
+				wg.Add(1)
+				go func(throttle *wait.StoreThrottle) {
+					defer wg.Done()
+					for i := 0; i < 5; i++ {
+						Assert(t, NoError(throttle.Process(ctx, task)), "task processed")
+					}
+				}(throttle)
+			}
+			wg.Wait()
+			Assert(t, Equal(count, 10), "all tasks processed")
+			Assert(t, Range(time.Since(start), 400*time.Millisecond, 700*time.Millisecond), "shared quota")
+		})
+	}
+
+	// Cancelled context while waiting.
+	throttle := wait.NewStoreThrottle(wait.NewMemoryBucketStore(), "quota", 1, 1)
+	Assert(t, NoError(throttle.Process(ctx, func() error { return nil })), "first task processed")
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = throttle.Process(cctx, func() error { return nil })
+	Assert(t, ErrorContains(err, "deadline exceeded"), "context timeout")
+}
+
+// EOF
@@ -34,12 +34,43 @@ type TickerFunc func(ctx context.Context) <-chan struct{}
 // case the bool return value is false the ticker will stop.
 type TickChangerFunc func(in time.Duration) (out time.Duration, ok bool)
 
+// TickerOption defines the signature of a ticker option setting function.
+type TickerOption func(to *tickerOptions)
+
+// tickerOptions contains the configuration of a ticker.
+type tickerOptions struct {
+	clock Clock
+}
+
+// WithClock sets the Clock used by a ticker. By default it's
+// the real clock.
+func WithClock(clock Clock) TickerOption {
+	return func(to *tickerOptions) {
+		to.clock = clock
+	}
+}
+
+// newTickerOptions applies the options to the defaults.
+func newTickerOptions(options []TickerOption) *tickerOptions {
+	to := &tickerOptions{
+		clock: RealClock(),
+	}
+	for _, option := range options {
+		option(to)
+	}
+	if to.clock == nil {
+		to.clock = RealClock()
+	}
+	return to
+}
+
 // MakeGenericIntervalTicker is a factory for tickers based on time
 // intervals. The given changer is responsible for the intervals and
 // if the ticker shall signal a stopping. The changer is called initially
 // with a duration of zero to allow the changer stopping the ticker even
 // before a first tick.
-func MakeGenericIntervalTicker(changer TickChangerFunc) TickerFunc {
+func MakeGenericIntervalTicker(changer TickChangerFunc, options ...TickerOption) TickerFunc {
+	to := newTickerOptions(options)
 	return func(ctx context.Context) <-chan struct{} {
 		tickc := make(chan struct{})
 		interval := 0 * time.Millisecond
@@ -51,12 +82,12 @@ func MakeGenericIntervalTicker(changer TickChangerFunc) TickerFunc {
 				return
 			}
 			// TickerFunc for the interval.
-			timer := time.NewTimer(interval)
+			timer := to.clock.NewTimer(interval)
 			defer timer.Stop()
 			// Loop sending signals.
 			for {
 				select {
-				case <-timer.C:
+				case <-timer.C():
 					// One interval tick. Ignore if needed.
 					select {
 					case tickc <- struct{}{}:
@@ -78,16 +109,16 @@ func MakeGenericIntervalTicker(changer TickChangerFunc) TickerFunc {
 }
 
 // MakeIntervalTicker returns a ticker signalling in intervals.
-func MakeIntervalTicker(interval time.Duration) TickerFunc {
+func MakeIntervalTicker(interval time.Duration, options ...TickerOption) TickerFunc {
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeMaxIntervalsTicker returns a ticker signalling in intervals. It
 // stops after a maximum number of signals.
-func MakeMaxIntervalsTicker(interval time.Duration, max int) TickerFunc {
+func MakeMaxIntervalsTicker(interval time.Duration, max int, options ...TickerOption) TickerFunc {
 	count := 0
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
 		count++
@@ -96,42 +127,45 @@ func MakeMaxIntervalsTicker(interval time.Duration, max int) TickerFunc {
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeDeadlinedIntervalTicker returns a ticker signalling in intervals
 // and stopping after a deadline.
-func MakeDeadlinedIntervalTicker(interval time.Duration, deadline time.Time) TickerFunc {
+func MakeDeadlinedIntervalTicker(interval time.Duration, deadline time.Time, options ...TickerOption) TickerFunc {
+	clock := newTickerOptions(options).clock
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
-		if time.Now().After(deadline) {
+		if clock.Now().After(deadline) {
 			return 0, false
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeExpiringIntervalTicker returns a ticker signalling in intervals
 // and stopping after a timeout.
-func MakeExpiringIntervalTicker(interval, timeout time.Duration) TickerFunc {
-	deadline := time.Now().Add(timeout)
+func MakeExpiringIntervalTicker(interval, timeout time.Duration, options ...TickerOption) TickerFunc {
+	clock := newTickerOptions(options).clock
+	deadline := clock.Now().Add(timeout)
 	changer := func(_ time.Duration) (out time.Duration, ok bool) {
-		if time.Now().After(deadline) {
+		if clock.Now().After(deadline) {
 			return 0, false
 		}
 		return interval, true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // MakeJitteringTicker returns a ticker signalling in jittering intervals. This
 // avoids converging on periadoc behavior during condition check. The returned
 // intervals jitter between the given interval and interval + factor * interval.
 // The ticker stops after reaching timeout.
-func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Duration) TickerFunc {
-	deadline := time.Now().Add(timeout)
+func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Duration, options ...TickerOption) TickerFunc {
+	clock := newTickerOptions(options).clock
+	deadline := clock.Now().Add(timeout)
 	changer := func(_ time.Duration) (time.Duration, bool) {
-		if time.Now().After(deadline) {
+		if clock.Now().After(deadline) {
 			return 0, false
 		}
 		if factor <= 0.0 {
@@ -139,7 +173,7 @@ func MakeJitteringTicker(interval time.Duration, factor float64, timeout time.Du
 		}
 		return interval + time.Duration(rand.Float64()*factor*float64(interval)), true
 	}
-	return MakeGenericIntervalTicker(changer)
+	return MakeGenericIntervalTicker(changer, options...)
 }
 
 // EOF